@@ -0,0 +1,126 @@
+package client
+
+import (
+	"fmt"
+	"io"
+	"math/bits"
+
+	"github.com/Rahul6700/Foodo/shared"
+)
+
+// Chunking is how Upload cuts files into chunks, see WithContentDefinedChunking
+type Chunking = shared.Chunking
+
+// fixed size chunks are simple, but inserting a single byte at the start of a file moves every boundary after it,
+// so none of the edited file's chunks match the old ones and dedup saves nothing.
+// content defined chunking (FastCDC) puts the boundaries where a rolling hash of the last bytes matches a mask instead,
+// an edit only changes the chunks around it and the boundaries after it come back where they were
+
+// cutter returns the size of the chunk that starts at data[0]
+// data holds MaxSize bytes, fewer only at the end of the file
+type cutter func(data []byte) int
+
+func newCutter(chunking Chunking) cutter {
+	if chunking.Algorithm == shared.ChunkerFastCDC {
+		return fastCDC(chunking)
+	}
+	return func(data []byte) int {
+		return min(len(data), chunking.MaxSize)
+	}
+}
+
+// checkChunking makes sure Upload can cut chunks with chunking
+func checkChunking(chunking Chunking) error {
+	switch chunking.Algorithm {
+	case shared.ChunkerFixed, shared.ChunkerFastCDC:
+	default:
+		return fmt.Errorf("unknown chunker %q", chunking.Algorithm)
+	}
+	if chunking.MinSize < 1 || chunking.MinSize > chunking.AvgSize || chunking.AvgSize > chunking.MaxSize {
+		return fmt.Errorf("bad chunk sizes %d/%d/%d, want 0 < min <= avg <= max", chunking.MinSize, chunking.AvgSize, chunking.MaxSize)
+	}
+	return nil
+}
+
+// fastCDC cuts chunks the FastCDC way: no boundary in the first MinSize bytes, then up to AvgSize a harder mask
+// (one more bit) and after it an easier one (one bit less), which keeps most chunks close to AvgSize, and a forced cut at MaxSize
+func fastCDC(chunking Chunking) cutter {
+	avgBits := bits.Len(uint(chunking.AvgSize)) - 1
+	// the mask bits are taken from the top of the hash, those depend on the last 64 bytes, the low ones only on the last few
+	maskHard := ^uint64(0) << (64 - min(avgBits+1, 63))
+	maskEasy := ^uint64(0) << (64 - max(avgBits-1, 1))
+
+	return func(data []byte) int {
+		n := min(len(data), chunking.MaxSize)
+		if n <= chunking.MinSize {
+			return n
+		}
+		normal := min(n, chunking.AvgSize)
+
+		var hash uint64
+		i := chunking.MinSize
+		for ; i < normal; i++ {
+			hash = hash<<1 + gear[data[i]]
+			if hash&maskHard == 0 {
+				return i + 1
+			}
+		}
+		for ; i < n; i++ {
+			hash = hash<<1 + gear[data[i]]
+			if hash&maskEasy == 0 {
+				return i + 1
+			}
+		}
+		return n
+	}
+}
+
+// gear maps every byte to a random 64 bit value for the rolling hash
+// it is generated from a fixed seed and must never change, chunk boundaries (and with them dedup against every file
+// already stored) depend on it
+var gear = func() [256]uint64 {
+	var table [256]uint64
+	state := uint64(0x466f6f646f434443) // "FoodoCDC"
+	for i := range table {
+		// splitmix64
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}()
+
+// hashChunks reads src one chunk at a time and returns the ID (sha1) and size of every chunk, the data itself is dropped
+// at most chunking.MaxSize bytes are held at once, the window is refilled after every cut
+// the chunks are encoded first (compressed, encrypted, see chunkEncoder), the IDs and sizes are the ones of the stored chunks
+func hashChunks(src *io.SectionReader, chunking Chunking, enc chunkEncoder) ([]shared.ClientChunk, error) {
+	cut := newCutter(chunking)
+	var chunks []shared.ClientChunk
+	window := make([]byte, chunking.MaxSize)
+	r := io.NewSectionReader(src, 0, src.Size())
+	filled, eof := 0, false
+	for index := 0; ; index++ {
+		// ReadFull so the cutter always sees a full window, no matter how the reads get split
+		if !eof {
+			n, err := io.ReadFull(r, window[filled:])
+			filled += n
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				eof = true
+			} else if err != nil {
+				return nil, err
+			}
+		}
+		if filled == 0 {
+			return chunks, nil
+		}
+		size := cut(window[:filled])
+		_, chunk, err := enc.encode(index, window[:size])
+		if err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, chunk)
+		filled = copy(window, window[size:filled])
+	}
+}
@@ -0,0 +1,150 @@
+package client
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/Rahul6700/Foodo/shared"
+)
+
+func cdc(minSize, avgSize, maxSize int) Chunking {
+	return Chunking{Algorithm: shared.ChunkerFastCDC, MinSize: minSize, AvgSize: avgSize, MaxSize: maxSize}
+}
+
+func fixed(size int) Chunking {
+	return Chunking{Algorithm: shared.ChunkerFixed, MinSize: size, AvgSize: size, MaxSize: size}
+}
+
+// boundaries returns the offset every chunk of data ends at, as Upload would cut it
+func boundaries(t *testing.T, data []byte, chunking Chunking) []int64 {
+	t.Helper()
+	chunks, err := hashChunks(io.NewSectionReader(bytes.NewReader(data), 0, int64(len(data))), chunking, chunkEncoder{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ends []int64
+	var end int64
+	for _, chunk := range chunks {
+		end += chunk.Size
+		ends = append(ends, end)
+	}
+	return ends
+}
+
+func seededData(seed int64, n int) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+func TestCheckChunking(t *testing.T) {
+	tests := []struct {
+		name     string
+		chunking Chunking
+		ok       bool
+	}{
+		{"fixed", fixed(1024), true},
+		{"fastcdc", cdc(256, 1024, 4096), true},
+		{"all the same", cdc(1024, 1024, 1024), true},
+		{"unknown chunker", Chunking{Algorithm: "rabin", MinSize: 1, AvgSize: 2, MaxSize: 3}, false},
+		{"zero min", cdc(0, 1024, 4096), false},
+		{"min over avg", cdc(2048, 1024, 4096), false},
+		{"avg over max", cdc(256, 8192, 4096), false},
+	}
+	for _, tt := range tests {
+		if err := checkChunking(tt.chunking); (err == nil) != tt.ok {
+			t.Errorf("%s: checkChunking = %v, want ok %t", tt.name, err, tt.ok)
+		}
+	}
+}
+
+// every chunk is between MinSize and MaxSize bytes, only the last one can be shorter, and together they are the file
+func TestChunkSizes(t *testing.T) {
+	tests := []struct {
+		name     string
+		chunking Chunking
+		size     int
+		zeros    bool // the rolling hash never matches, every chunk is cut at MaxSize
+	}{
+		{"fixed", fixed(1000), 10500, false},
+		{"fixed exact", fixed(1000), 3000, false},
+		{"fastcdc", cdc(512, 2048, 8192), 300000, false},
+		{"fastcdc narrow", cdc(1000, 1024, 1100), 100000, false},
+		{"fastcdc small file", cdc(512, 2048, 8192), 100, false},
+		{"fastcdc empty", cdc(512, 2048, 8192), 0, false},
+		{"fastcdc zeros", cdc(512, 2048, 8192), 50000, true},
+	}
+	for _, tt := range tests {
+		data := seededData(1, tt.size)
+		if tt.zeros {
+			data = make([]byte, tt.size)
+		}
+		ends := boundaries(t, data, tt.chunking)
+
+		var start int64
+		for i, end := range ends {
+			size := end - start
+			last := i == len(ends)-1
+			if size > int64(tt.chunking.MaxSize) || size < 1 || (!last && size < int64(tt.chunking.MinSize)) {
+				t.Fatalf("%s: chunk %d is %d bytes, want %d to %d", tt.name, i, size, tt.chunking.MinSize, tt.chunking.MaxSize)
+			}
+			start = end
+		}
+		if start != int64(len(data)) {
+			t.Fatalf("%s: chunks add up to %d bytes, the file has %d", tt.name, start, len(data))
+		}
+	}
+}
+
+// the average chunk of random data lands near AvgSize
+func TestChunkAverage(t *testing.T) {
+	chunking := cdc(1024, 4096, 16384)
+	data := seededData(2, 4<<20)
+	ends := boundaries(t, data, chunking)
+	avg := len(data) / len(ends)
+	if avg < chunking.AvgSize/2 || avg > chunking.AvgSize*2 {
+		t.Errorf("average chunk is %d bytes, want about %d", avg, chunking.AvgSize)
+	}
+}
+
+// inserting bytes only changes the chunks around the edit, the boundaries after it come back where they were
+func TestBoundariesSurviveInsertions(t *testing.T) {
+	chunking := cdc(512, 2048, 8192)
+	original := seededData(3, 1<<20)
+	tests := []struct {
+		name   string
+		at     int
+		insert []byte
+	}{
+		{"one byte at the start", 0, []byte{42}},
+		{"one byte in the middle", len(original) / 2, []byte{42}},
+		{"a block in the middle", 300000, seededData(4, 5000)},
+		{"a block near the end", len(original) - 20000, seededData(5, 777)},
+	}
+	before := boundaries(t, original, chunking)
+	for _, tt := range tests {
+		edited := append(append(append([]byte(nil), original[:tt.at]...), tt.insert...), original[tt.at:]...)
+		after := make(map[int64]bool)
+		for _, end := range boundaries(t, edited, chunking) {
+			after[end] = true
+		}
+
+		// the boundaries a few max sized chunks past the edit have to be back, shifted by what was inserted
+		resync := int64(tt.at + 4*chunking.MaxSize)
+		for _, end := range before {
+			if end > resync && !after[end+int64(len(tt.insert))] {
+				t.Errorf("%s: the boundary at %d is gone", tt.name, end)
+				break
+			}
+		}
+		// and the ones before the chunk with the edit stay where they are
+		for _, end := range before {
+			if end < int64(tt.at)-int64(chunking.MaxSize) && !after[end] {
+				t.Errorf("%s: the boundary at %d moved", tt.name, end)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,246 @@
+// Package client is the Go client for a Foodo cluster, it talks to the load balancer for metadata
+// and straight to the datanodes for chunk data
+//
+//	c := client.New(client.WithLBAddress("http://localhost:8000"))
+//	err := c.Upload(ctx, "/a/x.txt", file)
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Rahul6700/Foodo/shared"
+)
+
+// defaults for everything an Option can change
+const (
+	DefaultLBAddress    = "http://localhost:8000"
+	DefaultChunkSize    = 2 * 1024 * 1024
+	DefaultTimeout      = 30 * time.Second
+	DefaultChunkTimeout = 30 * time.Second
+	DefaultMaxInFlight  = 8
+)
+
+// Client uploads, downloads and manages files in a Foodo cluster, it is safe to use from several goroutines
+type Client struct {
+	lbAddress    string
+	chunkSize    int
+	replication  int // 0 -> the LB's -replication
+	maxInFlight  int
+	httpClient   *http.Client
+	timeout      time.Duration // per request to the LB
+	chunkTimeout time.Duration // per chunk request to a DN
+	logger       *log.Logger
+	journalDir   string         // "" -> uploads are not journaled, and can't be resumed
+	erasure      *ErasureCoding // nil -> uploads are replicated
+	policy       string         // "" -> replication and erasure decide
+	cdc          *Chunking      // nil -> fixed chunkSize chunks
+	masterKey    []byte         // nil -> uploads are not encrypted, and encrypted files can't be read
+	compression  string         // "" -> chunks are uploaded uncompressed
+	consistency  string         // "" -> the namenodes' default (lease)
+}
+
+// Option changes one setting of a Client, see New
+type Option func(*Client)
+
+// WithLBAddress sets the load balancer the client talks to -> "http://localhost:8000"
+func WithLBAddress(addr string) Option {
+	return func(c *Client) { c.lbAddress = strings.TrimRight(addr, "/") }
+}
+
+// WithChunkSize sets how big the chunks of uploaded files are
+// files uploaded with different chunk sizes never share chunks, so dedup only works between files of the same chunk size
+func WithChunkSize(size int) Option {
+	return func(c *Client) { c.chunkSize = size }
+}
+
+// WithContentDefinedChunking cuts uploaded files with FastCDC instead of into fixed chunkSize chunks:
+// chunks end where the data says so, between minSize and maxSize bytes and avgSize on average,
+// so inserting or removing bytes in a file only changes the chunks around the edit and the rest still dedups
+// against the old version. maxSize bytes are held in memory per upload while cutting.
+// FastCDC's usual sizes are avgSize/4 and avgSize*4 around avgSize, 0 avgSize turns it off again
+func WithContentDefinedChunking(minSize, avgSize, maxSize int) Option {
+	return func(c *Client) {
+		c.cdc = nil
+		if avgSize > 0 {
+			c.cdc = &Chunking{Algorithm: shared.ChunkerFastCDC, MinSize: minSize, AvgSize: avgSize, MaxSize: maxSize}
+		}
+	}
+}
+
+// WithMasterKey encrypts uploaded files, and decrypts encrypted files on download, with key wrapping their data keys
+// (MasterKeySize bytes). every file gets its own random data key, its chunks are sealed with AES-256-GCM before they
+// are uploaded, so the datanodes (and anybody reading chunks off them) never see the data. encrypted files don't dedup
+// at all, not against other files and not within themselves. losing the master key loses every file encrypted with it,
+// the cluster has no copy
+func WithMasterKey(key []byte) Option {
+	return func(c *Client) { c.masterKey = key }
+}
+
+// WithCompression compresses every chunk of uploaded files with codec (shared.CodecGzip) before it is uploaded,
+// chunks that barely shrink (already compressed data) are stored as they are. "" turns it off again
+// downloads decompress on their own, with or without this option
+func WithCompression(codec string) Option {
+	return func(c *Client) { c.compression = codec }
+}
+
+// WithReadConsistency sets how fresh Stat, List, ListFiles and the chunk lists of downloads have to be:
+// shared.ConsistencyLinearizable, shared.ConsistencyLease (the default) or shared.ConsistencyStale.
+// stale reads are answered by any namenode, so they take load off the leader but can miss the latest changes,
+// and the pages of one ListFiles can come from different namenodes
+func WithReadConsistency(consistency string) Option {
+	return func(c *Client) { c.consistency = consistency }
+}
+
+// WithReplication keeps this many replicas of every chunk of uploaded files instead of the LB's default
+// the files remember it, SetReplication changes it later
+func WithReplication(n int) Option {
+	return func(c *Client) { c.replication = n }
+}
+
+// WithMaxInFlight sets how many chunks are uploaded or downloaded at the same time,
+// memory use is about maxInFlight * chunk size
+func WithMaxInFlight(n int) Option {
+	return func(c *Client) { c.maxInFlight = n }
+}
+
+// WithHTTPClient sets the http.Client used for every request, its own Timeout (if any) applies on top of ours
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.httpClient = hc }
+}
+
+// WithTimeout sets how long a single request to the LB may take, 0 means no limit
+func WithTimeout(d time.Duration) Option {
+	return func(c *Client) { c.timeout = d }
+}
+
+// WithChunkTimeout sets how long a single chunk upload or download from one DN may take, 0 means no limit
+// a hung datanode should not hang the whole transfer
+func WithChunkTimeout(d time.Duration) Option {
+	return func(c *Client) { c.chunkTimeout = d }
+}
+
+// WithLogger makes the client log its progress (retries, skipped chunks, ...), by default it is silent
+func WithLogger(l *log.Logger) Option {
+	return func(c *Client) { c.logger = l }
+}
+
+// WithJournalDir keeps a small journal of every upload in progress in dir, so an upload that died halfway
+// (crash, lost connection, ...) is resumed by the next Upload of the same data to the same name
+func WithJournalDir(dir string) Option {
+	return func(c *Client) { c.journalDir = dir }
+}
+
+// WithErasureCoding stores uploaded files erasure coded instead of replicated: every dataShards chunks get
+// parityShards parity chunks, and each of those shards is stored once on its own datanode (the cluster needs that many)
+// a file survives losing any parityShards datanodes per stripe for 1 + parityShards/dataShards times its size,
+// against (replication) times its size for full copies, at the price of rebuilding lost chunks while reading
+// RS 6+3 (WithErasureCoding(6, 3)) is a good fit for cold data. 0 data shards turns it off again
+func WithErasureCoding(dataShards, parityShards int) Option {
+	return func(c *Client) {
+		c.erasure = nil
+		if dataShards > 0 {
+			c.erasure = &ErasureCoding{DataShards: dataShards, ParityShards: parityShards}
+		}
+	}
+}
+
+// WithPolicy uploads files with a named storage policy ("hot-3x", "cold-ec", see shared.StoragePolicies)
+// instead of WithReplication and WithErasureCoding, the files remember it and SetPolicy changes it later
+func WithPolicy(name string) Option {
+	return func(c *Client) { c.policy = name }
+}
+
+// New returns a client with the defaults above, changed by opts
+func New(opts ...Option) *Client {
+	c := &Client{
+		lbAddress:    DefaultLBAddress,
+		chunkSize:    DefaultChunkSize,
+		maxInFlight:  DefaultMaxInFlight,
+		httpClient:   http.DefaultClient,
+		timeout:      DefaultTimeout,
+		chunkTimeout: DefaultChunkTimeout,
+		logger:       log.New(io.Discard, "", 0),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.chunkSize <= 0 {
+		c.chunkSize = DefaultChunkSize
+	}
+	if c.maxInFlight <= 0 {
+		c.maxInFlight = DefaultMaxInFlight
+	}
+	return c
+}
+
+// chunking is how Upload cuts files, fixed chunkSize chunks unless content defined chunking is on
+func (c *Client) chunking() Chunking {
+	if c.cdc != nil {
+		return *c.cdc
+	}
+	return Chunking{Algorithm: shared.ChunkerFixed, MinSize: c.chunkSize, AvgSize: c.chunkSize, MaxSize: c.chunkSize}
+}
+
+// readQuery adds the read consistency (if one is set) to the query of a metadata read
+func (c *Client) readQuery(query url.Values) url.Values {
+	if c.consistency != "" {
+		query.Set("consistency", c.consistency)
+	}
+	return query
+}
+
+func (c *Client) logf(format string, args ...any) {
+	c.logger.Printf(format, args...)
+}
+
+// withTimeout puts the timeout d (if any) on ctx
+func withTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, d)
+}
+
+// callLB sends one request to the LB and decodes its JSON answer into out (if out isn't nil)
+// anything but a 200 comes back as an *Error
+func (c *Client) callLB(ctx context.Context, method, route string, query url.Values, body io.Reader, out any) error {
+	ctx, cancel := withTimeout(ctx, c.timeout)
+	defer cancel()
+
+	reqURL := c.lbAddress + route
+	if len(query) > 0 {
+		reqURL += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, reqURL, body)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s %s: %w", method, route, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return newError(method+" "+route, resp)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("%s %s: failed to decode response: %w", method, route, err)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package client
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/Rahul6700/Foodo/shared"
+)
+
+// compressed uploads (WithCompression) -> every chunk is gzipped on its own before it is uploaded, so any chunk can
+// still be read without the ones before it. the codec and the size before compression go into the chunk metadata,
+// offsets in the file (range reads, File) come from those, and a chunk is always fetched whole and cut after decompressing.
+// chunks that don't get at least 1/minCompressionSaving smaller are stored raw, so already compressed data
+// (media, archives) only costs the try. encrypted files are compressed before they are sealed, ciphertext doesn't compress
+
+// compressed chunks have to save at least 1/minCompressionSaving of the data (12.5%), or the chunk is stored raw
+const minCompressionSaving = 8
+
+// chunkEncoder turns pieces of the file into chunks as they are stored: compressed (if it pays off) and then sealed
+type chunkEncoder struct {
+	codec string      // "" -> no compression
+	key   *fileCipher // nil -> not encrypted
+}
+
+// encode returns chunk index (data) as it is stored, and its entry in the chunk list
+// it is deterministic, chunkReader encodes every chunk a second time and has to get the same bytes
+func (e chunkEncoder) encode(index int, data []byte) ([]byte, shared.ClientChunk, error) {
+	chunk := shared.ClientChunk{Index: index}
+	stored := data
+	if e.codec != "" {
+		compressed, err := compressChunk(e.codec, data)
+		if err != nil {
+			return nil, chunk, err
+		}
+		if len(compressed) <= len(data)-len(data)/minCompressionSaving {
+			stored = compressed
+			chunk.Codec, chunk.RawSize = e.codec, int64(len(data))
+		}
+	}
+	if e.key != nil {
+		stored = e.key.seal(index, stored)
+	}
+	chunk.ChunkID = sha1sum(stored)
+	chunk.Size = int64(len(stored))
+	return stored, chunk, nil
+}
+
+// rawSize is how many bytes of the file a chunk from encode holds
+func (e chunkEncoder) rawSize(chunk shared.ClientChunk) int64 {
+	if chunk.Codec != "" {
+		return chunk.RawSize
+	}
+	return dataSize(chunk.Size, e.key)
+}
+
+func checkCodec(codec string) error {
+	switch codec {
+	case "", shared.CodecGzip:
+		return nil
+	}
+	return fmt.Errorf("unknown codec %q", codec)
+}
+
+func compressChunk(codec string, data []byte) ([]byte, error) {
+	if codec != shared.CodecGzip {
+		return nil, fmt.Errorf("unknown codec %q", codec)
+	}
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decompressChunk undoes compressChunk, the data has to come out rawSize bytes long
+func decompressChunk(codec string, data []byte, rawSize int64) ([]byte, error) {
+	if codec != shared.CodecGzip {
+		return nil, fmt.Errorf("unknown codec %q", codec)
+	}
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	raw, err := io.ReadAll(io.LimitReader(r, rawSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(raw)) != rawSize {
+		return nil, fmt.Errorf("decompressed to %d bytes, want %d", len(raw), rawSize)
+	}
+	return raw, nil
+}
@@ -0,0 +1,174 @@
+package client
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/Rahul6700/Foodo/shared"
+)
+
+// decodeChunk undoes chunkEncoder.encode the way a download does (fetchChunk): open, then decompress
+func decodeChunk(t *testing.T, enc chunkEncoder, index int, stored []byte, chunk shared.ClientChunk) []byte {
+	t.Helper()
+	data := stored
+	if enc.key != nil {
+		var err error
+		if data, err = enc.key.open(index, data); err != nil {
+			t.Fatalf("chunk %d doesn't open: %v", index, err)
+		}
+	}
+	if chunk.Codec != "" {
+		var err error
+		if data, err = decompressChunk(chunk.Codec, data, chunk.RawSize); err != nil {
+			t.Fatalf("chunk %d doesn't decompress: %v", index, err)
+		}
+	}
+	return data
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	key, _, err := newFileCipher(seededData(7, MasterKeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := []byte(strings.Repeat("timestamp,level,message\n2026-10-17,INFO,all good\n", 400))
+	random := seededData(8, 20000)
+
+	tests := []struct {
+		name       string
+		enc        chunkEncoder
+		data       []byte
+		compressed bool // whether the chunk should end up stored compressed
+	}{
+		{"plain", chunkEncoder{}, text, false},
+		{"gzip text", chunkEncoder{codec: shared.CodecGzip}, text, true},
+		{"gzip random", chunkEncoder{codec: shared.CodecGzip}, random, false},
+		{"encrypted", chunkEncoder{key: key}, text, false},
+		{"gzip and encrypted text", chunkEncoder{codec: shared.CodecGzip, key: key}, text, true},
+		{"gzip and encrypted random", chunkEncoder{codec: shared.CodecGzip, key: key}, random, false},
+		{"one byte", chunkEncoder{codec: shared.CodecGzip, key: key}, []byte{1}, false},
+	}
+	for _, tt := range tests {
+		for _, index := range []int{0, 1, 1000} {
+			stored, chunk, err := tt.enc.encode(index, tt.data)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			if chunk.Index != index || chunk.ChunkID != sha1sum(stored) || chunk.Size != int64(len(stored)) {
+				t.Fatalf("%s: chunk %+v doesn't describe the %d stored bytes", tt.name, chunk, len(stored))
+			}
+			if (chunk.Codec != "") != tt.compressed {
+				t.Fatalf("%s: stored with codec %q, want compressed %t", tt.name, chunk.Codec, tt.compressed)
+			}
+			if tt.compressed && chunk.Size >= int64(len(tt.data)) {
+				t.Fatalf("%s: compressed %d bytes into %d", tt.name, len(tt.data), chunk.Size)
+			}
+			if got := tt.enc.rawSize(chunk); got != int64(len(tt.data)) {
+				t.Fatalf("%s: rawSize = %d, want %d", tt.name, got, len(tt.data))
+			}
+			if got := decodeChunk(t, tt.enc, index, stored, chunk); !bytes.Equal(got, tt.data) {
+				t.Fatalf("%s: chunk %d came back different", tt.name, index)
+			}
+
+			// hashChunks and chunkReader encode every chunk twice and need the same bytes both times
+			again, _, err := tt.enc.encode(index, tt.data)
+			if err != nil || !bytes.Equal(again, stored) {
+				t.Fatalf("%s: encoding chunk %d twice gave different bytes", tt.name, index)
+			}
+		}
+	}
+}
+
+func TestDecompressChunk(t *testing.T) {
+	data := []byte(strings.Repeat("abc", 1000))
+	compressed, err := compressChunk(shared.CodecGzip, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		codec   string
+		data    []byte
+		rawSize int64
+		ok      bool
+	}{
+		{"gzip", shared.CodecGzip, compressed, int64(len(data)), true},
+		{"too long", shared.CodecGzip, compressed, int64(len(data)) - 1, false},
+		{"too short", shared.CodecGzip, compressed, int64(len(data)) + 1, false},
+		{"not gzip", shared.CodecGzip, data, int64(len(data)), false},
+		{"truncated", shared.CodecGzip, compressed[:len(compressed)/2], int64(len(data)), false},
+		{"unknown codec", "zstd", compressed, int64(len(data)), false},
+	}
+	for _, tt := range tests {
+		got, err := decompressChunk(tt.codec, tt.data, tt.rawSize)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: decompressChunk error = %v, want ok %t", tt.name, err, tt.ok)
+			continue
+		}
+		if tt.ok && !bytes.Equal(got, data) {
+			t.Errorf("%s: decompressed data differs", tt.name)
+		}
+	}
+}
+
+func TestFileCipher(t *testing.T) {
+	master := seededData(9, MasterKeySize)
+	fc, enc, err := newFileCipher(master)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := seededData(10, 4096)
+	sealed := fc.seal(3, block)
+	if len(sealed) != len(block)+sealOverhead {
+		t.Fatalf("sealed %d bytes into %d, want %d more", len(block), len(sealed), sealOverhead)
+	}
+
+	// the same block at another index seals into a different chunk, so encrypted files don't dedup within themselves
+	if bytes.Equal(fc.seal(4, block), sealed) {
+		t.Fatal("the same data sealed the same at two indexes")
+	}
+	// and another file's key seals it differently again
+	other, _, err := newFileCipher(master)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(other.seal(3, block), sealed) {
+		t.Fatal("two files sealed the same data the same")
+	}
+
+	tests := []struct {
+		name   string
+		master []byte
+		enc    *Encryption
+		index  int
+		sealed []byte
+		err    error // nil -> opens, ErrKey -> refused for the key, anything else -> some other failure
+	}{
+		{"right key", master, enc, 3, sealed, nil},
+		{"no key", nil, enc, 3, sealed, ErrKey},
+		{"wrong key", seededData(11, MasterKeySize), enc, 3, sealed, ErrKey},
+		{"short key", master[:16], enc, 3, sealed, errors.New("")},
+		{"wrong index", master, enc, 2, sealed, errors.New("")},
+		{"tampered", master, enc, 3, append([]byte{sealed[0] ^ 1}, sealed[1:]...), errors.New("")},
+		{"unknown cipher", master, &Encryption{Cipher: "rot13"}, 3, sealed, errors.New("")},
+	}
+	for _, tt := range tests {
+		opened, err := openFileCipher(tt.master, tt.enc)
+		var data []byte
+		if err == nil {
+			data, err = opened.open(tt.index, tt.sealed)
+		}
+		switch {
+		case tt.err == nil && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case tt.err == nil && !bytes.Equal(data, block):
+			t.Errorf("%s: opened data differs", tt.name)
+		case tt.err != nil && err == nil:
+			t.Errorf("%s: opened, want an error", tt.name)
+		case errors.Is(tt.err, ErrKey) && !errors.Is(err, ErrKey):
+			t.Errorf("%s: error = %v, want ErrKey", tt.name, err)
+		}
+	}
+}
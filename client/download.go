@@ -0,0 +1,366 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Rahul6700/Foodo/shared"
+)
+
+// ChunkInfo is one chunk of a file, as the LB's /get-file-locations returns it
+type ChunkInfo struct {
+	ChunkID   string   `json:"chunk_id"`
+	Index     int      `json:"chunk_index"`
+	Locations []string `json:"locations"` // best replica first (live, least loaded)
+	Size      int64    `json:"size"`      // 0 for files registered before chunk sizes were recorded
+	Codec     string   `json:"codec"`     // compressed chunks -> shared.CodecGzip, see WithCompression
+	RawSize   int64    `json:"raw_size"`  // compressed chunks -> the size before compression
+
+	stripe *stripeInfo // erasure coded files -> the stripe the chunk is in
+	key    *fileCipher // encrypted files -> what the chunk opens with
+}
+
+// dataSize is how many bytes of the file the chunk holds, RawSize for compressed chunks,
+// Size minus the GCM tag for encrypted ones
+func (ch ChunkInfo) dataSize() int64 {
+	switch {
+	case ch.Codec != "":
+		return ch.RawSize
+	case ch.Size > 0:
+		return dataSize(ch.Size, ch.key)
+	}
+	return ch.Size
+}
+
+// encoded reports whether the chunk is stored compressed or encrypted, it can only be decoded whole then
+func (ch ChunkInfo) encoded() bool {
+	return ch.key != nil || ch.Codec != ""
+}
+
+// Download writes the file name to w
+// up to maxInFlight chunks are fetched at the same time, but they are written to w in order, so w needs no seeking
+func (c *Client) Download(ctx context.Context, name string, w io.Writer) error {
+	chunks, err := c.Chunks(ctx, name)
+	if err != nil {
+		return err
+	}
+	spans := make([]ChunkSpan, len(chunks))
+	for i, chunk := range chunks {
+		spans[i] = ChunkSpan{Chunk: chunk, Length: -1}
+	}
+	_, err = c.copySpans(ctx, name, spans, w)
+	return err
+}
+
+// ReadRange writes length bytes of the file name, starting at offset, to w and returns how many it wrote
+// a negative length reads to the end of the file
+// only the chunks covering the range are fetched, and of the first and last one only the bytes that are needed
+func (c *Client) ReadRange(ctx context.Context, name string, offset, length int64, w io.Writer) (int64, error) {
+	if offset < 0 {
+		return 0, &Error{Op: "read " + name, Message: "negative offset", Err: ErrInvalid}
+	}
+	chunks, err := c.Chunks(ctx, name)
+	if err != nil {
+		return 0, err
+	}
+	return c.copySpans(ctx, name, CoveringChunks(chunks, offset, length), w)
+}
+
+// copySpans fetches the spans and writes them to w in order, up to maxInFlight of them at the same time
+func (c *Client) copySpans(ctx context.Context, name string, spans []ChunkSpan, w io.Writer) (int64, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		data []byte
+		err  error
+	}
+	results := make([]chan result, len(spans))
+	for i := range results {
+		results[i] = make(chan result, 1)
+	}
+
+	// a slot is taken before a chunk is fetched and only given back once it has been written,
+	// so at most maxInFlight chunks are ever in memory
+	window := make(chan struct{}, c.maxInFlight)
+	bad := make(chan []shared.BadReplica, len(spans))
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i, span := range spans {
+			select {
+			case window <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			wg.Add(1)
+			go func(i int, span ChunkSpan) {
+				defer wg.Done()
+				data, badReplicas, err := c.fetchChunk(ctx, span)
+				bad <- badReplicas
+				results[i] <- result{data, err}
+			}(i, span)
+		}
+	}()
+
+	var written int64
+	var downloadErr error
+	for i := range spans {
+		var res result
+		select {
+		case res = <-results[i]:
+		case <-ctx.Done():
+			res.err = ctx.Err()
+		}
+		if res.err != nil {
+			downloadErr = res.err
+			break
+		}
+		n, err := w.Write(res.data)
+		written += int64(n)
+		if err != nil {
+			downloadErr = fmt.Errorf("download %s: %w", name, err)
+			break
+		}
+		<-window
+	}
+	cancel()
+	wg.Wait()
+	close(bad)
+
+	// whatever replicas failed us are reported even if the download failed, the cluster can repair them either way
+	var badReplicas []shared.BadReplica
+	for b := range bad {
+		badReplicas = append(badReplicas, b...)
+	}
+	c.reportBadReplicas(badReplicas)
+	return written, downloadErr
+}
+
+// Chunks returns the chunks of the file name sorted by index, with their replicas best first
+// the chunks of an encrypted file come with their key, so it takes the master key it was encrypted with (ErrKey otherwise)
+func (c *Client) Chunks(ctx context.Context, name string) ([]ChunkInfo, error) {
+	var plan struct {
+		Chunks     []ChunkInfo           `json:"chunks"`
+		Erasure    *shared.ErasureCoding `json:"erasure"`
+		Stripes    []shared.StripeStruct `json:"stripes"`
+		Encryption *Encryption           `json:"encryption"`
+	}
+	if err := c.callLB(ctx, http.MethodGet, "/get-file-locations", c.readQuery(url.Values{"filename": {name}}), nil, &plan); err != nil {
+		return nil, err
+	}
+	sort.Slice(plan.Chunks, func(i, j int) bool {
+		return plan.Chunks[i].Index < plan.Chunks[j].Index
+	})
+	if plan.Erasure != nil {
+		if err := attachStripes(plan.Chunks, plan.Erasure, plan.Stripes); err != nil {
+			return nil, &Error{Op: "get chunks of " + name, Message: err.Error(), Err: ErrInvalid}
+		}
+	}
+	if plan.Encryption != nil {
+		key, err := openFileCipher(c.masterKey, plan.Encryption)
+		if err != nil {
+			kind := ErrInvalid
+			if errors.Is(err, ErrKey) {
+				kind = ErrKey
+			}
+			return nil, &Error{Op: "open " + name, Message: err.Error(), Err: kind}
+		}
+		for i := range plan.Chunks {
+			plan.Chunks[i].key = key
+		}
+	}
+	return plan.Chunks, nil
+}
+
+// how many times we go through a chunk's whole replica list before giving up, and how long we wait before the 2nd round
+// (it doubles every round), a replica that is just overloaded or restarting gets a second chance this way
+const downloadRounds = 3
+const downloadBackoff = 500 * time.Millisecond
+
+// fetchChunk gets a chunk (or the part of it in span) from its replicas, see fetchStored
+// an encrypted or compressed chunk can only be decoded whole, so it is always fetched whole and cut after decoding it
+func (c *Client) fetchChunk(ctx context.Context, span ChunkSpan) ([]byte, []shared.BadReplica, error) {
+	ch := span.Chunk
+	if !ch.encoded() {
+		return c.fetchStored(ctx, span)
+	}
+	data, bad, err := c.fetchStored(ctx, ChunkSpan{Chunk: ch, Length: -1})
+	if err != nil {
+		return nil, bad, err
+	}
+	// the stored chunk already matched its ID, so these only fail if the key or the metadata is off
+	if ch.key != nil {
+		if data, err = ch.key.open(ch.Index, data); err != nil {
+			return nil, bad, &Error{Op: fmt.Sprintf("open chunk %d (%s)", ch.Index, ch.ChunkID), Message: err.Error(), Err: ErrChunk}
+		}
+	}
+	if ch.Codec != "" {
+		if data, err = decompressChunk(ch.Codec, data, ch.RawSize); err != nil {
+			return nil, bad, &Error{Op: fmt.Sprintf("decompress chunk %d (%s)", ch.Index, ch.ChunkID), Message: err.Error(), Err: ErrChunk}
+		}
+	}
+	if !span.whole() {
+		data = span.cut(data)
+	}
+	return data, bad, nil
+}
+
+// fetchStored gets a chunk as it is stored (or the part of it in span) from its replicas, see fetchReplicas
+// a chunk of an erasure coded file has a single copy, when that one fails we don't wait for it to come back
+// but rebuild the chunk from the rest of its stripe
+func (c *Client) fetchStored(ctx context.Context, span ChunkSpan) ([]byte, []shared.BadReplica, error) {
+	if span.Chunk.stripe == nil {
+		return c.fetchReplicas(ctx, span, downloadRounds)
+	}
+	data, bad, err := c.fetchReplicas(ctx, span, 1)
+	if err == nil || ctx.Err() != nil {
+		return data, bad, err
+	}
+	c.logf("chunk %s is unreadable (%v), rebuilding it from its stripe", span.Chunk.ChunkID, err)
+	data, rebuildBad, err := c.reconstructChunk(ctx, span.Chunk)
+	bad = append(bad, rebuildBad...)
+	if err != nil {
+		return nil, bad, err
+	}
+	if !span.whole() {
+		data = span.cut(data)
+	}
+	return data, bad, nil
+}
+
+// fetchReplicas tries the chunk's replicas in the order the LB gave them (least loaded live DN's first)
+// until one returns bytes that hash to the chunk ID, going over the list again with a backoff if they all fail, rounds times at most
+// it returns the replicas that failed along the way, even when the chunk was downloaded in the end
+// for a span that is only part of the chunk we can't check the hash, we rely on the DN verifying the chunk before serving it
+func (c *Client) fetchReplicas(ctx context.Context, span ChunkSpan, rounds int) ([]byte, []shared.BadReplica, error) {
+	ch := span.Chunk
+	op := "download chunk " + ch.ChunkID
+	if len(ch.Locations) == 0 {
+		return nil, nil, &Error{Op: op, Message: "no locations", Err: ErrChunk}
+	}
+
+	var bad []shared.BadReplica
+	var lastErr error
+	backoff := downloadBackoff
+	for round := 0; round < rounds; round++ {
+		if round > 0 {
+			c.logf("all replicas of chunk %s failed, retrying in %s", ch.ChunkID, backoff)
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return nil, bad, ctx.Err()
+			}
+			backoff *= 2
+		}
+
+		for _, location := range ch.Locations {
+			data, err := c.readChunk(ctx, location, span)
+			if err == nil && span.whole() {
+				// the ID is the sha1 of the content, so a replica that was corrupted on disk or on the wire shows up here
+				if sum := sha1sum(data); sum != ch.ChunkID {
+					err = &replicaError{reason: "corrupt", err: fmt.Errorf("chunk hashes to %s", sum)}
+				}
+			}
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil, bad, ctx.Err()
+				}
+				lastErr = fmt.Errorf("%s: %w", location, err)
+				c.logf("failed to get chunk %s from %s: %v, trying another replica", ch.ChunkID, location, err)
+				// only the first round is reported, retries of the same replica would just repeat it
+				if round == 0 {
+					bad = append(bad, shared.BadReplica{ChunkID: ch.ChunkID, NodeID: location, Reason: replicaErrorReason(err)})
+				}
+				continue
+			}
+			return data, bad, nil
+		}
+	}
+	return nil, bad, &Error{Op: op, Message: lastErr.Error(), Err: ErrChunk}
+}
+
+// readChunk gets one chunk (or the part of it in span) from one Datanode
+func (c *Client) readChunk(ctx context.Context, location string, span ChunkSpan) ([]byte, error) {
+	ctx, cancel := withTimeout(ctx, c.chunkTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/readChunk/%s", location, span.Chunk.ChunkID), nil)
+	if err != nil {
+		return nil, err
+	}
+	if !span.whole() {
+		req.Header.Set("Range", span.rangeHeader())
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, &replicaError{reason: "unreachable", err: err}
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, &replicaError{reason: "missing", err: fmt.Errorf("datanode returned error: %s", resp.Status)}
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		// the span starts past the end of the chunk, only possible for old chunks without a recorded size
+		return nil, nil
+	case resp.StatusCode == http.StatusPartialContent && !span.whole():
+	case resp.StatusCode != http.StatusOK:
+		// the DN refuses to serve a chunk that fails its own checksum with a 500
+		return nil, &replicaError{reason: "error", err: fmt.Errorf("datanode returned error: %s", resp.Status)}
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &replicaError{reason: "unreachable", err: err}
+	}
+	if resp.StatusCode == http.StatusOK && !span.whole() {
+		// a DN that ignored the Range header sent the whole chunk, cut our part out of it
+		data = span.cut(data)
+	}
+	return data, nil
+}
+
+// replicaError remembers why a replica failed, the LB treats "corrupt" and "missing" differently from "unreachable"
+type replicaError struct {
+	reason string
+	err    error
+}
+
+func (e *replicaError) Error() string { return e.reason + ": " + e.err.Error() }
+func (e *replicaError) Unwrap() error { return e.err }
+
+func replicaErrorReason(err error) string {
+	var re *replicaError
+	if errors.As(err, &re) {
+		return re.reason
+	}
+	return "error"
+}
+
+// reportBadReplicas tells the LB which replicas let us down, failing to report is not worth failing the download over
+// it gets its own context, a download that was cancelled should still report what it found
+func (c *Client) reportBadReplicas(bad []shared.BadReplica) {
+	if len(bad) == 0 {
+		return
+	}
+	jsonData, err := json.Marshal(bad)
+	if err != nil {
+		return
+	}
+	if err := c.callLB(context.Background(), http.MethodPost, "/reportBadReplicas", nil, bytes.NewReader(jsonData), nil); err != nil {
+		c.logf("failed to report %d bad replicas: %v", len(bad), err)
+		return
+	}
+	c.logf("reported %d bad replicas to the load balancer", len(bad))
+}
@@ -0,0 +1,146 @@
+package client
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/Rahul6700/Foodo/shared"
+)
+
+// encrypted files (WithMasterKey) -> every file gets a random data key, its chunks are sealed with AES-256-GCM before they
+// leave the client, and the data key is stored in the file's metadata wrapped with the master key (see shared.Encryption)
+// datanodes only ever see sealed chunks, and the chunk IDs are the sha1 of those, so replicas are still checked as usual
+// the keys are random per file and every chunk is sealed with a nonce from its index, so an encrypted chunk never matches
+// any other chunk, of another file or of the same one (two identical blocks seal differently): encrypted files get nothing
+// out of dedup, only a resumed upload still skips the chunks its interrupted attempt stored
+
+// Encryption is what the cluster stores about an encrypted file, see WithMasterKey
+type Encryption = shared.Encryption
+
+// MasterKeySize is the size of a master key, it is an AES-256 key
+const MasterKeySize = 32
+
+// sealOverhead is how much bigger a sealed chunk is than its data, the GCM tag
+const sealOverhead = 16
+
+// what the data key is sealed with, so a wrapped key can't pass for anything else sealed with the master key
+var wrapAAD = []byte("foodo file key")
+
+// fileCipher seals and opens the chunks of one encrypted file
+type fileCipher struct {
+	aead  cipher.AEAD
+	nonce []byte // the file's nonce base
+}
+
+// chunkNonce is the nonce of chunk index, the base with index xored into its last 8 bytes
+// every file has its own key, so nonces only have to be unique within the file
+// it also means the same data at two indexes seals into two different chunks
+func (fc *fileCipher) chunkNonce(index int) []byte {
+	nonce := append([]byte(nil), fc.nonce...)
+	tail := nonce[len(nonce)-8:]
+	binary.BigEndian.PutUint64(tail, binary.BigEndian.Uint64(tail)^uint64(index))
+	return nonce
+}
+
+func (fc *fileCipher) seal(index int, data []byte) []byte {
+	return fc.aead.Seal(nil, fc.chunkNonce(index), data, nil)
+}
+
+func (fc *fileCipher) open(index int, sealed []byte) ([]byte, error) {
+	return fc.aead.Open(nil, fc.chunkNonce(index), sealed, nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// keyID is the fingerprint of a master key that goes with every file it encrypts
+func keyID(masterKey []byte) string {
+	sum := sha256.Sum256(masterKey)
+	return hex.EncodeToString(sum[:8])
+}
+
+func checkMasterKey(masterKey []byte) error {
+	if len(masterKey) != MasterKeySize {
+		return fmt.Errorf("master key must be %d bytes, got %d", MasterKeySize, len(masterKey))
+	}
+	return nil
+}
+
+// newFileCipher makes a new data key and nonce base for a file, and wraps the key with the master key
+func newFileCipher(masterKey []byte) (*fileCipher, *Encryption, error) {
+	if err := checkMasterKey(masterKey); err != nil {
+		return nil, nil, err
+	}
+	wrap, err := newGCM(masterKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	dataKey := make([]byte, 32)
+	wrapNonce := make([]byte, wrap.NonceSize())
+	nonce := make([]byte, 12)
+	for _, b := range [][]byte{dataKey, wrapNonce, nonce} {
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	encryption := &Encryption{
+		Cipher:     shared.CipherAES256GCM,
+		WrappedKey: wrap.Seal(wrapNonce, wrapNonce, dataKey, wrapAAD),
+		Nonce:      nonce,
+		KeyID:      keyID(masterKey),
+	}
+	return &fileCipher{aead: aead, nonce: nonce}, encryption, nil
+}
+
+// openFileCipher unwraps the data key of a file with the master key
+// a missing or different master key is an ErrKey, the file can't be read without the right one
+func openFileCipher(masterKey []byte, encryption *Encryption) (*fileCipher, error) {
+	if encryption.Cipher != shared.CipherAES256GCM {
+		return nil, fmt.Errorf("unknown cipher %q", encryption.Cipher)
+	}
+	if masterKey == nil {
+		return nil, fmt.Errorf("%w: the file is encrypted (key id %s) and no master key is set", ErrKey, encryption.KeyID)
+	}
+	if err := checkMasterKey(masterKey); err != nil {
+		return nil, err
+	}
+	if id := keyID(masterKey); id != encryption.KeyID {
+		return nil, fmt.Errorf("%w: the file is encrypted with key id %s, the master key is %s", ErrKey, encryption.KeyID, id)
+	}
+
+	wrap, err := newGCM(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(encryption.WrappedKey) < wrap.NonceSize() {
+		return nil, fmt.Errorf("wrapped key is too short")
+	}
+	wrapNonce, sealed := encryption.WrappedKey[:wrap.NonceSize()], encryption.WrappedKey[wrap.NonceSize():]
+	dataKey, err := wrap.Open(nil, wrapNonce, sealed, wrapAAD)
+	if err != nil {
+		return nil, fmt.Errorf("%w: the wrapped data key does not open with the master key", ErrKey)
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(encryption.Nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("nonce must be %d bytes, got %d", aead.NonceSize(), len(encryption.Nonce))
+	}
+	return &fileCipher{aead: aead, nonce: encryption.Nonce}, nil
+}
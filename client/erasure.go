@@ -0,0 +1,214 @@
+package client
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Rahul6700/Foodo/erasure"
+	"github.com/Rahul6700/Foodo/shared"
+)
+
+// erasure coded files are cut into chunks as usual, and every DataShards consecutive chunks form a stripe
+// a stripe is encoded with all its shards as big as its biggest chunk, shorter chunks (and the chunks a short last stripe
+// doesn't have) are zero padded for that, the padding is never stored. the parity chunks are named by their sha1 like any chunk
+
+// stripeInfo is a stripe of an erasure coded file, shared by the ChunkInfo of every chunk in it
+type stripeInfo struct {
+	code      *erasure.Code
+	data      []ChunkInfo // the stripe's chunks, can be fewer than DataShards in the last stripe
+	parity    []ChunkInfo
+	shardSize int64
+}
+
+// stripeShardSize is the size every shard of a stripe is padded to, its biggest chunk
+func stripeShardSize(chunks []shared.ClientChunk) int64 {
+	var size int64
+	for _, chunk := range chunks {
+		if chunk.Size > size {
+			size = chunk.Size
+		}
+	}
+	return size
+}
+
+// stripeEncoder reads the stripes of a file (a chunk at a time, with read) and encodes them, one stripe at a time
+type stripeEncoder struct {
+	code   *erasure.Code
+	read   func(i int) ([]byte, error) // reads chunk i as it is stored, see chunkReader
+	chunks []shared.ClientChunk
+
+	stripe int      // the stripe in shards, -1 for none
+	shards [][]byte // its data shards (padded) followed by its parity shards
+}
+
+func newStripeEncoder(layout *shared.ErasureCoding, chunks []shared.ClientChunk, read func(i int) ([]byte, error)) (*stripeEncoder, error) {
+	code, err := erasure.New(layout.DataShards, layout.ParityShards)
+	if err != nil {
+		return nil, &Error{Op: "erasure coding", Message: err.Error(), Err: ErrInvalid}
+	}
+	return &stripeEncoder{code: code, read: read, chunks: chunks, stripe: -1}, nil
+}
+
+func (e *stripeEncoder) stripeCount() int {
+	k := e.code.DataShards()
+	return (len(e.chunks) + k - 1) / k
+}
+
+// stripeChunks returns the chunks of stripe s
+func (e *stripeEncoder) stripeChunks(s int) []shared.ClientChunk {
+	k := e.code.DataShards()
+	last := (s + 1) * k
+	if last > len(e.chunks) {
+		last = len(e.chunks)
+	}
+	return e.chunks[s*k : last]
+}
+
+// encode reads stripe s and computes its parity, the last stripe encoded stays around for shard
+func (e *stripeEncoder) encode(s int) error {
+	if e.stripe == s {
+		return nil
+	}
+	chunks := e.stripeChunks(s)
+	shardSize := stripeShardSize(chunks)
+	shards := make([][]byte, e.code.DataShards()+e.code.ParityShards())
+	for i := 0; i < e.code.DataShards(); i++ {
+		shards[i] = make([]byte, shardSize)
+	}
+	for i, chunk := range chunks {
+		data, err := e.read(s*e.code.DataShards() + i)
+		if err != nil {
+			return fmt.Errorf("failed to read chunk %d: %w", chunk.Index, err)
+		}
+		copy(shards[i], data)
+	}
+	if err := e.code.Encode(shards); err != nil {
+		return err
+	}
+	e.stripe, e.shards = s, shards
+	return nil
+}
+
+// shard returns shard i of stripe s, data shards come back without their padding
+func (e *stripeEncoder) shard(s, i int) ([]byte, error) {
+	if err := e.encode(s); err != nil {
+		return nil, err
+	}
+	chunks := e.stripeChunks(s)
+	if i < len(chunks) {
+		return e.shards[i][:chunks[i].Size], nil
+	}
+	return e.shards[i], nil
+}
+
+// parity computes the parity chunks of every stripe, what the LB needs to plan the upload
+// the file is read once more for this, a stripe at a time
+func (e *stripeEncoder) parity() ([]shared.StripeStruct, error) {
+	stripes := make([]shared.StripeStruct, e.stripeCount())
+	for s := range stripes {
+		if err := e.encode(s); err != nil {
+			return nil, err
+		}
+		stripes[s].Index = s
+		for p, shard := range e.shards[e.code.DataShards():] {
+			stripes[s].Parity = append(stripes[s].Parity, shared.ChunkStruct{
+				ChunkID:    sha1sum(shard),
+				ChunkIndex: p,
+				Size:       int64(len(shard)),
+			})
+		}
+	}
+	return stripes, nil
+}
+
+// attachStripes links every chunk of an erasure coded file to its stripe, so a chunk that can't be read can be rebuilt
+func attachStripes(chunks []ChunkInfo, layout *shared.ErasureCoding, stripes []shared.StripeStruct) error {
+	code, err := erasure.New(layout.DataShards, layout.ParityShards)
+	if err != nil {
+		return err
+	}
+	k := layout.DataShards
+	for _, stripe := range stripes {
+		first, last := stripe.Index*k, (stripe.Index+1)*k
+		if first >= len(chunks) {
+			return fmt.Errorf("stripe %d is past the last chunk", stripe.Index)
+		}
+		if last > len(chunks) {
+			last = len(chunks)
+		}
+		info := &stripeInfo{code: code}
+		for _, parity := range stripe.Parity {
+			info.parity = append(info.parity, ChunkInfo{ChunkID: parity.ChunkID, Index: parity.ChunkIndex, Locations: parity.Locations, Size: parity.Size})
+			if parity.Size > info.shardSize {
+				info.shardSize = parity.Size
+			}
+		}
+		for i := first; i < last; i++ {
+			chunks[i].stripe = info
+			info.data = append(info.data, chunks[i])
+		}
+	}
+	return nil
+}
+
+// reconstructChunk rebuilds a chunk of an erasure coded file that none of its locations could give us
+// out of the other shards of its stripe, it needs any DataShards of them
+func (c *Client) reconstructChunk(ctx context.Context, ch ChunkInfo) ([]byte, []shared.BadReplica, error) {
+	op := "reconstruct chunk " + ch.ChunkID
+	stripe := ch.stripe
+	k := stripe.code.DataShards()
+	shards := make([][]byte, k+stripe.code.ParityShards())
+
+	// the chunks a short last stripe doesn't have are zeros, we have those already
+	have := 0
+	for i := len(stripe.data); i < k; i++ {
+		shards[i] = make([]byte, stripe.shardSize)
+		have++
+	}
+
+	var bad []shared.BadReplica
+	target := -1
+	others := append(append([]ChunkInfo(nil), stripe.data...), stripe.parity...)
+	for i, other := range others {
+		shard := i
+		if i >= len(stripe.data) {
+			shard = k + (i - len(stripe.data))
+		}
+		if i < len(stripe.data) && other.Index == ch.Index {
+			target = shard
+			continue
+		}
+		if have == k {
+			continue
+		}
+		data, badReplicas, err := c.fetchReplicas(ctx, ChunkSpan{Chunk: other, Length: -1}, 1)
+		bad = append(bad, badReplicas...)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, bad, ctx.Err()
+			}
+			continue
+		}
+		padded := make([]byte, stripe.shardSize)
+		copy(padded, data)
+		shards[shard] = padded
+		have++
+	}
+	if target < 0 {
+		return nil, bad, &Error{Op: op, Message: "chunk is not in its stripe", Err: ErrChunk}
+	}
+	if have < k {
+		return nil, bad, &Error{Op: op, Message: fmt.Sprintf("only %d of the %d shards needed are readable", have, k), Err: ErrChunk}
+	}
+
+	shards[target] = nil
+	if err := stripe.code.Reconstruct(shards); err != nil {
+		return nil, bad, &Error{Op: op, Message: err.Error(), Err: ErrChunk}
+	}
+	data := shards[target][:ch.Size]
+	if sum := sha1sum(data); sum != ch.ChunkID {
+		return nil, bad, &Error{Op: op, Message: "rebuilt chunk hashes to " + sum, Err: ErrChunk}
+	}
+	c.logf("rebuilt chunk %s from its stripe", ch.ChunkID)
+	return data, bad, nil
+}
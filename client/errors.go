@@ -0,0 +1,61 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// errors callers can check for with errors.Is, every *Error from the cluster wraps one of them
+var (
+	ErrNotFound    = errors.New("not found")             // the file or directory does not exist
+	ErrExists      = errors.New("already exists")        // e.g. mkdir on a path that is a file
+	ErrInvalid     = errors.New("invalid request")       // the cluster refused the request (bad path, not empty dir, ...)
+	ErrUnavailable = errors.New("cluster unavailable")   // no namenode leader, no live datanodes, ...
+	ErrChunk       = errors.New("chunk transfer failed") // a chunk could not be uploaded to or downloaded from any replica
+	ErrKey         = errors.New("wrong key")             // an encrypted file and no master key, or not the one it was encrypted with
+)
+
+// Error is a failed request to the cluster, with what the LB (or the namenode behind it) said about it
+type Error struct {
+	Op         string // "GET /stat", "upload chunk 9f86d0...", ...
+	StatusCode int    // http status, 0 if the request never got an answer
+	Message    string // the "error" field of the answer, or its body
+	Err        error  // one of the Err* above
+}
+
+func (e *Error) Error() string {
+	if e.StatusCode == 0 {
+		return fmt.Sprintf("%s: %s", e.Op, e.Message)
+	}
+	return fmt.Sprintf("%s: %d %s: %s", e.Op, e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// newError builds the *Error for a non 200 answer, the status code picks which Err* it wraps
+func newError(op string, resp *http.Response) *Error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	message := string(body)
+	var answer struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(body, &answer) == nil && answer.Error != "" {
+		message = answer.Error
+	}
+
+	var kind error
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		kind = ErrNotFound
+	case http.StatusConflict:
+		kind = ErrExists
+	case http.StatusServiceUnavailable, http.StatusBadGateway, http.StatusGatewayTimeout:
+		kind = ErrUnavailable
+	default:
+		kind = ErrInvalid
+	}
+	return &Error{Op: op, StatusCode: resp.StatusCode, Message: message, Err: kind}
+}
@@ -0,0 +1,243 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"sync"
+)
+
+// File is an open file of the cluster, it reads chunks from the datanodes on demand,
+// only the chunks covering the bytes asked for are fetched
+//
+// the chunk list is fetched once when the file is opened, if the file is overwritten or deleted after that
+// reads keep going against the old chunks until the GC removes them from the datanodes
+type File struct {
+	c       *Client
+	ctx     context.Context
+	stat    *FileStat
+	chunks  []ChunkInfo
+	offsets []int64 // where every chunk starts in the file
+
+	lock   sync.Mutex
+	pos    int64  // for Read and Seek
+	cached int    // index of the chunk in data, -1 for none
+	data   []byte // the last chunk we fetched, sequential reads mostly hit it
+}
+
+// OpenFile opens the file name for reading
+func (c *Client) OpenFile(ctx context.Context, name string) (*File, error) {
+	stat, err := c.Stat(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if stat.IsDir {
+		return nil, &Error{Op: "open " + name, Message: "is a directory", Err: ErrInvalid}
+	}
+	return c.openFile(ctx, stat)
+}
+
+// openFile fetches the chunk list of the file stat describes
+func (c *Client) openFile(ctx context.Context, stat *FileStat) (*File, error) {
+	chunks, err := c.Chunks(ctx, stat.Path)
+	if err != nil {
+		return nil, err
+	}
+	return &File{c: c, ctx: ctx, stat: stat, chunks: chunks, offsets: chunkOffsets(chunks), cached: -1}, nil
+}
+
+// chunkOffsets returns where every chunk (sorted by index) starts in the file
+// files registered before chunk sizes were recorded have no sizes, they were all cut into DefaultChunkSize chunks
+func chunkOffsets(chunks []ChunkInfo) []int64 {
+	offsets := make([]int64, len(chunks))
+	var offset int64
+	for i, ch := range chunks {
+		offsets[i] = offset
+		if ch.Size > 0 {
+			offset += ch.dataSize()
+		} else {
+			offset += DefaultChunkSize
+		}
+	}
+	return offsets
+}
+
+// ChunkSpan is the part of one chunk a byte range of its file covers
+type ChunkSpan struct {
+	Chunk  ChunkInfo
+	Offset int64 // where the span starts in the chunk
+	Length int64 // how many bytes of the chunk, -1 for everything from Offset on
+}
+
+// whole reports whether the span is the entire chunk, only then can the data be checked against the chunk ID
+func (s ChunkSpan) whole() bool {
+	return s.Offset == 0 && (s.Length < 0 || (s.Chunk.Size > 0 && s.Length >= s.Chunk.dataSize()))
+}
+
+// rangeHeader is the span as an http Range header -> "bytes=100-199"
+func (s ChunkSpan) rangeHeader() string {
+	if s.Length < 0 {
+		return fmt.Sprintf("bytes=%d-", s.Offset)
+	}
+	return fmt.Sprintf("bytes=%d-%d", s.Offset, s.Offset+s.Length-1)
+}
+
+// cut returns the span's part of the whole chunk's data
+func (s ChunkSpan) cut(data []byte) []byte {
+	if s.Offset >= int64(len(data)) {
+		return nil
+	}
+	data = data[s.Offset:]
+	if s.Length >= 0 && s.Length < int64(len(data)) {
+		data = data[:s.Length]
+	}
+	return data
+}
+
+// CoveringChunks returns the spans of the chunks (sorted by index, as Chunks returns them) that hold
+// the bytes [offset, offset+length) of their file, a negative length covers everything up to the end of the file
+// chunks in the middle of the range are covered whole, the first and last one usually only in part
+func CoveringChunks(chunks []ChunkInfo, offset, length int64) []ChunkSpan {
+	if length == 0 {
+		return nil
+	}
+	var spans []ChunkSpan
+	offsets := chunkOffsets(chunks)
+	for i, chunk := range chunks {
+		start := offsets[i]
+		if length >= 0 && start >= offset+length {
+			break // this chunk and all after it start past the range
+		}
+		// old chunks without a recorded size are taken as DefaultChunkSize, the DN cuts the last one short
+		size := chunk.dataSize()
+		if size <= 0 {
+			size = DefaultChunkSize
+		}
+		if start+size <= offset {
+			continue // ends before the range
+		}
+
+		span := ChunkSpan{Chunk: chunk, Length: -1}
+		if offset > start {
+			span.Offset = offset - start
+		}
+		if length >= 0 && offset+length < start+size {
+			span.Length = offset + length - start - span.Offset
+		}
+		spans = append(spans, span)
+	}
+	return spans
+}
+
+// chunkAt returns the index of the chunk holding the byte at off
+func (f *File) chunkAt(off int64) int {
+	return sort.Search(len(f.offsets), func(i int) bool { return f.offsets[i] > off }) - 1
+}
+
+// Stat returns what the cluster knew about the file when it was opened
+func (f *File) Stat() (fs.FileInfo, error) {
+	return fileInfo{f.stat}, nil
+}
+
+// Size returns the size of the file
+func (f *File) Size() int64 {
+	return f.stat.Size
+}
+
+// ReadAt reads len(p) bytes starting at off, fetching only the chunks that cover them, it is safe to call from several goroutines
+func (f *File) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, &fs.PathError{Op: "read", Path: f.stat.Path, Err: fs.ErrInvalid}
+	}
+	n := 0
+	for n < len(p) && off < f.stat.Size {
+		i := f.chunkAt(off)
+		data, err := f.chunk(i)
+		if err != nil {
+			return n, err
+		}
+		// a chunk shorter than the metadata says would have us slice past its end, or loop forever on an empty copy
+		start := off - f.offsets[i]
+		if start < 0 || start >= int64(len(data)) {
+			return n, fmt.Errorf("%s: chunk %d has %d bytes, need offset %d: %w", f.stat.Path, i, len(data), start, io.ErrUnexpectedEOF)
+		}
+		copied := copy(p[n:], data[start:])
+		n += copied
+		off += int64(copied)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// chunk returns the data of chunk i, from the cache if it was the last one fetched
+func (f *File) chunk(i int) ([]byte, error) {
+	f.lock.Lock()
+	if f.cached == i {
+		data := f.data
+		f.lock.Unlock()
+		return data, nil
+	}
+	f.lock.Unlock()
+
+	data, bad, err := f.c.fetchChunk(f.ctx, ChunkSpan{Chunk: f.chunks[i], Length: -1})
+	f.c.reportBadReplicas(bad)
+	if err != nil {
+		return nil, err
+	}
+
+	f.lock.Lock()
+	f.cached, f.data = i, data
+	f.lock.Unlock()
+	return data, nil
+}
+
+// Read reads from the current position, like any io.Reader
+func (f *File) Read(p []byte) (int, error) {
+	f.lock.Lock()
+	pos := f.pos
+	f.lock.Unlock()
+
+	n, err := f.ReadAt(p, pos)
+	if n > 0 && err == io.EOF {
+		err = nil // the next Read returns the EOF
+	}
+
+	f.lock.Lock()
+	f.pos += int64(n)
+	f.lock.Unlock()
+	return n, err
+}
+
+// Seek sets where the next Read starts, like any io.Seeker
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.pos
+	case io.SeekEnd:
+		offset += f.stat.Size
+	default:
+		return 0, errors.New("seek: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("seek: negative position")
+	}
+	f.pos = offset
+	return offset, nil
+}
+
+// Close drops the cached chunk, there is nothing else to release
+func (f *File) Close() error {
+	f.lock.Lock()
+	f.cached, f.data = -1, nil
+	f.lock.Unlock()
+	return nil
+}
@@ -0,0 +1,197 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"time"
+)
+
+// FS is the cluster as an io/fs filesystem, so http.FileServer, fs.WalkDir, archive/zip and friends work on top of it
+// names are the usual io/fs names, unrooted and slash separated ("a/x.txt", "." for the root)
+// files that are still pending (being uploaded) don't exist as far as FS is concerned
+type FS struct {
+	c   *Client
+	ctx context.Context
+}
+
+// make sure we actually implement what callers will look for
+var (
+	_ fs.FS         = (*FS)(nil)
+	_ fs.ReadDirFS  = (*FS)(nil)
+	_ fs.StatFS     = (*FS)(nil)
+	_ io.ReaderAt   = (*File)(nil)
+	_ io.ReadSeeker = (*File)(nil)
+)
+
+// FS returns the cluster as an fs.FS, every request it makes uses ctx
+func (c *Client) FS(ctx context.Context) *FS {
+	return &FS{c: c, ctx: ctx}
+}
+
+// clusterPath turns an io/fs name into a path in the cluster, "a/x.txt" -> "/a/x.txt"
+func clusterPath(op, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	return path.Join("/", name), nil
+}
+
+// pathError wraps a client error the way io/fs callers expect, ErrNotFound becomes fs.ErrNotExist
+func pathError(op, name string, err error) error {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		err = fs.ErrNotExist
+	case errors.Is(err, ErrExists):
+		err = fs.ErrExist
+	}
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+// Open opens a file or a directory, files come back as *File
+func (fsys *FS) Open(name string) (fs.File, error) {
+	p, err := clusterPath("open", name)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := fsys.c.stat(fsys.ctx, p)
+	if err != nil {
+		return nil, pathError("open", name, err)
+	}
+	if stat.IsDir {
+		return &dirFile{fsys: fsys, name: name, stat: stat}, nil
+	}
+	f, err := fsys.c.openFile(fsys.ctx, stat)
+	if err != nil {
+		return nil, pathError("open", name, err)
+	}
+	return f, nil
+}
+
+// Stat returns the FileInfo of a file or directory without opening it
+func (fsys *FS) Stat(name string) (fs.FileInfo, error) {
+	p, err := clusterPath("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := fsys.c.stat(fsys.ctx, p)
+	if err != nil {
+		return nil, pathError("stat", name, err)
+	}
+	return fileInfo{stat}, nil
+}
+
+// ReadDir lists a directory, directories first and then by name
+func (fsys *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	p, err := clusterPath("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := fsys.c.List(fsys.ctx, p)
+	if err != nil {
+		return nil, pathError("readdir", name, err)
+	}
+	dirEntries := make([]fs.DirEntry, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.Pending {
+			dirEntries = append(dirEntries, dirEntry{entry})
+		}
+	}
+	return dirEntries, nil
+}
+
+// stat is Stat without pending files
+func (c *Client) stat(ctx context.Context, name string) (*FileStat, error) {
+	stat, err := c.Stat(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if stat.State == StatePending {
+		return nil, &Error{Op: "stat " + name, StatusCode: http.StatusNotFound, Message: "file is still being uploaded", Err: ErrNotFound}
+	}
+	return stat, nil
+}
+
+// dirFile is an open directory, the listing is fetched on the first ReadDir
+type dirFile struct {
+	fsys    *FS
+	name    string
+	stat    *FileStat
+	entries []fs.DirEntry
+	read    bool
+}
+
+func (d *dirFile) Stat() (fs.FileInfo, error) { return fileInfo{d.stat}, nil }
+
+func (d *dirFile) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: errors.New("is a directory")}
+}
+
+func (d *dirFile) Close() error { return nil }
+
+// ReadDir follows fs.ReadDirFile, n <= 0 returns everything that's left, n > 0 returns at most n entries and io.EOF at the end
+func (d *dirFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.read {
+		entries, err := d.fsys.ReadDir(d.name)
+		if err != nil {
+			return nil, err
+		}
+		d.entries, d.read = entries, true
+	}
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(d.entries) {
+		n = len(d.entries)
+	}
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
+
+// fileInfo is a FileStat as an fs.FileInfo, files are read only as far as io/fs is concerned
+type fileInfo struct {
+	stat *FileStat
+}
+
+func (fi fileInfo) Name() string {
+	if fi.stat.Path == "/" {
+		return "."
+	}
+	return path.Base(fi.stat.Path)
+}
+func (fi fileInfo) Size() int64        { return fi.stat.Size }
+func (fi fileInfo) ModTime() time.Time { return fi.stat.ModifiedAt }
+func (fi fileInfo) IsDir() bool        { return fi.stat.IsDir }
+func (fi fileInfo) Sys() any           { return fi.stat }
+func (fi fileInfo) Mode() fs.FileMode {
+	if fi.stat.IsDir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+// dirEntry is a DirEntry of a listing as an fs.DirEntry
+type dirEntry struct {
+	entry DirEntry
+}
+
+func (de dirEntry) Name() string { return de.entry.Name }
+func (de dirEntry) IsDir() bool  { return de.entry.IsDir }
+func (de dirEntry) Type() fs.FileMode {
+	if de.entry.IsDir {
+		return fs.ModeDir
+	}
+	return 0
+}
+func (de dirEntry) Info() (fs.FileInfo, error) {
+	return fileInfo{&FileStat{Path: de.entry.Path, IsDir: de.entry.IsDir, Size: de.entry.Size, ModifiedAt: de.entry.ModifiedAt}}, nil
+}
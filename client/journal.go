@@ -0,0 +1,124 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Rahul6700/Foodo/shared"
+)
+
+// the upload journal is a small file per (LB, remote name) in the journal dir, written once the cluster has opened an
+// upload session and removed once it is committed, if it is still there on the next Upload of the same name with the
+// same data, that upload resumes the session instead of starting over
+
+type uploadJournal struct {
+	SessionID string    `json:"session_id"`
+	LBAddress string    `json:"lb_address"`
+	Filename  string    `json:"filename"`
+	ChunkIDs  []string  `json:"chunk_ids"` // the data the session is for, in order
+	StartedAt time.Time `json:"started_at"`
+	// encrypted uploads -> the file's wrapped data key and nonce, the resumed upload has to seal its chunks the same way
+	// (only ever with the same data, see hashUpload)
+	Encryption *Encryption `json:"encryption,omitempty"`
+}
+
+// journalPath is where the journal of an upload of name lives, "" when journaling is off
+func (c *Client) journalPath(name string) string {
+	if c.journalDir == "" {
+		return ""
+	}
+	return filepath.Join(c.journalDir, sha1sum([]byte(c.lbAddress+"\n"+name))+".json")
+}
+
+// saveJournal records the session of an upload that is about to start, failing to write it only costs us the resume
+func (c *Client) saveJournal(name, sessionID string, chunks []shared.ClientChunk, encryption *Encryption) {
+	p := c.journalPath(name)
+	if p == "" {
+		return
+	}
+	journal := uploadJournal{SessionID: sessionID, LBAddress: c.lbAddress, Filename: name, StartedAt: time.Now(), Encryption: encryption}
+	for _, chunk := range chunks {
+		journal.ChunkIDs = append(journal.ChunkIDs, chunk.ChunkID)
+	}
+	data, err := json.Marshal(journal)
+	if err == nil {
+		err = os.MkdirAll(c.journalDir, 0700)
+	}
+	if err == nil {
+		err = os.WriteFile(p, data, 0600)
+	}
+	if err != nil {
+		c.logf("failed to write upload journal %s: %v", p, err)
+	}
+}
+
+// loadJournal returns the journal of an earlier upload of name, nil if there is none (or it is unreadable)
+func (c *Client) loadJournal(name string) *uploadJournal {
+	p := c.journalPath(name)
+	if p == "" {
+		return nil
+	}
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return nil
+	}
+	var journal uploadJournal
+	if err := json.Unmarshal(data, &journal); err != nil {
+		return nil
+	}
+	return &journal
+}
+
+func (c *Client) removeJournal(name string) {
+	if p := c.journalPath(name); p != "" {
+		os.Remove(p)
+	}
+}
+
+// resumeUpload looks for a journal of an earlier upload of the same data to name, and asks the LB what is left of its session
+// it returns the session and the plan for the chunks that still need uploading, or an empty session id if there is nothing to resume
+func (c *Client) resumeUpload(ctx context.Context, name string, chunks []shared.ClientChunk) (string, map[string][]string, error) {
+	journal := c.loadJournal(name)
+	if journal == nil {
+		return "", nil, nil
+	}
+	if !sameChunks(journal.ChunkIDs, chunks) {
+		// the data changed since, the old session will expire on its own
+		c.removeJournal(name)
+		return "", nil, nil
+	}
+
+	var status struct {
+		UploadPlan map[string][]string `json:"upload_plan"`
+		Acked      map[string][]string `json:"acked"`
+	}
+	err := c.callLB(ctx, http.MethodGet, "/uploadStatus", url.Values{"session_id": {journal.SessionID}}, nil, &status)
+	if errors.Is(err, ErrNotFound) {
+		// committed, aborted or expired in the meantime
+		c.removeJournal(name)
+		return "", nil, nil
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	c.logf("resuming upload session %s of %s, %d chunks already stored, %d left to upload", journal.SessionID, name, len(status.Acked), len(status.UploadPlan))
+	return journal.SessionID, status.UploadPlan, nil
+}
+
+func sameChunks(chunkIDs []string, chunks []shared.ClientChunk) bool {
+	if len(chunkIDs) != len(chunks) {
+		return false
+	}
+	for i, chunk := range chunks {
+		if chunkIDs[i] != chunk.ChunkID {
+			return false
+		}
+	}
+	return true
+}
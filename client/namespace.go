@@ -0,0 +1,151 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/Rahul6700/Foodo/shared"
+)
+
+// the states a file can be in, see FileStat.State
+const (
+	StateCommitted = "committed"
+	StatePending   = "pending" // the first upload to the path is still running, the file can't be read yet
+)
+
+// FileStat is everything the cluster knows about a file or directory
+// a pending file has the size and chunks of its upload, its replicas are the acknowledged ones so far
+type FileStat struct {
+	Path        string         `json:"path"`
+	IsDir       bool           `json:"is_dir"`
+	State       string         `json:"state"` // files only, StateCommitted or StatePending
+	Size        int64          `json:"size"`
+	ChunkCount  int            `json:"chunk_count"`
+	CreatedAt   time.Time      `json:"created_at"`
+	ModifiedAt  time.Time      `json:"modified_at"`
+	Replicas    []int          `json:"replicas"`     // live replicas of every chunk, in chunk order
+	MinReplicas int            `json:"min_replicas"` // the least replicated chunk
+	Erasure     *ErasureCoding `json:"erasure"`      // erasure coded files only, their chunks have a single copy each
+	Replication int            `json:"replication"`  // replicas the file keeps, 0 -> the cluster's default
+	Policy      string         `json:"policy"`       // its storage policy, if it has one
+	Chunking    *Chunking      `json:"chunking"`     // how it was cut into chunks, nil for files from before that was recorded
+	Encryption  *Encryption    `json:"encryption"`   // encrypted files only, see WithMasterKey
+	StoredSize  int64          `json:"stored_size"`  // its chunks as stored (compressed, encrypted), for one replica
+}
+
+// ErasureCoding is the layout of an erasure coded file, see WithErasureCoding
+type ErasureCoding = shared.ErasureCoding
+
+// DirEntry is one entry of a directory listing
+type DirEntry struct {
+	Name       string    `json:"name"`
+	Path       string    `json:"path"`
+	IsDir      bool      `json:"is_dir"`
+	Size       int64     `json:"size"`
+	ModifiedAt time.Time `json:"modified_at"` // zero for directories
+	Pending    bool      `json:"pending"`     // a file that is still being uploaded, it can't be read yet
+}
+
+// DedupStats is how much space chunk dedup is saving across the cluster
+type DedupStats struct {
+	LogicalChunks int   `json:"logical_chunks"` // chunks referenced by files, counting every reference
+	UniqueChunks  int   `json:"unique_chunks"`  // chunks actually stored
+	LogicalBytes  int64 `json:"logical_bytes"`
+	StoredBytes   int64 `json:"stored_bytes"`
+	SavedBytes    int64 `json:"saved_bytes"`
+}
+
+// Stat returns what the cluster knows about the file or directory name
+func (c *Client) Stat(ctx context.Context, name string) (*FileStat, error) {
+	var stat FileStat
+	if err := c.callLB(ctx, http.MethodGet, "/stat", c.readQuery(url.Values{"filename": {name}}), nil, &stat); err != nil {
+		return nil, err
+	}
+	return &stat, nil
+}
+
+// List returns the contents of the directory dir, directories first
+func (c *Client) List(ctx context.Context, dir string) ([]DirEntry, error) {
+	var listing struct {
+		Entries []DirEntry `json:"entries"`
+	}
+	if err := c.callLB(ctx, http.MethodGet, "/ls", c.readQuery(url.Values{"path": {dir}}), nil, &listing); err != nil {
+		return nil, err
+	}
+	return listing.Entries, nil
+}
+
+// how many files ListFiles asks the LB for per request
+const listPageSize = 500
+
+// ListFiles calls fn for every file whose full path starts with prefix, in path order, fetching the list a page at a time
+// an error returned by fn stops the listing and is returned as is
+func (c *Client) ListFiles(ctx context.Context, prefix string, fn func(FileStat) error) error {
+	after := ""
+	for {
+		var page struct {
+			Files []FileStat `json:"files"`
+			Next  string     `json:"next"`
+		}
+		query := url.Values{"prefix": {prefix}, "after": {after}, "limit": {strconv.Itoa(listPageSize)}}
+		if err := c.callLB(ctx, http.MethodGet, "/files", c.readQuery(query), nil, &page); err != nil {
+			return err
+		}
+		for _, file := range page.Files {
+			if err := fn(file); err != nil {
+				return err
+			}
+		}
+		if page.Next == "" {
+			return nil
+		}
+		after = page.Next
+	}
+}
+
+// Delete removes the file name, its chunks are deleted from the datanodes in the background
+func (c *Client) Delete(ctx context.Context, name string) error {
+	return c.callLB(ctx, http.MethodPost, "/deleteFile", url.Values{"filename": {name}}, nil, nil)
+}
+
+// Mkdir creates the directory dir (and its parents)
+func (c *Client) Mkdir(ctx context.Context, dir string) error {
+	return c.callLB(ctx, http.MethodPost, "/mkdir", url.Values{"path": {dir}}, nil, nil)
+}
+
+// Rename moves a file or a directory, if dst is a directory src is moved into it
+func (c *Client) Rename(ctx context.Context, src, dst string) error {
+	return c.callLB(ctx, http.MethodPost, "/rename", url.Values{"src": {src}, "dst": {dst}}, nil, nil)
+}
+
+// Remove removes a file or an empty directory, or a whole tree with recursive
+func (c *Client) Remove(ctx context.Context, name string, recursive bool) error {
+	query := url.Values{"path": {name}, "recursive": {strconv.FormatBool(recursive)}}
+	return c.callLB(ctx, http.MethodPost, "/delete", query, nil, nil)
+}
+
+// SetReplication changes how many replicas the file name keeps, the cluster adds or removes replicas in the background
+// erasure coded files keep a single copy of every shard, they can't be replicated
+func (c *Client) SetReplication(ctx context.Context, name string, replication int) error {
+	query := url.Values{"filename": {name}, "replication": {strconv.Itoa(replication)}}
+	return c.callLB(ctx, http.MethodPost, "/setReplication", query, nil, nil)
+}
+
+// SetPolicy moves the file name to a storage policy (see WithPolicy), the cluster adds or removes replicas in the background
+// switching between replicated and erasure coded policies needs the file to be uploaded again
+func (c *Client) SetPolicy(ctx context.Context, name, policy string) error {
+	return c.callLB(ctx, http.MethodPost, "/setReplication", url.Values{"filename": {name}, "policy": {policy}}, nil, nil)
+}
+
+// DedupStats returns how much space chunk dedup is saving across the cluster
+// encrypted files (WithMasterKey) never share chunks, every one of their chunks counts as stored once and saves nothing
+func (c *Client) DedupStats(ctx context.Context) (*DedupStats, error) {
+	var stats DedupStats
+	if err := c.callLB(ctx, http.MethodGet, "/dedupStats", nil, nil, &stats); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
@@ -0,0 +1,477 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/Rahul6700/Foodo/shared"
+)
+
+// Upload stores everything read from r in the cluster as name, replacing the file if it already exists
+// r is read twice (once to hash the chunks, once to send them) and never held in memory as a whole,
+// readers that can't seek (pipes, network streams) are copied to a temp file first
+//
+// the upload runs in a session, until it is committed the file is only visible as pending (FileStat.State) and can't be read
+// the commit carries the replicas that stored every chunk, it is rejected (a 409 *Error) if a chunk has too few of them
+// with a journal dir (WithJournalDir) an upload that died halfway is resumed by calling Upload again with the same data
+// with erasure coding (WithErasureCoding, or an erasure coded WithPolicy) r is read a third time, to compute the parity chunks up front
+// with a master key (WithMasterKey) every chunk is encrypted before it is hashed and sent
+func (c *Client) Upload(ctx context.Context, name string, r io.Reader) error {
+	req, err := c.uploadRequest(name)
+	if err != nil {
+		return err
+	}
+	src, cleanup, err := seekable(r)
+	if err != nil {
+		return fmt.Errorf("upload %s: %w", name, err)
+	}
+	defer cleanup()
+	req.Size = src.Size()
+
+	// 1. Break the data into chunks (only their IDs and sizes are kept)
+	chunks, enc, err := c.hashUpload(name, src, &req)
+	if err != nil {
+		return err
+	}
+	c.logf("%s split into %d chunks (%s)%s", name, len(chunks), req.Chunking.Algorithm, compressionSummary(chunks))
+	readChunk := chunkReader(src, chunks, enc)
+
+	// erasure coded files also need the parity chunks of every stripe before the LB can plan them
+	var encoder *stripeEncoder
+	var stripes []shared.StripeStruct
+	if req.Erasure != nil {
+		if encoder, err = newStripeEncoder(req.Erasure, chunks, readChunk); err != nil {
+			return err
+		}
+		if stripes, err = encoder.parity(); err != nil {
+			return fmt.Errorf("upload %s: failed to encode stripes: %w", name, err)
+		}
+		c.logf("%s encoded into %d stripes of %d+%d shards", name, len(stripes), req.Erasure.DataShards, req.Erasure.ParityShards)
+	}
+
+	// 2. Pick up where an earlier attempt left off, or get a new upload plan from the Load Balancer
+	sessionID, uploadPlan, err := c.resumeUpload(ctx, name, chunks)
+	if err != nil {
+		return err
+	}
+	if sessionID == "" {
+		req.Chunks, req.Stripes = chunks, stripes
+		plan, err := c.initiateUpload(ctx, req)
+		if err != nil {
+			return err
+		}
+		sessionID, uploadPlan = plan.SessionID, plan.UploadPlan
+		c.saveJournal(name, sessionID, chunks, req.Encryption)
+
+		// chunks that are already stored (dedup) are dropped from the plan, no need to send them again
+		if len(plan.AlreadyStored) > 0 {
+			var savedBytes int64
+			sizes := make(map[string]int64, len(chunks))
+			for _, chunk := range chunks {
+				sizes[chunk.ChunkID] = chunk.Size
+			}
+			for _, stripe := range stripes {
+				for _, parity := range stripe.Parity {
+					sizes[parity.ChunkID] = parity.Size
+				}
+			}
+			for _, chunkID := range plan.AlreadyStored {
+				savedBytes += sizes[chunkID]
+				delete(uploadPlan, chunkID)
+			}
+			c.logf("%d chunks (%d bytes) already stored, skipping them", len(plan.AlreadyStored), savedBytes)
+		}
+	}
+
+	// 3. Follow the plan and upload the data, acknowledging every chunk to the session as it lands
+	acks, err := c.uploadChunks(ctx, sessionID, chunkSources(chunks, readChunk, encoder, stripes), uploadPlan)
+	if err != nil {
+		return err
+	}
+
+	// 4. Commit with every replica that stored a chunk, only now can the file be read
+	commit, err := json.Marshal(shared.UploadSessionRequest{SessionID: sessionID, Chunks: acks})
+	if err != nil {
+		return err
+	}
+	if err := c.callLB(ctx, http.MethodPost, "/commitUpload", nil, bytes.NewReader(commit), nil); err != nil {
+		return err
+	}
+	c.removeJournal(name)
+	return nil
+}
+
+// seekable returns r as something we can read twice
+// a reader that can already seek (like a regular *os.File) is used from its current position, anything else is spooled to a temp file
+// cleanup removes the temp file, if there is one
+func seekable(r io.Reader) (*io.SectionReader, func(), error) {
+	if rs, ok := r.(interface {
+		io.ReaderAt
+		io.Seeker
+	}); ok {
+		// os.Stdin is an *os.File too, but Seek fails on a pipe, so it ends up spooled like any other stream
+		if start, err := rs.Seek(0, io.SeekCurrent); err == nil {
+			if end, err := rs.Seek(0, io.SeekEnd); err == nil {
+				if _, err := rs.Seek(start, io.SeekStart); err == nil {
+					return io.NewSectionReader(rs, start, end-start), func() {}, nil
+				}
+			}
+		}
+	}
+
+	tmp, err := os.CreateTemp("", "foodo-upload-*")
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup := func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}
+	n, err := io.Copy(tmp, r)
+	if err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf("failed to spool data to %s: %w", tmp.Name(), err)
+	}
+	return io.NewSectionReader(tmp, 0, n), cleanup, nil
+}
+
+func sha1sum(inp []byte) string {
+	h := sha1.New()
+	h.Write(inp)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// uploadRequest starts the LB's upload request for name with how the file is to be cut and stored,
+// a storage policy (WithPolicy) decides the storing on its own, otherwise WithReplication and WithErasureCoding do
+func (c *Client) uploadRequest(name string) (shared.ClientUploadRequest, error) {
+	chunking := c.chunking()
+	req := shared.ClientUploadRequest{FileName: name, Replication: c.replication, Erasure: c.erasure, Chunking: &chunking}
+	if err := checkChunking(chunking); err != nil {
+		return req, &Error{Op: "upload " + name, Message: err.Error(), Err: ErrInvalid}
+	}
+	if err := checkCodec(c.compression); err != nil {
+		return req, &Error{Op: "upload " + name, Message: err.Error(), Err: ErrInvalid}
+	}
+	if c.policy == "" {
+		return req, nil
+	}
+	policy, ok := shared.LookupPolicy(c.policy)
+	if !ok {
+		return req, &Error{Op: "upload " + name, Message: "unknown storage policy " + c.policy, Err: ErrInvalid}
+	}
+	req.Policy, req.Replication, req.Erasure = policy.Name, policy.Replication, policy.Erasure
+	return req, nil
+}
+
+// hashUpload cuts src into the chunks of an upload of name, encoded the way they are going to be stored,
+// and sets req.Encryption if they are encrypted
+// an upload resuming an interrupted one (see journal.go) has to seal with that upload's data key and nonce, or its chunks
+// wouldn't match the ones already stored. that is only safe for the same data, sealing anything else with them would
+// reuse GCM nonces. sealing is deterministic, so the data is the same exactly when it seals into the journal's chunks:
+// those sealed chunks are only hashed, never sent, and if they don't match the data is hashed again with a new key
+func (c *Client) hashUpload(name string, src *io.SectionReader, req *shared.ClientUploadRequest) ([]shared.ClientChunk, chunkEncoder, error) {
+	enc := chunkEncoder{codec: c.compression}
+	hash := func() ([]shared.ClientChunk, error) {
+		chunks, err := hashChunks(src, *req.Chunking, enc)
+		if err != nil {
+			return nil, fmt.Errorf("upload %s: failed to chunk data: %w", name, err)
+		}
+		return chunks, nil
+	}
+	if c.masterKey == nil {
+		chunks, err := hash()
+		return chunks, enc, err
+	}
+
+	if journal := c.loadJournal(name); journal != nil && journal.Encryption != nil {
+		if fc, err := openFileCipher(c.masterKey, journal.Encryption); err == nil {
+			enc.key = fc
+			chunks, err := hash()
+			if err != nil {
+				return nil, enc, err
+			}
+			if sameChunks(journal.ChunkIDs, chunks) {
+				req.Encryption = journal.Encryption
+				return chunks, enc, nil
+			}
+		}
+	}
+
+	var err error
+	if enc.key, req.Encryption, err = newFileCipher(c.masterKey); err != nil {
+		return nil, enc, &Error{Op: "upload " + name, Message: err.Error(), Err: ErrInvalid}
+	}
+	chunks, err := hash()
+	return chunks, enc, err
+}
+
+// initiateUpload sends the chunk list (and the stripes, if erasure coded) to the LB, which opens an upload session
+// and tells us where each chunk goes
+func (c *Client) initiateUpload(ctx context.Context, req shared.ClientUploadRequest) (*shared.UploadPlanResponse, error) {
+	jsonData, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+	var plan shared.UploadPlanResponse
+	if err := c.callLB(ctx, http.MethodPost, "/uploadFile", nil, bytes.NewReader(jsonData), &plan); err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
+
+// chunkSource is a chunk to upload, read gets its data
+type chunkSource struct {
+	chunkID string
+	name    string // for errors -> "chunk 3", "parity 1 of stripe 0"
+	read    func() ([]byte, error)
+}
+
+// chunkReader returns a function that reads chunk i (of chunks, as hashChunks listed them) back from src,
+// encoded (compressed, sealed) the same way hashChunks encoded it
+func chunkReader(src *io.SectionReader, chunks []shared.ClientChunk, enc chunkEncoder) func(i int) ([]byte, error) {
+	offsets := make([]int64, len(chunks))
+	var offset int64
+	for i, chunk := range chunks {
+		offsets[i] = offset
+		offset += enc.rawSize(chunk)
+	}
+	return func(i int) ([]byte, error) {
+		data := make([]byte, enc.rawSize(chunks[i]))
+		if _, err := src.ReadAt(data, offsets[i]); err != nil {
+			return nil, err
+		}
+		stored, _, err := enc.encode(chunks[i].Index, data)
+		return stored, err
+	}
+}
+
+// compressionSummary is the ", 5 of 8 compressed to 31%" part of the upload log line, "" if nothing was compressed
+func compressionSummary(chunks []shared.ClientChunk) string {
+	var compressed int
+	var raw, stored int64
+	for _, chunk := range chunks {
+		if chunk.Codec != "" {
+			compressed++
+			raw += chunk.RawSize
+			stored += chunk.Size
+		}
+	}
+	if compressed == 0 {
+		return ""
+	}
+	return fmt.Sprintf(", %d of %d compressed to %d%%", compressed, len(chunks), stored*100/raw)
+}
+
+// dataSize is how many bytes of the file an uncompressed stored chunk of size bytes holds
+func dataSize(size int64, fc *fileCipher) int64 {
+	if fc != nil {
+		return size - sealOverhead
+	}
+	return size
+}
+
+// chunkSources lists the chunks of the file in upload order, reading each one back with readChunk
+// an erasure coded file (encoder isn't nil) goes stripe by stripe, every stripe's chunks followed by its parity chunks,
+// so each stripe only has to be read and encoded once
+func chunkSources(chunks []shared.ClientChunk, readChunk func(i int) ([]byte, error), encoder *stripeEncoder, stripes []shared.StripeStruct) []chunkSource {
+	var sources []chunkSource
+	if encoder == nil {
+		for i, chunk := range chunks {
+			sources = append(sources, chunkSource{
+				chunkID: chunk.ChunkID,
+				name:    fmt.Sprintf("chunk %d", chunk.Index),
+				read:    func() ([]byte, error) { return readChunk(i) },
+			})
+		}
+		return sources
+	}
+
+	for s, stripe := range stripes {
+		for i, chunk := range encoder.stripeChunks(s) {
+			sources = append(sources, chunkSource{
+				chunkID: chunk.ChunkID,
+				name:    fmt.Sprintf("chunk %d", chunk.Index),
+				read:    func() ([]byte, error) { return encoder.shard(s, i) },
+			})
+		}
+		for p, parity := range stripe.Parity {
+			shard := encoder.code.DataShards() + p
+			sources = append(sources, chunkSource{
+				chunkID: parity.ChunkID,
+				name:    fmt.Sprintf("parity %d of stripe %d", p, s),
+				read:    func() ([]byte, error) { return encoder.shard(s, shard) },
+			})
+		}
+	}
+	return sources
+}
+
+// uploadChunks reads every planned chunk from its source and uploads it, with at most maxInFlight chunks in memory
+// a chunk that appears more than once in the file is only sent once
+// it returns the replicas that stored every chunk it sent, for the commit
+// the first chunk that can't be stored anywhere stops the upload, the chunks already in flight
+// are still finished and acknowledged so a resumed upload doesn't have to send them again
+func (c *Client) uploadChunks(ctx context.Context, sessionID string, sources []chunkSource, uploadPlan map[string][]string) ([]shared.ChunkStruct, error) {
+	var acksLock sync.Mutex
+	var acks []shared.ChunkStruct
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	var errOnce sync.Once
+	var firstErr error
+	fail := func(err error) {
+		errOnce.Do(func() {
+			firstErr = err
+			close(stop)
+		})
+	}
+	stopped := func() bool {
+		select {
+		case <-stop:
+			return true
+		default:
+			return false
+		}
+	}
+
+	window := make(chan struct{}, c.maxInFlight) // a slot per chunk in flight
+	sent := make(map[string]bool)
+	for _, source := range sources {
+		locations, ok := uploadPlan[source.chunkID]
+		if !ok || sent[source.chunkID] {
+			continue // already stored, or already sent earlier in this file
+		}
+		sent[source.chunkID] = true
+
+		// blocks while maxInFlight chunks are still uploading
+		select {
+		case window <- struct{}{}:
+		case <-stop:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			fail(ctx.Err())
+		}
+		if stopped() {
+			break
+		}
+
+		data, err := source.read()
+		if err != nil {
+			<-window
+			fail(fmt.Errorf("failed to read %s: %w", source.name, err))
+			break
+		}
+
+		wg.Add(1)
+		go func(id string, locs []string, d []byte) {
+			defer wg.Done()
+			defer func() { <-window }()
+			stored, err := c.uploadChunk(ctx, id, locs, d)
+			if err != nil {
+				fail(err)
+				return
+			}
+			acksLock.Lock()
+			acks = append(acks, shared.ChunkStruct{ChunkID: id, Locations: stored})
+			acksLock.Unlock()
+
+			// the commit carries this ack too, a lost one only means a resumed upload sends the chunk again
+			if err := c.ackChunk(ctx, sessionID, id, stored); err != nil {
+				c.logf("failed to acknowledge chunk %s: %v", id, err)
+			}
+		}(source.chunkID, locations, data)
+	}
+	wg.Wait()
+	return acks, firstErr
+}
+
+// ackChunk tells the session which replicas stored the chunk, a resumed upload skips it from then on
+func (c *Client) ackChunk(ctx context.Context, sessionID, chunkID string, stored []string) error {
+	ack, err := json.Marshal(shared.UploadSessionRequest{
+		SessionID: sessionID,
+		Chunks:    []shared.ChunkStruct{{ChunkID: chunkID, Locations: stored}},
+	})
+	if err != nil {
+		return err
+	}
+	return c.callLB(ctx, http.MethodPost, "/ackChunks", nil, bytes.NewReader(ack), nil)
+}
+
+// uploadChunk sends the chunk once, to the first replica, and lets the datanodes pipeline it to the others
+// the first datanode only answers after the whole chain has stored the chunk, it returns the replicas that did
+// a chain that broke halfway still counts, the LB's replication manager tops the chunk up later.
+// a head that can't be reached or stored nothing is skipped, the next replica heads the chain of the ones after it
+func (c *Client) uploadChunk(ctx context.Context, chunkID string, locations []string, data []byte) ([]string, error) {
+	op := "upload chunk " + chunkID
+	if len(locations) == 0 {
+		return nil, &Error{Op: op, Message: "no replicas planned", Err: ErrChunk}
+	}
+
+	var lastErr *Error
+	for i, head := range locations {
+		stored, err := c.writeChain(ctx, chunkID, head, locations[i+1:], data)
+		if err == nil {
+			if len(stored) < len(locations) {
+				c.logf("pipeline for chunk %s only reached %d of %d replicas", chunkID, len(stored), len(locations))
+			}
+			return stored, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		lastErr = &Error{Op: op, StatusCode: err.StatusCode, Message: head + ": " + err.Message, Err: ErrChunk}
+		if i+1 < len(locations) {
+			c.logf("failed to write chunk %s to %s: %s, trying %s as the head of the pipeline", chunkID, head, err.Message, locations[i+1])
+		}
+	}
+	return nil, lastErr
+}
+
+// writeChain posts the chunk to head with the rest of the chain in the pipeline header,
+// and returns the replicas that stored it, an error if not even the head did
+func (c *Client) writeChain(ctx context.Context, chunkID, head string, rest []string, data []byte) ([]string, *Error) {
+	ctx, cancel := withTimeout(ctx, c.chunkTimeout)
+	defer cancel()
+
+	fullURL := fmt.Sprintf("%s/writeChunk/%s", head, chunkID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fullURL, bytes.NewReader(data))
+	if err != nil {
+		return nil, &Error{Message: err.Error()}
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if len(rest) > 0 {
+		req.Header.Set(shared.PipelineHeader, strings.Join(rest, ","))
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, &Error{Message: err.Error()}
+	}
+	defer resp.Body.Close()
+
+	var ack shared.WriteChunkResponse
+	if err := json.NewDecoder(resp.Body).Decode(&ack); err != nil || len(ack.Stored) == 0 {
+		message := ack.Error
+		if message == "" && err != nil {
+			message = err.Error()
+		}
+		if message == "" {
+			message = resp.Status
+		}
+		return nil, &Error{StatusCode: resp.StatusCode, Message: message}
+	}
+	if resp.StatusCode != http.StatusOK {
+		c.logf("pipeline for chunk %s broke after %v: %s %s", chunkID, ack.Stored, resp.Status, ack.Error)
+	}
+	return ack.Stored, nil
+}
@@ -0,0 +1,154 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Rahul6700/Foodo/shared"
+)
+
+// fakeCluster is an LB that opens sessions and a datanode that refuses every chunk, so every upload is interrupted
+// and leaves its journal behind
+type fakeCluster struct {
+	lock      sync.Mutex
+	begun     []shared.ClientUploadRequest // every /uploadFile
+	written   []string                     // chunk ids the datanode was sent
+	resumable bool                         // whether /uploadStatus knows the session
+	lb, dn    *httptest.Server
+}
+
+func newFakeCluster(t *testing.T) *fakeCluster {
+	f := &fakeCluster{}
+	f.dn = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.lock.Lock()
+		f.written = append(f.written, strings.TrimPrefix(r.URL.Path, "/writeChunk/"))
+		f.lock.Unlock()
+		http.Error(w, `{"error": "disk full"}`, http.StatusInternalServerError)
+	}))
+	f.lb = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.lock.Lock()
+		defer f.lock.Unlock()
+		switch r.URL.Path {
+		case "/uploadFile":
+			var req shared.ClientUploadRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			f.begun = append(f.begun, req)
+			json.NewEncoder(w).Encode(shared.UploadPlanResponse{Success: true, SessionID: "s1", UploadPlan: f.plan(req.Chunks)})
+		case "/uploadStatus":
+			if !f.resumable {
+				http.Error(w, `{"error": "not found"}`, http.StatusNotFound)
+				return
+			}
+			last := f.begun[len(f.begun)-1]
+			json.NewEncoder(w).Encode(map[string]any{"upload_plan": f.plan(last.Chunks), "acked": map[string][]string{}})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(func() {
+		f.lb.Close()
+		f.dn.Close()
+	})
+	return f
+}
+
+// every chunk goes to the datanode
+func (f *fakeCluster) plan(chunks []shared.ClientChunk) map[string][]string {
+	plan := make(map[string][]string)
+	for _, chunk := range chunks {
+		plan[chunk.ChunkID] = []string{f.dn.URL}
+	}
+	return plan
+}
+
+func randomData(t *testing.T, n int) []byte {
+	data := make([]byte, n)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// an upload resuming an interrupted one seals with its key only if it is the same data, anything else gets a new key
+func TestInterruptedEncryptedUploadKeys(t *testing.T) {
+	f := newFakeCluster(t)
+	key := randomData(t, MasterKeySize)
+	c := New(WithLBAddress(f.lb.URL), WithJournalDir(t.TempDir()), WithMasterKey(key), WithChunkSize(1024), WithMaxInFlight(1))
+	ctx := context.Background()
+
+	first := randomData(t, 4096)
+	if err := c.Upload(ctx, "/f", bytes.NewReader(first)); err == nil {
+		t.Fatal("upload to a datanode that refuses every chunk succeeded")
+	}
+	if len(f.begun) != 1 || f.begun[0].Encryption == nil {
+		t.Fatalf("first upload began %d sessions, want 1 encrypted one", len(f.begun))
+	}
+	firstKey := f.begun[0].Encryption
+
+	// the same data again resumes the session, sealed with the same key into the same chunks
+	f.resumable = true
+	f.written = nil
+	if err := c.Upload(ctx, "/f", bytes.NewReader(first)); err == nil {
+		t.Fatal("resumed upload succeeded")
+	}
+	if len(f.begun) != 1 {
+		t.Fatalf("resuming the same data began a new session")
+	}
+	if len(f.written) == 0 || f.written[0] != f.begun[0].Chunks[0].ChunkID {
+		t.Fatalf("resumed upload sent %v, want the journal's chunk %s first", f.written, f.begun[0].Chunks[0].ChunkID)
+	}
+
+	// different data must not be sealed with the interrupted upload's key and nonces
+	second := randomData(t, 4096)
+	if err := c.Upload(ctx, "/f", bytes.NewReader(second)); err == nil {
+		t.Fatal("upload to a datanode that refuses every chunk succeeded")
+	}
+	if len(f.begun) != 2 {
+		t.Fatalf("upload of different data began %d sessions in total, want 2", len(f.begun))
+	}
+	secondKey := f.begun[1].Encryption
+	if secondKey == nil {
+		t.Fatal("upload of different data was not encrypted")
+	}
+	if bytes.Equal(secondKey.WrappedKey, firstKey.WrappedKey) || bytes.Equal(secondKey.Nonce, firstKey.Nonce) {
+		t.Fatal("upload of different data reused the interrupted upload's data key and nonce")
+	}
+}
+
+// a head of the pipeline that is down or stores nothing is skipped, the next replica heads the chain of the ones after it
+func TestUploadChunkSkipsDeadHead(t *testing.T) {
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+	full := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInsufficientStorage)
+		json.NewEncoder(w).Encode(shared.WriteChunkResponse{Error: "disk full"})
+	}))
+	defer full.Close()
+	var pipeline string
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pipeline = r.Header.Get(shared.PipelineHeader)
+		json.NewEncoder(w).Encode(shared.WriteChunkResponse{Success: true, Stored: []string{"ok", pipeline}})
+	}))
+	defer ok.Close()
+
+	c := New(WithLBAddress(ok.URL))
+	stored, err := c.uploadChunk(context.Background(), "c1", []string{dead.URL, full.URL, ok.URL, "http://last"}, []byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pipeline != "http://last" || len(stored) != 2 {
+		t.Fatalf("stored on %v with pipeline %q, want the live replica to head the rest of the chain", stored, pipeline)
+	}
+
+	if _, err := c.uploadChunk(context.Background(), "c1", []string{dead.URL, full.URL}, []byte("data")); !errors.Is(err, ErrChunk) {
+		t.Fatalf("upload with no replica storing the chunk returned %v, want ErrChunk", err)
+	}
+}
@@ -1,309 +1,204 @@
 package main
 
 import (
-	"bytes"
-	"crypto/sha1"
+	"context"
 	"encoding/hex"
-	"encoding/json"
+	"flag"
 	"fmt"
-	"io"
 	"log"
-	"net/http"
 	"os"
+	"path"
 	"path/filepath"
-	"sort"
-	"sync"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Rahul6700/Foodo/client"
+	"github.com/Rahul6700/Foodo/shared"
 )
 
-// --- CONFIGURATION ---
-const chunkSize = 2 * 1024 * 1024
-const lbAddress = "http://localhost:8000"
+// the CLI is a thin wrapper around the client package, every command is one call on a client.Client
 
-// --- STRUCTS (For Uploading) ---
-type ClientChunk struct {
-	ChunkID string `json:"chunk_id"`
-	Index   int    `json:"index"`
-}
-type ClientUploadRequest struct {
-	FileName string        `json:"filename"`
-	Chunks   []ClientChunk `json:"chunks"`
+// remotePath turns a local path into the path the file gets in the cluster
+// "a/x.txt" -> "/a/x.txt", so uploading a/x.txt and b/x.txt no longer collide
+func remotePath(localPath string) string {
+	return path.Clean("/" + filepath.ToSlash(localPath))
 }
-type UploadPlanResponse struct {
-	Success    bool                `json:"success"`
-	UploadPlan map[string][]string `json:"upload_plan"` // chunkID -> [DN_URL, ...]
-}
-
-// --- STRUCTS (For Downloading) ---
-type DownloadChunkInfo struct {
-	ChunkID   string   `json:"chunk_id"`
-	Index     int      `json:"chunk_index"`
-	Locations []string `json:"locations"`
-}
-type DownloadPlanResponse struct {
-	Chunks []DownloadChunkInfo `json:"chunks"`
-}
-
-// --- SHA1 HELPER ---
-func sha1sum(inp []byte) string {
-	h := sha1.New()
-	h.Write(inp)
-	return hex.EncodeToString(h.Sum(nil))
-}
-
-// ===================================================================
-//
-//	UPLOAD LOGIC
-//
-// ===================================================================
 
-func handleUpload(filePath string) {
-	// 1. Break the file into chunks
-	log.Printf("Chunking file: %s\n", filePath)
-	chunks, data, err := chunkFile(filePath)
+// uploads in progress are journaled in the user's cache dir, running the same upload again after a failure resumes it
+func defaultJournalDir() string {
+	dir, err := os.UserCacheDir()
 	if err != nil {
-		log.Fatalf("Failed to chunk file: %v", err)
+		return ""
 	}
-	log.Printf("File split into %d chunks.\n", len(chunks))
+	return filepath.Join(dir, "foodo", "uploads")
+}
 
-	// 2. Call the Load Balancer to get the upload plan
-	log.Println("Contacting load balancer to get upload plan...")
-	plan, err := initiateUpload(filepath.Base(filePath), chunks)
-	if err != nil {
-		log.Fatalf("Failed to get upload plan: %v", err)
+// the master key of encrypted uploads, read from -key-file (32 raw bytes or 64 hex digits) or $FOODO_MASTER_KEY (hex)
+// nil if neither is set, files are uploaded in the clear then
+func loadMasterKey(keyFile string) ([]byte, error) {
+	text := os.Getenv("FOODO_MASTER_KEY")
+	if keyFile != "" {
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		if len(data) == client.MasterKeySize {
+			return data, nil
+		}
+		text = string(data)
 	}
-	log.Println("Upload plan received.")
-
-	// 3. Follow the plan and upload the data
-	log.Println("Starting chunk uploads...")
-	uploadChunks(plan, data)
-
-	log.Println("Upload complete!")
+	if text == "" {
+		return nil, nil
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(text))
+	if err != nil || len(key) != client.MasterKeySize {
+		return nil, fmt.Errorf("want %d raw bytes or %d hex digits", client.MasterKeySize, client.MasterKeySize*2)
+	}
+	return key, nil
 }
 
-// chunkFile (Same as before)
-func chunkFile(filePath string) ([]ClientChunk, map[string][]byte, error) {
-	var chunksMetadata []ClientChunk
-	chunkDataMap := make(map[string][]byte)
+// handleUpload uploads the local file at filePath and stores it in the cluster as target
+// if it fails halfway, running the same upload again picks up where it left off
+func handleUpload(ctx context.Context, c *client.Client, filePath string, target string) {
 	file, err := os.Open(filePath)
 	if err != nil {
-		return nil, nil, err
+		log.Fatalf("Failed to open file: %v", err)
 	}
 	defer file.Close()
-	buffer := make([]byte, chunkSize)
-	index := 0
-	for {
-		n, err := file.Read(buffer)
-		if n > 0 {
-			chunkData := make([]byte, n)
-			copy(chunkData, buffer[:n])
-			chunkID := sha1sum(chunkData)
-			chunksMetadata = append(chunksMetadata, ClientChunk{
-				ChunkID: chunkID,
-				Index:   index,
-			})
-			chunkDataMap[chunkID] = chunkData
-			index++
-		}
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return nil, nil, err
-		}
+
+	log.Printf("Uploading %s to %s\n", filePath, target)
+	if err := c.Upload(ctx, target, file); err != nil {
+		log.Fatalf("Failed to upload: %v", err)
 	}
-	return chunksMetadata, chunkDataMap, nil
+	log.Println("Upload complete!")
 }
 
-// initiateUpload (Same as before)
-func initiateUpload(filename string, chunks []ClientChunk) (map[string][]string, error) {
-	reqBody := ClientUploadRequest{FileName: filename, Chunks: chunks}
-	jsonData, err := json.Marshal(reqBody)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request: %w", err)
-	}
-	resp, err := http.Post(lbAddress+"/uploadFile", "application/json", bytes.NewBuffer(jsonData))
+// handleDownload saves the file fileName as saveAs, a failed download doesn't leave a half written file behind
+func handleDownload(ctx context.Context, c *client.Client, fileName string, saveAs string) {
+	file, err := os.Create(saveAs)
 	if err != nil {
-		return nil, fmt.Errorf("failed to call load balancer: %w", err)
+		log.Fatalf("Failed to create %s: %v", saveAs, err)
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("load balancer returned error: %s", resp.Status)
+	if err := c.Download(ctx, fileName, file); err != nil {
+		file.Close()
+		os.Remove(saveAs)
+		log.Fatalf("Failed to download: %v", err)
 	}
-	var uploadResponse UploadPlanResponse
-	if err := json.NewDecoder(resp.Body).Decode(&uploadResponse); err != nil {
-		return nil, fmt.Errorf("failed to decode upload plan: %w", err)
+	if err := file.Close(); err != nil {
+		log.Fatalf("Failed to save %s: %v", saveAs, err)
 	}
-	return uploadResponse.UploadPlan, nil
-}
-
-// uploadChunks (Same as before)
-func uploadChunks(uploadPlan map[string][]string, chunkData map[string][]byte) {
-	var wg sync.WaitGroup
-	for chunkID, locations := range uploadPlan {
-		data, ok := chunkData[chunkID]
-		if !ok {
-			log.Printf("Error: No data found for chunkID %s, skipping", chunkID)
-			continue
-		}
-		wg.Add(1)
-		go func(id string, locs []string, d []byte) {
-			defer wg.Done()
-			uploadChunkToReplicas(id, locs, d)
-		}(chunkID, locations, data)
-	}
-	wg.Wait()
+	log.Printf("File successfully downloaded and saved as %s\n", saveAs)
 }
 
-// uploadChunkToReplicas (Same as before)
-func uploadChunkToReplicas(chunkID string, locations []string, data []byte) {
-	var wg sync.WaitGroup
-	for _, location := range locations {
-		wg.Add(1)
-		go func(url string) {
-			defer wg.Done()
-			fullURL := fmt.Sprintf("%s/writeChunk/%s", url, chunkID)
-			req, err := http.NewRequest("POST", fullURL, bytes.NewBuffer(data))
-			if err != nil {
-				log.Printf("Failed to create request for %s: %v\n", url, err)
-				return
-			}
-			req.Header.Set("Content-Type", "application/octet-stream")
-			resp, err := http.DefaultClient.Do(req)
-			if err != nil {
-				log.Printf("Failed to upload chunk %s to %s: %v\n", chunkID, url, err)
-				return
-			}
-			resp.Body.Close()
-		}(location)
+// handleCat writes length bytes of the file, starting at offset, to stdout (a negative length reads to the end)
+// only the chunks covering the range are fetched from the datanodes
+func handleCat(ctx context.Context, c *client.Client, target string, offset, length int64) {
+	if _, err := c.ReadRange(ctx, target, offset, length, os.Stdout); err != nil {
+		log.Fatalf("Failed to read %s: %v", target, err)
 	}
-	wg.Wait()
 }
 
-// ===================================================================
-//
-//	DOWNLOAD LOGIC (NEW)
-//
-// ===================================================================
-
-// handleDownload is the main "download" function
-func handleDownload(fileName string, saveAs string) {
-	// 1. Get the download plan from the LB/Namenode
-	log.Println("Contacting load balancer for download plan...")
-	plan, err := getDownloadPlan(fileName)
+// handleList prints the contents of a directory, directories get a trailing / and files that are still uploading a (pending)
+func handleList(ctx context.Context, c *client.Client, dir string) {
+	entries, err := c.List(ctx, dir)
 	if err != nil {
-		log.Fatalf("Failed to get download plan: %v", err)
+		log.Fatalf("Failed to list %s: %v", dir, err)
 	}
-
-	// 2. Download all chunks in parallel
-	log.Println("Downloading chunks...")
-	// Sort the chunks by their index (0, 1, 2, ...)
-	sort.Slice(plan.Chunks, func(i, j int) bool {
-		return plan.Chunks[i].Index < plan.Chunks[j].Index
-	})
-	
-	chunkData, err := downloadAllChunks(plan)
-	if err != nil {
-		log.Fatalf("Failed to download chunks: %v", err)
+	for _, entry := range entries {
+		if entry.IsDir {
+			fmt.Printf("%12s  %-19s  %s/\n", "-", "", entry.Name)
+		} else if entry.Pending {
+			fmt.Printf("%12d  %-19s  %s (pending)\n", entry.Size, entry.ModifiedAt.Local().Format("2006-01-02 15:04:05"), entry.Name)
+		} else {
+			fmt.Printf("%12d  %-19s  %s\n", entry.Size, entry.ModifiedAt.Local().Format("2006-01-02 15:04:05"), entry.Name)
+		}
 	}
+}
 
-	// 3. Stitch the file back together
-	log.Println("Reassembling file...")
-	err = reassembleFile(chunkData, saveAs)
+// handleListPrefix prints every file whose full path starts with prefix
+func handleListPrefix(ctx context.Context, c *client.Client, prefix string) {
+	err := c.ListFiles(ctx, prefix, func(file client.FileStat) error {
+		fmt.Printf("%12d  %-19s  %s\n", file.Size, file.ModifiedAt.Local().Format("2006-01-02 15:04:05"), file.Path)
+		return nil
+	})
 	if err != nil {
-		log.Fatalf("Failed to reassemble file: %v", err)
+		log.Fatalf("Failed to list files: %v", err)
 	}
-
-	log.Printf("File successfully downloaded and saved as %s\n", saveAs)
 }
 
-// getDownloadPlan calls a *new* LB endpoint
-func getDownloadPlan(filename string) (*DownloadPlanResponse, error) {
-	reqURL := fmt.Sprintf("%s/get-file-locations?filename=%s", lbAddress, filename)
-	log.Printf("lbAddress : %s\n", lbAddress)
-	log.Println("reqURL: " + reqURL)
-	resp, err := http.Get(reqURL)
+// handleStat prints everything the cluster knows about a file
+func handleStat(ctx context.Context, c *client.Client, target string) {
+	stat, err := c.Stat(ctx, target)
 	if err != nil {
-		return nil, err
+		log.Fatalf("Failed to stat %s: %v", target, err)
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("LB returned error: %s", resp.Status)
+	fmt.Printf("path:      %s\n", stat.Path)
+	if stat.IsDir {
+		fmt.Println("type:      directory")
+		return
 	}
-
-	var plan DownloadPlanResponse
-	if err := json.NewDecoder(resp.Body).Decode(&plan); err != nil {
-		return nil, err
+	fmt.Println("type:      file")
+	fmt.Printf("state:     %s\n", stat.State)
+	fmt.Printf("size:      %d bytes\n", stat.Size)
+	if stat.StoredSize > 0 && stat.StoredSize != stat.Size {
+		fmt.Printf("stored:    %d bytes (%d%%)\n", stat.StoredSize, stat.StoredSize*100/max(stat.Size, 1))
 	}
-	return &plan, nil
-}
-
-// downloadAllChunks fetches all chunks (in order) from the Datanodes
-func downloadAllChunks(plan *DownloadPlanResponse) ([][]byte, error) {
-	// A slice to hold the downloaded data, in the correct order
-	chunkData := make([][]byte, len(plan.Chunks))
-	
-	var wg sync.WaitGroup
-	errChan := make(chan error, len(plan.Chunks))
-
-	for i, chunk := range plan.Chunks {
-		wg.Add(1)
-		go func(c DownloadChunkInfo, index int) {
-			defer wg.Done()
-			
-			// Try the first location
-			data, err := downloadChunk(c.Locations[0], c.ChunkID)
-			if err != nil {
-				// TODO: Add logic to try other locations if the first fails
-				errChan <- fmt.Errorf("failed to download chunk %s: %w", c.ChunkID, err)
-				return
-			}
-			chunkData[index] = data
-		}(chunk, i)
+	fmt.Printf("chunks:    %d\n", stat.ChunkCount)
+	if ch := stat.Chunking; ch != nil && ch.Algorithm == shared.ChunkerFastCDC {
+		fmt.Printf("chunker:   %s, %d/%d/%d bytes min/avg/max\n", ch.Algorithm, ch.MinSize, ch.AvgSize, ch.MaxSize)
+	} else if ch != nil {
+		fmt.Printf("chunker:   %s, %d bytes\n", ch.Algorithm, ch.MaxSize)
 	}
-	
-	wg.Wait()
-	close(errChan)
-
-	// Check if any errors occurred during download
-	if err := <-errChan; err != nil {
-		return nil, err
+	if stat.Encryption != nil {
+		fmt.Printf("encrypted: %s (key id %s)\n", stat.Encryption.Cipher, stat.Encryption.KeyID)
+	}
+	if stat.Policy != "" {
+		fmt.Printf("policy:    %s\n", stat.Policy)
 	}
-	
-	return chunkData, nil
+	if stat.Erasure != nil {
+		fmt.Printf("erasure:   RS %d+%d\n", stat.Erasure.DataShards, stat.Erasure.ParityShards)
+	} else if stat.Replication > 0 {
+		fmt.Printf("target:    %d replicas\n", stat.Replication)
+	} else {
+		fmt.Println("target:    cluster default replicas")
+	}
+	fmt.Printf("replicas:  %v (min %d)\n", stat.Replicas, stat.MinReplicas)
+	fmt.Printf("created:   %s\n", stat.CreatedAt.Local().Format(time.RFC3339))
+	fmt.Printf("modified:  %s\n", stat.ModifiedAt.Local().Format(time.RFC3339))
 }
 
-// downloadChunk gets one chunk from one Datanode
-func downloadChunk(location string, chunkID string) ([]byte, error) {
-	reqURL := fmt.Sprintf("%s/readChunk/%s", location, chunkID)
-	resp, err := http.Get(reqURL)
+// handleSetReplication changes how many replicas a file keeps, setting is either a number or a storage policy name
+func handleSetReplication(ctx context.Context, c *client.Client, setting string, target string) {
+	var err error
+	if replication, convErr := strconv.Atoi(setting); convErr == nil {
+		err = c.SetReplication(ctx, target, replication)
+	} else {
+		err = c.SetPolicy(ctx, target, setting)
+	}
 	if err != nil {
-		return nil, err
+		log.Fatalf("Failed to set the replication of %s: %v", target, err)
 	}
-	defer resp.Body.Close()
+	log.Printf("Set %s to %s, replicas are added or removed in the background\n", target, setting)
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("datanode returned error: %s", resp.Status)
+// policyNames lists the storage policies for the usage text
+func policyNames() string {
+	var names []string
+	for _, policy := range shared.StoragePolicies {
+		names = append(names, policy.Name)
 	}
-	
-	return io.ReadAll(resp.Body)
+	return strings.Join(names, ", ")
 }
 
-// reassembleFile writes all the chunks to a single output file
-func reassembleFile(chunks [][]byte, saveAs string) error {
-	file, err := os.Create(saveAs)
+// handleDedupStats prints how much space chunk dedup is saving across the cluster
+func handleDedupStats(ctx context.Context, c *client.Client) {
+	stats, err := c.DedupStats(ctx)
 	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	for _, chunk := range chunks {
-		_, err := file.Write(chunk)
-		if err != nil {
-			return err
-		}
+		log.Fatalf("Failed to get dedup stats: %v", err)
 	}
-	return nil
+	fmt.Printf("chunks: %d referenced, %d stored\n", stats.LogicalChunks, stats.UniqueChunks)
+	fmt.Printf("bytes:  %d referenced, %d stored, %d saved by dedup\n", stats.LogicalBytes, stats.StoredBytes, stats.SavedBytes)
 }
 
 // ===================================================================
@@ -312,33 +207,186 @@ func reassembleFile(chunks [][]byte, saveAs string) error {
 //
 // ===================================================================
 
+func usage() {
+	fmt.Println("Usage: go run ./cmd/client/ [flags] [command] [args]")
+	fmt.Println("  upload [file_to_upload] [remote_path]")
+	fmt.Println("  download [remote_path] [save_as_path]")
+	fmt.Println("  cat [--offset N] [--length N] [remote_path]")
+	fmt.Println("  delete [remote_path]")
+	fmt.Println("  mkdir [remote_dir]")
+	fmt.Println("  ls [remote_dir]")
+	fmt.Println("  ls -p [path_prefix]")
+	fmt.Println("  stat [remote_path]")
+	fmt.Println("  mv [src] [dst]")
+	fmt.Println("  rm [-r] [remote_path]")
+	fmt.Println("  setrep [replicas|policy] [remote_path]")
+	fmt.Println("  dedup")
+	fmt.Println("Storage policies: " + policyNames())
+	fmt.Println("Flags:")
+	flag.PrintDefaults()
+	os.Exit(1)
+}
+
 func main() {
-	if len(os.Args) < 3 {
-		fmt.Println("Usage: go run ./client/ [upload|download] [file_path]")
-		fmt.Println("  upload [file_to_upload]")
-		fmt.Println("  download [filename_to_download] [save_as_path]")
-		os.Exit(1)
+	lbAddr := flag.String("lb", client.DefaultLBAddress, "address of the load balancer")
+	chunkSize := flag.Int("chunk-size", client.DefaultChunkSize, "size of the chunks uploaded files are split into, in bytes")
+	replication := flag.Int("replication", 0, "replicas of every uploaded chunk, 0 uses the load balancer's default")
+	journalDir := flag.String("journal-dir", defaultJournalDir(), "where uploads in progress are journaled so they can be resumed, empty disables it")
+	erasureCoding := flag.String("erasure", "", "store uploads erasure coded instead of replicated, as data+parity shards (e.g. 6+3)")
+	chunker := flag.String("chunker", shared.ChunkerFixed, "how uploads are cut into chunks: fixed, or fastcdc (content defined, -chunk-size on average, 4x smaller to 4x bigger)")
+	policy := flag.String("policy", "", "storage policy of uploaded files (e.g. hot-3x, cold-ec), overrides -replication and -erasure")
+	compress := flag.String("compress", "", "compress the chunks of uploaded files: gzip (chunks that don't shrink are stored as they are)")
+	consistency := flag.String("consistency", "", "how fresh metadata reads have to be: linearizable, lease (the default) or stale (any namenode answers)")
+	keyFile := flag.String("key-file", "", "master key file, uploads are encrypted with it and downloads decrypted (default $FOODO_MASTER_KEY, in hex)")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		usage()
+	}
+
+	var dataShards, parityShards int
+	if *erasureCoding != "" {
+		if _, err := fmt.Sscanf(*erasureCoding, "%d+%d", &dataShards, &parityShards); err != nil {
+			log.Fatalf("Bad -erasure %q, want data+parity shards like 6+3", *erasureCoding)
+		}
+	}
+
+	// FastCDC with its usual spread around the average size
+	avgSize := 0
+	switch *chunker {
+	case shared.ChunkerFixed:
+	case shared.ChunkerFastCDC:
+		avgSize = *chunkSize
+	default:
+		log.Fatalf("Bad -chunker %q, want %s or %s", *chunker, shared.ChunkerFixed, shared.ChunkerFastCDC)
+	}
+
+	masterKey, err := loadMasterKey(*keyFile)
+	if err != nil {
+		log.Fatalf("Bad master key: %v", err)
 	}
 
-	command := os.Args[1]
-	
+	c := client.New(
+		client.WithLBAddress(*lbAddr),
+		client.WithChunkSize(*chunkSize),
+		client.WithReplication(*replication),
+		client.WithLogger(log.Default()),
+		client.WithJournalDir(*journalDir),
+		client.WithErasureCoding(dataShards, parityShards),
+		client.WithPolicy(*policy),
+		client.WithContentDefinedChunking(avgSize/4, avgSize, avgSize*4),
+		client.WithMasterKey(masterKey),
+		client.WithCompression(*compress),
+		client.WithReadConsistency(*consistency),
+	)
+	ctx := context.Background()
+
+	command := flag.Arg(0)
+	args := flag.Args()[1:]
+
 	switch command {
 	case "upload":
-		if len(os.Args) < 3 {
-			log.Fatal("Usage: go run ./client/ upload [file_to_upload]")
+		if len(args) < 1 {
+			log.Fatal("Usage: go run ./cmd/client/ upload [file_to_upload] [remote_path]")
 		}
-		filePath := os.Args[2]
-		handleUpload(filePath)
+		filePath := args[0]
+		// without a remote path the file keeps its local (relative) path
+		target := remotePath(filePath)
+		if len(args) > 1 {
+			target = args[1]
+		}
+		handleUpload(ctx, c, filePath, target)
 
 	case "download":
-		if len(os.Args) < 4 {
-			log.Fatal("Usage: go run ./client/ download [filename_to_download] [save_as_path]")
+		if len(args) < 2 {
+			log.Fatal("Usage: go run ./cmd/client/ download [remote_path] [save_as_path]")
+		}
+		handleDownload(ctx, c, args[0], args[1])
+
+	case "cat":
+		catFlags := flag.NewFlagSet("cat", flag.ExitOnError)
+		offset := catFlags.Int64("offset", 0, "byte of the file to start at")
+		length := catFlags.Int64("length", -1, "how many bytes to read, -1 reads to the end of the file")
+		catFlags.Parse(args)
+		if catFlags.NArg() < 1 {
+			log.Fatal("Usage: go run ./cmd/client/ cat [--offset N] [--length N] [remote_path]")
+		}
+		handleCat(ctx, c, catFlags.Arg(0), *offset, *length)
+
+	case "delete":
+		if len(args) < 1 {
+			log.Fatal("Usage: go run ./cmd/client/ delete [remote_path]")
+		}
+		if err := c.Delete(ctx, args[0]); err != nil {
+			log.Fatalf("Failed to delete %s: %v", args[0], err)
+		}
+		log.Printf("Deleted %s\n", args[0])
+
+	case "mkdir":
+		if len(args) < 1 {
+			log.Fatal("Usage: go run ./cmd/client/ mkdir [remote_dir]")
+		}
+		if err := c.Mkdir(ctx, args[0]); err != nil {
+			log.Fatalf("Failed to create %s: %v", args[0], err)
+		}
+		log.Printf("Created %s\n", args[0])
+
+	case "ls":
+		// -p lists every file under a path prefix instead of a single directory
+		if len(args) > 0 && args[0] == "-p" {
+			prefix := "/"
+			if len(args) > 1 {
+				prefix = args[1]
+			}
+			handleListPrefix(ctx, c, prefix)
+			return
+		}
+		dir := "/"
+		if len(args) > 0 {
+			dir = args[0]
 		}
-		fileName := os.Args[2]
-		saveAs := os.Args[3]
-		handleDownload(fileName, saveAs)
-		
+		handleList(ctx, c, dir)
+
+	case "stat":
+		if len(args) < 1 {
+			log.Fatal("Usage: go run ./cmd/client/ stat [remote_path]")
+		}
+		handleStat(ctx, c, args[0])
+
+	case "mv":
+		if len(args) < 2 {
+			log.Fatal("Usage: go run ./cmd/client/ mv [src] [dst]")
+		}
+		if err := c.Rename(ctx, args[0], args[1]); err != nil {
+			log.Fatalf("Failed to move %s: %v", args[0], err)
+		}
+		log.Printf("Moved %s to %s\n", args[0], args[1])
+
+	case "rm":
+		recursive := len(args) > 0 && args[0] == "-r"
+		if recursive {
+			args = args[1:]
+		}
+		if len(args) < 1 {
+			log.Fatal("Usage: go run ./cmd/client/ rm [-r] [remote_path]")
+		}
+		if err := c.Remove(ctx, args[0], recursive); err != nil {
+			log.Fatalf("Failed to remove %s: %v", args[0], err)
+		}
+		log.Printf("Removed %s\n", args[0])
+
+	case "setrep":
+		if len(args) < 2 {
+			log.Fatal("Usage: go run ./cmd/client/ setrep [replicas|policy] [remote_path]")
+		}
+		handleSetReplication(ctx, c, args[0], args[1])
+
+	case "dedup":
+		handleDedupStats(ctx, c)
+
 	default:
-		log.Fatalf("Unknown command: %s. Use 'upload' or 'download'.", command)
+		log.Printf("Unknown command: %s", command)
+		usage()
 	}
 }
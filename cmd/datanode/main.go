@@ -4,6 +4,7 @@ import (
 	"flag"
 	"log"
 	"os"
+	"time"
 	"github.com/Rahul6700/Foodo/datanode" 
 	"github.com/gin-gonic/gin"
 )
@@ -15,6 +16,11 @@ var (
 	dataDir = flag.String("data-dir", "dn-data-1", "Data directory for chunks")
 	//The addr (url) of the loadb
 	lbAddr = flag.String("lb-addr", "", "Load Balancer address, sumn like -> http://192.168.1.10:8000)")
+	// how often every stored chunk is re-hashed to catch bit rot, and how fast (in MB/s) the scrubber may read
+	scrubInterval = flag.Duration("scrub-interval", time.Hour, "How often all chunks are re-verified")
+	scrubRate = flag.Int64("scrub-rate", 10, "Max scrubber read rate in MB/s")
+	// how often the full list of stored chunks is sent to the LB
+	blockReportInterval = flag.Duration("block-report-interval", time.Minute, "How often a block report is sent")
 )
 
 func main() {
@@ -28,13 +34,20 @@ func main() {
 	// we start the hearBeat sending process in the BG using a goroutine
 	// we give it the LB addr so it can send there and the public port on which it can recieve responeses
 	go datanode.StartHeartBeat(*lbAddr, *apiAddr)
+	// the scrubber too, corrupt chunks it finds are reported through the heartbeat
+	go datanode.StartScrubber(*dataDir, *scrubInterval, *scrubRate*1024*1024)
+	// and the block reports, the first one goes out right away
+	go datanode.StartBlockReports(*lbAddr, *apiAddr, *dataDir, *blockReportInterval)
 
-	api := datanode.NewApiServer(*dataDir)
+	api := datanode.NewApiServer(*dataDir, datanode.NodeURL(*apiAddr))
 	
 	r := gin.Default()
 	// Pass the dataDir to the route handlers so they know where to save files
 	r.POST("/writeChunk/:chunkID", api.HandleWriteChunk)
 	r.GET("/readChunk/:chunkID", api.HandleReadChunk)
+	r.GET("/verifyChunk/:chunkID", api.HandleVerifyChunk)
+	r.DELETE("/chunk/:chunkID", api.HandleDeleteChunk)
+	r.POST("/replicateChunk/:chunkID", api.HandleReplicateChunk)
 
 	log.Printf("Datanode API server starting on %s\n", *apiAddr)
 	// We listen on 0.0.0.0 to be reachable from other machines
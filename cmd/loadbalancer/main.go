@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"strings"
+	"time"
+	"github.com/Rahul6700/Foodo/loadbalancer"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// the public facing port, the client and the DN's both point here
+	apiAddr = flag.String("api-addr", ":8000", "API address (e.g., :8000)")
+	// comma separated api addresses of the namenodes, the LB finds the leader among them
+	namenodes = flag.String("namenodes", "http://localhost:8001,http://localhost:8002,http://localhost:8003", "Comma separated namenode API addresses")
+	// how many DN's every chunk gets written to
+	replication = flag.Int("replication", 3, "Number of replicas per chunk, for files that dont set their own")
+	// how many replicas of every chunk have to be stored before an upload can be committed
+	minReplicas = flag.Int("min-replicas", 2, "Replicas every chunk needs before an upload can be committed")
+	// how often the garbage collector looks for chunks that no file uses anymore
+	gcInterval = flag.Duration("gc-interval", 30*time.Second, "How often unused chunks are deleted from the datanodes")
+	// a DN that misses heartbeats for this long is dead, and its chunks get copied to other DN's
+	deadTimeout = flag.Duration("dead-timeout", 15*time.Second, "How long a datanode can miss heartbeats before it is considered dead")
+	// how often we look for chunks that lost replicas
+	replicationInterval = flag.Duration("replication-interval", 10*time.Second, "How often chunks are brought back to the replicas their files want")
+	// uploads that were neither committed nor aborted after this long are dropped, along with their pending file
+	uploadTTL = flag.Duration("upload-ttl", 24*time.Hour, "How long an upload can stay uncommitted before it is expired")
+	// how often we look for those
+	uploadExpiryInterval = flag.Duration("upload-expiry-interval", time.Minute, "How often abandoned uploads are expired")
+)
+
+func main() {
+	flag.Parse()
+
+	var namenodeAddrs []string
+	for _, addr := range strings.Split(*namenodes, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			namenodeAddrs = append(namenodeAddrs, addr)
+		}
+	}
+	if len(namenodeAddrs) == 0 {
+		log.Fatal("at least one namenode address is required")
+	}
+	if *replication < 1 {
+		log.Fatal("replication must be at least 1")
+	}
+	if *minReplicas < 1 || *minReplicas > *replication {
+		log.Fatal("min-replicas must be between 1 and replication")
+	}
+
+	gin.SetMode(gin.ReleaseMode)
+	r := gin.Default()
+
+	api := loadbalancer.NewApiServer(namenodeAddrs, *replication, *minReplicas, *deadTimeout)
+	api.RegisterRoutes(r)
+
+	// the garbage collector, the re-replication and the upload expiry run in the BG for as long as the LB is up
+	go api.StartGarbageCollector(*gcInterval)
+	go api.StartReplicationManager(*replicationInterval)
+	go api.StartUploadExpiry(*uploadExpiryInterval, *uploadTTL)
+
+	log.Printf("Load balancer starting on %s\n", *apiAddr)
+	if err := r.Run(*apiAddr); err != nil {
+		log.Fatalf("Load balancer failed: %s", err)
+	}
+}
@@ -2,9 +2,11 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
 	"net"
 	"os"
+	"strings"
 	"time"
 	"github.com/Rahul6700/Foodo/namenode"
 	"path/filepath"
@@ -18,13 +20,43 @@ import (
 // so we extract all these flags from the go run command and use them elsewhere (to create our raft node)
 // this is just a reusable var to store those flags
 var (
-	nodeID    = flag.String("id", "", "Node ID") // unique ID for the node
-	apiAddr   = flag.String("api-addr", ":8001", "API address") // the public IP add for the Gin API endpoint (8001 is the fallback value incase users dont specify in the go run cmd)
-	raftAddr  = flag.String("raft-addr", "localhost:7001", "Raft address") // a pvt address for raft nodes to talk to each other
-	dataDir   = flag.String("data-dir", "data-1", "Data directory") // the dir where we store the namenodes's data (given )
-	bootstrap = flag.Bool("bootstrap", false, "Bootstrap cluster") // bootstrap flag with value as true or false, true if this is the first node to start (automatically becomes leader without election)
+	nodeID      = flag.String("id", "", "Node ID") // unique ID for the node
+	apiAddr     = flag.String("api-addr", ":8001", "API address") // the public IP add for the Gin API endpoint (8001 is the fallback value incase users dont specify in the go run cmd)
+	raftAddr    = flag.String("raft-addr", "localhost:7001", "Raft address") // a pvt address for raft nodes to talk to each other
+	dataDir     = flag.String("data-dir", "data-1", "Data directory") // the dir where we store the namenodes's data (given )
+	bootstrap   = flag.Bool("bootstrap", false, "Bootstrap cluster") // bootstrap flag with value as true or false, true if this is the first node to start (automatically becomes leader without election)
+	peers       = flag.String("peers", "nn-1=localhost:7001,nn-2=localhost:7002,nn-3=localhost:7003", "Comma separated id=raft-addr of the namenodes the cluster is bootstrapped with") // only read with -bootstrap
+	join        = flag.String("join", "", "Comma separated API addresses of existing namenodes to join the cluster through") // -> http://localhost:8001, for nodes added after the bootstrap
+	nonvoter    = flag.Bool("nonvoter", false, "Join as a non voter") // gets the log but doesnt vote, e.g. a read replica in another DC
+	minReplicas = flag.Int("min-replicas", 1, "Replicas every chunk needs before an upload is committed, whatever the LB asks for") // the durability floor, the LB can only ask for more
+	advertise   = flag.String("advertise-addr", "", "Address other namenodes and the LB reach our API at (default http://localhost<api-addr port>)") // followers forward requests to the leader's
 )
 
+// the API address we tell the others about, -api-addr is only what we listen on (":8001" has no host to reach it at)
+func advertiseAddr() string {
+	if *advertise != "" {
+		return strings.TrimRight(*advertise, "/")
+	}
+	host, port, err := net.SplitHostPort(*apiAddr)
+	if err != nil || host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+	return "http://" + net.JoinHostPort(host, port)
+}
+
+// turns the -peers flag into the servers of the bootstrap configuration -> "nn-1=localhost:7001,nn-2=localhost:7002"
+func parsePeers(list string) ([]raft.Server, error) {
+	var servers []raft.Server
+	for _, peer := range strings.Split(list, ",") {
+		id, addr, ok := strings.Cut(strings.TrimSpace(peer), "=")
+		if !ok || id == "" || addr == "" {
+			return nil, fmt.Errorf("bad peer %q, want id=raft-addr", peer)
+		}
+		servers = append(servers, raft.Server{ID: raft.ServerID(id), Address: raft.ServerAddress(addr)})
+	}
+	return servers, nil
+}
+
 func main(){
 	// first read all the flags from the run cmd
 	flag.Parse()
@@ -32,6 +64,9 @@ func main(){
 		log.Println("nodeID not set")
 		return
 	}
+	if *bootstrap && *join != "" {
+		log.Fatalf("-bootstrap starts a new cluster and -join joins an existing one, use one of them")
+	}
 
 	// setup
 	config := raft.DefaultConfig()
@@ -67,6 +102,13 @@ func main(){
 		log.Fatalf("error creating pvt TCP conn for NN's : %s", err)
 	}
 
+	// a node that already has raft state is a member already (it was bootstrapped or joined before and is restarting),
+	// the configuration is in its log, so -bootstrap and -join are skipped for it
+	hasState, err := raft.HasExistingState(logStore, stableStore, snapshotStore)
+	if err != nil {
+		log.Fatalf("error reading raft state: %s", err)
+	}
+
 	//create a new fsm
 	fsm := namenode.NewFsm()
 
@@ -87,32 +129,50 @@ func main(){
 
 	// we check if the bootsrap flag has been provided
 	// if it has. then it means this is the first node created and hence it directly becomes the leader node
-	// we give it a "guest-list" basically of all the nodes which can be allowed as namenodes
+	// we give it a "guest-list" basically of all the nodes which can be allowed as namenodes (-peers, it has to include this node)
 	// the bootstrapped node write this list to its logs.dat and becomes leader
 	// it does not call or try communicating to the other nodes, so even if they are not active, its fine. it just stores the list
-	if *bootstrap {
-		log.Printf("Bootstrapping using NN:%s", nodeID)
-		cfg := raft.Configuration{
-			Servers: []raft.Server{
-				{ ID: "nn-1", Address: raft.ServerAddress("localhost:7001") },
-				{ ID: "nn-2", Address: raft.ServerAddress("localhost:7002") },
-				{ ID: "nn-3", Address: raft.ServerAddress("localhost:7003") },
-			},
+	if *bootstrap && hasState {
+		log.Printf("NN:%s already has raft state, not bootstrapping again", *nodeID)
+	} else if *bootstrap {
+		log.Printf("Bootstrapping using NN:%s", *nodeID)
+		servers, err := parsePeers(*peers)
+		if err != nil {
+			log.Fatalf("bad -peers: %s", err)
+		}
+		self := false
+		for _, server := range servers {
+			self = self || server.ID == config.LocalID
 		}
-		f := raftNode.BootstrapCluster(cfg)
+		if !self {
+			log.Fatalf("-peers has to include this node (%s)", *nodeID)
+		}
+		f := raftNode.BootstrapCluster(raft.Configuration{Servers: servers})
 
 		if err := f.Error(); err != nil {
 			log.Fatalf("failed to bootstrap cluster: %s", err)
 		}
 	}
 
+	// a node started with -join asks the cluster's leader to add it, the leader then replicates the log (or a snapshot) to it
+	if *join != "" && hasState {
+		log.Printf("NN:%s already has raft state, not joining again", *nodeID)
+	} else if *join != "" {
+		req := namenode.JoinRequest{ID: *nodeID, RaftAddr: *raftAddr, APIAddr: advertiseAddr(), Nonvoter: *nonvoter}
+		if err := namenode.Join(strings.Split(*join, ","), req, time.Minute); err != nil {
+			log.Fatalf("failed to join the cluster: %s", err)
+		}
+	}
+
 	// set up a gin endpoint for the cluster to listen publically
 	gin.SetMode(gin.ReleaseMode)
 	r := gin.Default()
 
 	// "inject" the Raft engine into the API server
-	apiServer := namenode.NewApiServer(raftNode, fsm) // NewApiServer is the method in api.go that creates a new an api server and passes the raft engine by referrence to it, now the api server has a ptr to the raft engine that it can use to serve
+	apiServer := namenode.NewApiServer(raftNode, fsm, advertiseAddr(), config.LeaderLeaseTimeout, *minReplicas) // NewApiServer is the method in api.go that creates a new an api server and passes the raft engine by referrence to it, now the api server has a ptr to the raft engine that it can use to serve
 	apiServer.RegisterRoutes(r) // we now pass the router too
+	// whenever this node is the leader it makes sure its API address is in the FSM, so the followers can forward to it
+	go apiServer.StartLeaderRegistration(2 * time.Second)
 
 	log.Printf("API server starting on %s\n", *apiAddr)
 	
@@ -1,26 +1,38 @@
 package datanode
 
 import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"log"
+	"net/http"
+	"time"
 	"io"
 	"os"
 	"path/filepath"
 	"sync/atomic"
+	"github.com/Rahul6700/Foodo/shared"
 	"github.com/gin-gonic/gin"
 )
 
+// uploads land in "<chunkID>.tmp-<random>" until they are verified and renamed
+const tmpSuffix = ".tmp-"
+
 // atomic int a thread safe int (no race conditions as only one thread can modify at a time)
 // we use it to count active writes for the DN
 var ActiveWrites atomic.Int32
 
 type ApiServer struct {
 	dataDir string // Holds the path to the data directory
+	myURL   string // our own url (see NodeURL), reported back in pipeline acks
 }
 
 // NewApiServer is the constructor
-func NewApiServer(dataDir string) *ApiServer {
+func NewApiServer(dataDir, myURL string) *ApiServer {
 	os.MkdirAll(dataDir, 0700) //idempotent data dir creation
-	return &ApiServer{dataDir: dataDir}
+	return &ApiServer{dataDir: dataDir, myURL: myURL}
 }
 
 func(s *ApiServer)HandleWriteChunk(c* gin.Context){
@@ -30,28 +42,178 @@ func(s *ApiServer)HandleWriteChunk(c* gin.Context){
 	defer ActiveWrites.Add(-1)
 
 	chunkID := c.Param("chunkID") // reads the chunk ID from the URL (query param)
+	if !validChunkID(chunkID) {
+		c.JSON(400, gin.H{"error": "bad chunk id " + chunkID})
+		return
+	}
+
+	if err := s.storeChunk(chunkID, c.Request.Body); err != nil {
+		// a body that doesnt match its ID is the sender's fault, not ours
+		if errors.Is(err, ErrChecksumMismatch) {
+			c.JSON(400, gin.H{"error" : err.Error()})
+			return
+		}
+		c.JSON(500, gin.H{"error" : err.Error()})
+		return
+	}
+	log.Printf("successfully wrote chunk %s\n", chunkID)
+
+	// in pipeline mode the client only sent the chunk to us, and we pass it down the chain (see pipeline.go)
+	if pipeline := c.GetHeader(shared.PipelineHeader); pipeline != "" {
+		s.forwardPipeline(c, chunkID, pipeline)
+		return
+	}
+
+	c.JSON(200, gin.H{"success" : true, "stored": []string{s.myURL}})
+}
+
+// writes everything from r to the chunk's file in the dataDir
+// the bytes go to a temp file first and are hashed on the way, the temp file only replaces the chunk's file if the hash matches the ID
+// so a bad or half finished upload never leaves a broken chunk behind
+func (s *ApiServer) storeChunk(chunkID string, r io.Reader) error {
+	if !validChunkID(chunkID) {
+		return fmt.Errorf("bad chunk id %s", chunkID)
+	}
 	filePath := filepath.Join(s.dataDir, chunkID) // we create the file path (/dn-1/chunkID)
 
-	file, err := os.Create(filePath)
+	file, err := os.CreateTemp(s.dataDir, chunkID+tmpSuffix+"*")
 	if err != nil {
-		c.JSON(500, gin.H{"error" : "couldnt create file in DN for" + chunkID})
-		return
+		return fmt.Errorf("couldnt create file in DN for %s", chunkID)
 	}
+	defer os.Remove(file.Name()) // no-op once it has been renamed
 	defer file.Close()
 
-	// we use io.Copy(destination, source) to write the content from the req body to the file
-	_, err = io.Copy(file, c.Request.Body)
+	// we use io.Copy(destination, source) to write the content from the req body to the file (and the hash)
+	hash := sha1.New()
+	if _, err = io.Copy(io.MultiWriter(file, hash), r); err != nil {
+		return fmt.Errorf("count not write content to file in DN for chunk: %s", chunkID)
+	}
+	if err := verifyChecksum(chunkID, hex.EncodeToString(hash.Sum(nil))); err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("count not write content to file in DN for chunk: %s", chunkID)
+	}
+	return os.Rename(file.Name(), filePath)
+}
+
+// serves a chunk, after checking it still hashes to its ID
+// a chunk that doesnt is never sent out, the caller gets a 500 and should go to another replica
+func (s *ApiServer) HandleReadChunk(c* gin.Context){
+	chunkID := c.Param("chunkID") // again extract chunkID from req param
+	if !validChunkID(chunkID) {
+		c.JSON(400, gin.H{"error": "bad chunk id " + chunkID})
+		return
+	}
+	filePath := filepath.Join(s.dataDir, chunkID)
+
+	data, err := os.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		c.JSON(404, gin.H{"error": "chunk " + chunkID + " not found"})
+		return
+	}
 	if err != nil {
-		c.JSON(500, gin.H{"error" : "count not write content to file in DN for chunk:" + chunkID})
+		c.JSON(500, gin.H{"error": "could not read chunk " + chunkID})
+		return
+	}
+	if err := verifyChecksum(chunkID, chunkChecksum(data)); err != nil {
+		log.Printf("refusing to serve corrupt chunk: %s\n", err)
+		quarantineChunk(s.dataDir, chunkID) // no point waiting for the scrubber to find it
+		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
 
-	log.Printf("successfully wrote chunk %s\n", chunkID)
-	c.JSON(200, gin.H{"success" : true})
+	// ServeContent sets the right headers for us (and handles Range requests)
+	// a Range request still reads and verifies the whole chunk, the client can't check the sha1 of just a part of it
+	http.ServeContent(c.Writer, c.Request, chunkID, time.Time{}, bytes.NewReader(data))
 }
 
-func (s *ApiServer) HandleReadChunk(c* gin.Context){
-	chunkID := c.Param("chunkID") // again extract chunkID from req param
+// checks our copy of a chunk without sending it -> 200 if it is fine, 404 if we dont have it, 409 if it is corrupt
+// the LB asks before it drops a replica a client reported as bad, the client's copy may have been damaged on the way
+func (s *ApiServer) HandleVerifyChunk(c *gin.Context) {
+	chunkID := c.Param("chunkID")
+	if !validChunkID(chunkID) {
+		c.JSON(400, gin.H{"error": "bad chunk id " + chunkID})
+		return
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.dataDir, chunkID))
+	if os.IsNotExist(err) {
+		c.JSON(404, gin.H{"error": "chunk " + chunkID + " not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": "could not read chunk " + chunkID})
+		return
+	}
+	if err := verifyChecksum(chunkID, chunkChecksum(data)); err != nil {
+		log.Printf("verify found a corrupt chunk: %s\n", err)
+		quarantineChunk(s.dataDir, chunkID)
+		c.JSON(409, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"success": true})
+}
+
+// deletes a chunk from disk, the LB calls this once no file references the chunk anymore
+// deleting a chunk we dont have is not an error, that way the LB can safely retry
+func (s *ApiServer) HandleDeleteChunk(c *gin.Context) {
+	chunkID := c.Param("chunkID")
+	if !validChunkID(chunkID) {
+		c.JSON(400, gin.H{"error": "bad chunk id " + chunkID})
+		return
+	}
 	filePath := filepath.Join(s.dataDir, chunkID)
-	c.File(filePath) // this method finds the file, handles error, setts correct http headers and puts the file from disk into the req body
+
+	if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+		c.JSON(500, gin.H{"error": "could not delete chunk " + chunkID})
+		return
+	}
+
+	log.Printf("deleted chunk %s\n", chunkID)
+	c.JSON(200, gin.H{"success": true})
+}
+
+// client used when this DN pulls chunks from other DN's
+var peerClient = &http.Client{Timeout: 60 * time.Second}
+
+// copies a chunk from another DN onto this one -> /replicateChunk/:chunkID?source=http://localhost:9002
+// the LB calls this to bring a chunk back up to its replica count after a DN died
+func (s *ApiServer) HandleReplicateChunk(c *gin.Context) {
+	ActiveWrites.Add(1)
+	defer ActiveWrites.Add(-1)
+
+	chunkID := c.Param("chunkID")
+	if !validChunkID(chunkID) {
+		c.JSON(400, gin.H{"error": "bad chunk id " + chunkID})
+		return
+	}
+	source := c.Query("source")
+	if source == "" {
+		c.JSON(400, gin.H{"error": "missing 'source' query parameter"})
+		return
+	}
+
+	resp, err := peerClient.Get(source + "/readChunk/" + chunkID)
+	if err != nil {
+		c.JSON(502, gin.H{"error": "could not reach source " + source})
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		c.JSON(502, gin.H{"error": "source " + source + " returned " + resp.Status})
+		return
+	}
+
+	if err := s.storeChunk(chunkID, resp.Body); err != nil {
+		if errors.Is(err, ErrChecksumMismatch) {
+			c.JSON(502, gin.H{"error": "source " + source + " sent a corrupt copy: " + err.Error()})
+			return
+		}
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	log.Printf("replicated chunk %s from %s\n", chunkID, source)
+	c.JSON(200, gin.H{"success": true})
 }
@@ -0,0 +1,71 @@
+package datanode
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Rahul6700/Foodo/shared"
+)
+
+// StartBlockReports sends the LB the list of every chunk in dataDir right away, and then every interval
+// the LB compares it against the namenodes' metadata to find replicas that went missing and chunks nobody needs anymore
+func StartBlockReports(lbAddr, myApiAddr, dataDir string, interval time.Duration) {
+	myURL := NodeURL(myApiAddr)
+	sendBlockReport(lbAddr, myURL, dataDir)
+
+	ticker := time.NewTicker(interval)
+	for range ticker.C {
+		sendBlockReport(lbAddr, myURL, dataDir)
+	}
+}
+
+func sendBlockReport(lbAddr, myURL, dataDir string) {
+	report, err := buildBlockReport(myURL, dataDir)
+	if err != nil {
+		log.Printf("could not build block report: %s", err)
+		return
+	}
+
+	jsonData, err := json.Marshal(report)
+	if err != nil {
+		log.Printf("%s failed to marshal block report: %s", myURL, err)
+		return
+	}
+
+	resp, err := http.Post(lbAddr+"/blockReport", "application/json", bytes.NewBuffer(jsonData))
+	if err != nil {
+		log.Printf("%s failed to send block report", myURL)
+		return
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("block report for %s is not OK, returned: %s", myURL, resp.Status)
+	}
+}
+
+// lists the chunks in dataDir, same rules as the scrubber: no directories (quarantine) and no in flight uploads
+func buildBlockReport(myURL, dataDir string) (shared.BlockReport, error) {
+	report := shared.BlockReport{NodeID: myURL, Chunks: []shared.BlockReportItem{}}
+
+	entries, err := os.ReadDir(dataDir)
+	if err != nil {
+		return report, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || strings.Contains(entry.Name(), tmpSuffix) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue // deleted since we listed the dir
+		}
+		report.Chunks = append(report.Chunks, shared.BlockReportItem{ChunkID: entry.Name(), Size: info.Size()})
+	}
+	return report, nil
+}
@@ -0,0 +1,42 @@
+package datanode
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// chunk ID's are the sha1 of the chunk's bytes (the client names them that way),
+// so the ID is also the checksum: we check it when a chunk comes in and every time it goes out
+
+// returned (wrapped) when a chunk's bytes dont hash to its ID
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// returns the hex sha1 of data, same as the client's sha1sum
+func chunkChecksum(data []byte) string {
+	sum := sha1.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// a chunk ID is 40 lowercase hex digits, anything else is refused before it gets anywhere near a file path
+// ("../x" or "a/b" would otherwise read, write or delete files outside the data dir)
+func validChunkID(chunkID string) bool {
+	if len(chunkID) != 2*sha1.Size {
+		return false
+	}
+	for _, c := range chunkID {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// checks a hex sha1 against the chunk ID
+func verifyChecksum(chunkID, actual string) error {
+	if actual != chunkID {
+		return fmt.Errorf("chunk %s hashes to %s: %w", chunkID, actual, ErrChecksumMismatch)
+	}
+	return nil
+}
@@ -9,13 +9,20 @@ import (
 	"github.com/Rahul6700/Foodo/shared"
 )
 
-func StartHeartBeat(lbAddr, myApiAddr string){
+// NodeURL is the url other components reach this DN on, it doubles as the DN's ID everywhere in the cluster
+func NodeURL(myApiAddr string) string {
 	// hardcoding the DN's IP (the system running the DN)
 	const IP_addr = "localhost"
+	return "http://" + IP_addr + myApiAddr // myApiAddr is the port on which the DN is running
+}
 
+func StartHeartBeat(lbAddr, myApiAddr string){
 	// this is the nodeID that we will send the loadb
-	myURL := "http://" + IP_addr + myApiAddr // myApiAddr is the port on which the DN is running
+	myURL := NodeURL(myApiAddr)
 	log.Printf("DN %s is sending heartbeat", myURL)
+	knownPeers.lock.Lock()
+	knownPeers.lbAddr = lbAddr
+	knownPeers.lock.Unlock()
 
 	// creating a new ticker obj that triggers every 5 seconds
 	ticker := time.NewTicker(5*time.Second)
@@ -25,9 +32,11 @@ func StartHeartBeat(lbAddr, myApiAddr string){
 		load := ActiveWrites.Load()
 
 		// create the req payload
+		corrupt := pendingCorrupt()
 		payload := shared.HeartbeatPayload {
 			NodeID: myURL,
 			ActiveWrites: int(load),
+			CorruptChunks: corrupt,
 		}
 
 		// convert it to JSON form
@@ -43,10 +52,15 @@ func StartHeartBeat(lbAddr, myApiAddr string){
 			continue // skip this ticker and continue from next
 		}
 
+		var answer shared.HeartbeatResponse
+		json.NewDecoder(resp.Body).Decode(&answer)
 		resp.Body.Close()
 
 		if resp.StatusCode != http.StatusOK {
-			log.Printf("heartbeat for %s is not OK, returned: " +resp.Status)
+			log.Printf("heartbeat for %s is not OK, returned: %s", myURL, resp.Status)
+			continue // the corrupt chunks (if any) go out again with the next one
 		}
+		clearCorrupt(corrupt)
+		setPeers(answer.Peers) // who we may forward pipelined writes to
 	}
 }
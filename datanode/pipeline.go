@@ -0,0 +1,158 @@
+package datanode
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Rahul6700/Foodo/shared"
+	"github.com/gin-gonic/gin"
+)
+
+// pipelined writes:
+// instead of the client sending every replica itself (client -> DN1, client -> DN2, client -> DN3),
+// it sends the chunk once to DN1 with "DN2,DN3" in the pipeline header, DN1 stores it and sends it to DN2 with "DN3",
+// DN2 does the same for DN3, and the acks travel back up the chain -> the client only uploads each chunk once.
+// a DN that is down is skipped (DN1 sends to DN3 if DN2 can't be reached), the ack only lists the DN's that stored it
+
+// the DN's we forward pipelined writes to, the LB sends the list back with every heartbeat
+// the pipeline header comes from the client, without this it could make us send requests to any url it likes
+var knownPeers = struct {
+	lock      sync.Mutex
+	urls      map[string]bool
+	lbAddr    string    // where to ask for the list between heartbeats, set by StartHeartBeat
+	refreshed time.Time // when we last asked
+}{urls: make(map[string]bool)}
+
+// we ask the LB at most this often, a pipeline naming DN's it doesnt know either can't make us hammer it
+const peerRefreshInterval = time.Second
+
+func setPeers(urls []string) {
+	knownPeers.lock.Lock()
+	defer knownPeers.lock.Unlock()
+	knownPeers.urls = make(map[string]bool, len(urls))
+	for _, u := range urls {
+		knownPeers.urls[u] = true
+	}
+}
+
+// whether next is a DN the LB told us about
+// one we dont know may have joined since our last heartbeat (or we only just started), so we ask the LB again
+func isPeer(next string) bool {
+	knownPeers.lock.Lock()
+	known, lbAddr := knownPeers.urls[next], knownPeers.lbAddr
+	refresh := !known && lbAddr != "" && time.Since(knownPeers.refreshed) >= peerRefreshInterval
+	if refresh {
+		knownPeers.refreshed = time.Now()
+	}
+	knownPeers.lock.Unlock()
+	if known || !refresh {
+		return known
+	}
+
+	resp, err := peerClient.Get(lbAddr + "/datanodes")
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	var answer struct {
+		Datanodes []string `json:"datanodes"`
+	}
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&answer) != nil {
+		return false
+	}
+	setPeers(answer.Datanodes)
+	return slices.Contains(answer.Datanodes, next)
+}
+
+// checks next is a DN we may forward a chunk to: a plain http(s) url (scheme and host, nothing else) the LB told us about
+func checkPeer(next string) error {
+	u, err := url.Parse(next)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User != nil ||
+		u.Path != "" || u.RawQuery != "" || u.Fragment != "" {
+		return fmt.Errorf("bad datanode url %q", next)
+	}
+	if !isPeer(next) {
+		return fmt.Errorf("%s is not a known datanode", next)
+	}
+	return nil
+}
+
+// called after the chunk is already on our disk, sends it to the next DN in the pipeline and waits for the rest of the chain
+// a next DN that is unknown, can't be reached or stored nothing is skipped, and the one after it gets the chunk instead
+// answers 200 only if every DN down the chain stored the chunk, otherwise 502,
+// in both cases with the list of DN's that did store it so the client knows what actually landed
+func (s *ApiServer) forwardPipeline(c *gin.Context, chunkID, pipeline string) {
+	targets := strings.Split(pipeline, ",")
+	for i := range targets {
+		targets[i] = strings.TrimSpace(targets[i])
+	}
+
+	stored := []string{s.myURL}
+	var failures []string
+	for i, next := range targets {
+		if next == s.myURL {
+			continue // we have it already
+		}
+		if err := checkPeer(next); err != nil {
+			failures = append(failures, err.Error())
+			continue
+		}
+		downstream, err := s.sendToNext(chunkID, next, strings.Join(targets[i+1:], ","))
+		if err != nil {
+			failures = append(failures, err.Error())
+		}
+		if len(downstream) > 0 {
+			// next stored it and took care of the rest of the chain
+			stored = append(stored, downstream...)
+			break
+		}
+		log.Printf("skipping %s in the pipeline of chunk %s: %s", next, chunkID, err)
+	}
+	if len(failures) > 0 {
+		c.JSON(http.StatusBadGateway, shared.WriteChunkResponse{Stored: stored, Error: strings.Join(failures, "; ")})
+		return
+	}
+	c.JSON(http.StatusOK, shared.WriteChunkResponse{Success: true, Stored: stored})
+}
+
+// streams our stored copy of the chunk to the next DN, and returns the DN's that ended up storing it further down
+func (s *ApiServer) sendToNext(chunkID, next, rest string) ([]string, error) {
+	file, err := os.Open(filepath.Join(s.dataDir, chunkID))
+	if err != nil {
+		return nil, fmt.Errorf("could not reopen chunk %s: %w", chunkID, err)
+	}
+	defer file.Close()
+
+	req, err := http.NewRequest(http.MethodPost, next+"/writeChunk/"+chunkID, file)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if rest != "" {
+		req.Header.Set(shared.PipelineHeader, rest)
+	}
+
+	resp, err := peerClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not reach %s: %w", next, err)
+	}
+	defer resp.Body.Close()
+
+	var ack shared.WriteChunkResponse
+	if err := json.NewDecoder(resp.Body).Decode(&ack); err != nil {
+		return nil, fmt.Errorf("bad ack from %s: %w", next, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return ack.Stored, fmt.Errorf("pipeline failed at %s: %s", next, ack.Error)
+	}
+	return ack.Stored, nil
+}
@@ -0,0 +1,126 @@
+package datanode
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Rahul6700/Foodo/shared"
+	"github.com/gin-gonic/gin"
+)
+
+// newTestDN starts a DN with its own data dir, its url is its id like everywhere in the cluster
+func newTestDN(t *testing.T) (*httptest.Server, string) {
+	gin.SetMode(gin.TestMode)
+	dir := t.TempDir()
+	var api *ApiServer
+	r := gin.New()
+	r.POST("/writeChunk/:chunkID", func(c *gin.Context) { api.HandleWriteChunk(c) })
+	srv := httptest.NewServer(r)
+	api = NewApiServer(dir, srv.URL)
+	t.Cleanup(srv.Close)
+	return srv, dir
+}
+
+func TestForwardPipeline(t *testing.T) {
+	dn1, dir1 := newTestDN(t)
+	dn2, dir2 := newTestDN(t)
+	dn3, dir3 := newTestDN(t)
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+	setPeers([]string{dn1.URL, dn2.URL, dn3.URL, dead.URL})
+	t.Cleanup(func() { setPeers(nil) })
+
+	data := []byte("pipelined chunk")
+	sum := sha1.Sum(data)
+	chunkID := hex.EncodeToString(sum[:])
+
+	tests := []struct {
+		name     string
+		pipeline []string
+		status   int
+		stored   []string
+		dirs     []string // the data dirs that end up holding the chunk
+	}{
+		{"whole chain", []string{dn2.URL, dn3.URL}, http.StatusOK, []string{dn1.URL, dn2.URL, dn3.URL}, []string{dir1, dir2, dir3}},
+		{"dead hop is skipped", []string{dead.URL, dn3.URL}, http.StatusBadGateway, []string{dn1.URL, dn3.URL}, []string{dir1, dir3}},
+		{"dead hop in the middle", []string{dn2.URL, dead.URL, dn3.URL}, http.StatusBadGateway, []string{dn1.URL, dn2.URL, dn3.URL}, []string{dir1, dir2, dir3}},
+		{"unknown host", []string{"http://169.254.169.254", dn2.URL}, http.StatusBadGateway, []string{dn1.URL, dn2.URL}, []string{dir1, dir2}},
+		{"not a datanode url", []string{dn2.URL + "/admin?x=1", "file:///etc/passwd"}, http.StatusBadGateway, []string{dn1.URL}, []string{dir1}},
+	}
+	for _, tt := range tests {
+		for _, dir := range []string{dir1, dir2, dir3} {
+			os.Remove(filepath.Join(dir, chunkID))
+		}
+		req, _ := http.NewRequest(http.MethodPost, dn1.URL+"/writeChunk/"+chunkID, bytes.NewReader(data))
+		req.Header.Set(shared.PipelineHeader, strings.Join(tt.pipeline, ","))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		var ack shared.WriteChunkResponse
+		json.NewDecoder(resp.Body).Decode(&ack)
+		resp.Body.Close()
+
+		if resp.StatusCode != tt.status || strings.Join(ack.Stored, ",") != strings.Join(tt.stored, ",") {
+			t.Errorf("%s: %d stored on %v (%s), want %d on %v", tt.name, resp.StatusCode, ack.Stored, ack.Error, tt.status, tt.stored)
+		}
+		for _, dir := range []string{dir1, dir2, dir3} {
+			_, err := os.Stat(filepath.Join(dir, chunkID))
+			if want := slices.Contains(tt.dirs, dir); (err == nil) != want {
+				t.Errorf("%s: chunk on disk in %s is %t, want %t", tt.name, dir, err == nil, want)
+			}
+		}
+	}
+}
+
+// a DN that joined after our last heartbeat is looked up at the LB, at most once per peerRefreshInterval
+func TestPeersFromLB(t *testing.T) {
+	dn1, _ := newTestDN(t)
+	dn2, dir2 := newTestDN(t)
+	var asked atomic.Int32
+	lb := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		asked.Add(1)
+		json.NewEncoder(w).Encode(map[string][]string{"datanodes": {dn1.URL, dn2.URL}})
+	}))
+	defer lb.Close()
+	setPeers(nil)
+	knownPeers.lock.Lock()
+	knownPeers.lbAddr, knownPeers.refreshed = lb.URL, time.Time{}
+	knownPeers.lock.Unlock()
+	t.Cleanup(func() {
+		setPeers(nil)
+		knownPeers.lock.Lock()
+		knownPeers.lbAddr = ""
+		knownPeers.lock.Unlock()
+	})
+
+	data := []byte("chunk for a new peer")
+	sum := sha1.Sum(data)
+	chunkID := hex.EncodeToString(sum[:])
+	for _, pipeline := range []string{dn2.URL, "http://localhost:1", "http://localhost:2"} {
+		req, _ := http.NewRequest(http.MethodPost, dn1.URL+"/writeChunk/"+chunkID, bytes.NewReader(data))
+		req.Header.Set(shared.PipelineHeader, pipeline)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	if _, err := os.Stat(filepath.Join(dir2, chunkID)); err != nil {
+		t.Fatalf("the chunk was not forwarded to the peer the LB knows: %v", err)
+	}
+	if n := asked.Load(); n != 1 {
+		t.Fatalf("asked the LB %d times, want once", n)
+	}
+}
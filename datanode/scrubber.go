@@ -0,0 +1,107 @@
+package datanode
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// corrupt chunks are moved in here instead of being deleted, so someone can still look at them
+const quarantineDir = "quarantine"
+
+// chunks we found corrupt (by the scrubber or while serving a read) that the LB hasnt been told about yet
+// the heartbeat sends them and clears them once the LB has acknowledged
+var corruptChunks = struct {
+	lock sync.Mutex
+	ids  map[string]bool
+}{ids: make(map[string]bool)}
+
+func reportCorrupt(chunkID string) {
+	corruptChunks.lock.Lock()
+	defer corruptChunks.lock.Unlock()
+	corruptChunks.ids[chunkID] = true
+}
+
+// returns the corrupt chunks that still have to be reported
+func pendingCorrupt() []string {
+	corruptChunks.lock.Lock()
+	defer corruptChunks.lock.Unlock()
+
+	var ids []string
+	for id := range corruptChunks.ids {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// forgets chunks the LB has acknowledged
+func clearCorrupt(ids []string) {
+	corruptChunks.lock.Lock()
+	defer corruptChunks.lock.Unlock()
+	for _, id := range ids {
+		delete(corruptChunks.ids, id)
+	}
+}
+
+// moves a corrupt chunk out of the dataDir into dataDir/quarantine and queues it for the next heartbeat
+// from then on this DN answers 404 for it, and the LB re-replicates it from a healthy copy
+func quarantineChunk(dataDir, chunkID string) {
+	qDir := filepath.Join(dataDir, quarantineDir)
+	os.MkdirAll(qDir, 0700)
+	if err := os.Rename(filepath.Join(dataDir, chunkID), filepath.Join(qDir, chunkID)); err != nil && !os.IsNotExist(err) {
+		log.Printf("could not quarantine chunk %s: %s\n", chunkID, err)
+	}
+	reportCorrupt(chunkID)
+}
+
+// StartScrubber runs forever, every interval it re-reads every chunk in dataDir and checks it still hashes to its ID (bit rot)
+// reading is capped at bytesPerSec so a scrub doesnt starve the actual reads and writes
+func StartScrubber(dataDir string, interval time.Duration, bytesPerSec int64) {
+	ticker := time.NewTicker(interval)
+	for range ticker.C {
+		scrubbed, corrupt := scrubOnce(dataDir, bytesPerSec)
+		log.Printf("scrubber: checked %d chunks, %d corrupt\n", scrubbed, corrupt)
+	}
+}
+
+// one pass over the dataDir, returns how many chunks were checked and how many were corrupt
+func scrubOnce(dataDir string, bytesPerSec int64) (int, int) {
+	entries, err := os.ReadDir(dataDir)
+	if err != nil {
+		log.Printf("scrubber: could not list %s: %s\n", dataDir, err)
+		return 0, 0
+	}
+
+	scrubbed, corrupt := 0, 0
+	for _, entry := range entries {
+		// the quarantine dir and uploads that are still in flight are not chunks
+		if entry.IsDir() || strings.Contains(entry.Name(), tmpSuffix) {
+			continue
+		}
+		chunkID := entry.Name()
+
+		start := time.Now()
+		data, err := os.ReadFile(filepath.Join(dataDir, chunkID))
+		if err != nil {
+			continue // deleted while we were scrubbing, nothing to check
+		}
+		scrubbed++
+		if err := verifyChecksum(chunkID, chunkChecksum(data)); err != nil {
+			log.Printf("scrubber: %s, quarantining it\n", err)
+			quarantineChunk(dataDir, chunkID)
+			corrupt++
+		}
+
+		// rate limit: reading len(data) bytes should take at least len(data)/bytesPerSec seconds
+		if bytesPerSec > 0 {
+			budget := time.Duration(float64(len(data)) / float64(bytesPerSec) * float64(time.Second))
+			if spent := time.Since(start); spent < budget {
+				time.Sleep(budget - spent)
+			}
+		}
+	}
+	return scrubbed, corrupt
+}
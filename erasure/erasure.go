@@ -0,0 +1,171 @@
+// Package erasure is a pure Go Reed-Solomon erasure code over GF(2^8)
+// data is split into dataShards equally sized shards, Encode computes parityShards more,
+// and Reconstruct rebuilds any missing ones as long as at least dataShards of them (data or parity) are left
+//
+//	code, _ := erasure.New(6, 3)
+//	shards := [][]byte{d0, d1, d2, d3, d4, d5, nil, nil, nil}
+//	err := code.Encode(shards)  // shards[6:] now hold the parity
+//	shards[1], shards[7] = nil, nil
+//	err = code.Reconstruct(shards)  // and they are back
+package erasure
+
+import (
+	"errors"
+	"fmt"
+)
+
+// MaxShards is the most data + parity shards a code can have, every shard needs its own row in GF(2^8)
+const MaxShards = 256
+
+var (
+	// ErrTooFewShards is returned by Reconstruct when fewer than dataShards shards are left
+	ErrTooFewShards = errors.New("too few shards to reconstruct")
+	// ErrShardSize is returned when the shards are not all the same size
+	ErrShardSize = errors.New("shards differ in size")
+)
+
+// Code encodes and reconstructs shards, it is safe to use from several goroutines
+type Code struct {
+	dataShards   int
+	parityShards int
+	// (dataShards + parityShards) x dataShards, the top dataShards rows are the identity,
+	// so data shards are stored as they are, and every row below computes one parity shard
+	matrix matrix
+}
+
+// New returns a code with dataShards data and parityShards parity shards
+func New(dataShards, parityShards int) (*Code, error) {
+	if dataShards < 1 || parityShards < 1 {
+		return nil, fmt.Errorf("need at least 1 data and 1 parity shard, got %d+%d", dataShards, parityShards)
+	}
+	if dataShards+parityShards > MaxShards {
+		return nil, fmt.Errorf("at most %d shards, got %d+%d", MaxShards, dataShards, parityShards)
+	}
+
+	// any dataShards rows of a vandermonde matrix are invertible, and multiplying by the inverse of its top
+	// turns the top into the identity without losing that, which makes the code systematic
+	v := vandermonde(dataShards+parityShards, dataShards)
+	topInv, err := v.subRows(seq(dataShards)).invert()
+	if err != nil {
+		return nil, err
+	}
+	return &Code{dataShards: dataShards, parityShards: parityShards, matrix: v.multiply(topInv)}, nil
+}
+
+// DataShards is the number of data shards of the code
+func (c *Code) DataShards() int { return c.dataShards }
+
+// ParityShards is the number of parity shards of the code
+func (c *Code) ParityShards() int { return c.parityShards }
+
+// Encode computes the parity shards from the data shards
+// shards holds dataShards data shards followed by parityShards parity shards, the data shards all have to be the same size
+// parity shards of the wrong size (or nil) are allocated
+func (c *Code) Encode(shards [][]byte) error {
+	if len(shards) != c.dataShards+c.parityShards {
+		return fmt.Errorf("expected %d shards, got %d", c.dataShards+c.parityShards, len(shards))
+	}
+	size := len(shards[0])
+	for _, shard := range shards[:c.dataShards] {
+		if len(shard) != size {
+			return ErrShardSize
+		}
+	}
+
+	for p := 0; p < c.parityShards; p++ {
+		shards[c.dataShards+p] = c.encodeRow(c.matrix[c.dataShards+p], shards[:c.dataShards], shards[c.dataShards+p], size)
+	}
+	return nil
+}
+
+// encodeRow computes one output shard as row * inputs, reusing out if it is the right size
+func (c *Code) encodeRow(row []byte, inputs [][]byte, out []byte, size int) []byte {
+	if len(out) != size {
+		out = make([]byte, size)
+	} else {
+		clear(out)
+	}
+	for i, input := range inputs {
+		mulAdd(out, input, row[i])
+	}
+	return out
+}
+
+// Reconstruct rebuilds every missing shard (nil or empty) in place, data and parity
+// at least dataShards shards have to be present, and they all have to be the same size
+func (c *Code) Reconstruct(shards [][]byte) error {
+	if len(shards) != c.dataShards+c.parityShards {
+		return fmt.Errorf("expected %d shards, got %d", c.dataShards+c.parityShards, len(shards))
+	}
+
+	size := 0
+	var present []int
+	for i, shard := range shards {
+		if len(shard) == 0 {
+			continue
+		}
+		if size == 0 {
+			size = len(shard)
+		} else if len(shard) != size {
+			return ErrShardSize
+		}
+		present = append(present, i)
+	}
+	if len(present) == len(shards) {
+		return nil
+	}
+	if len(present) < c.dataShards {
+		return fmt.Errorf("%w: have %d, need %d", ErrTooFewShards, len(present), c.dataShards)
+	}
+
+	// the rows of the first dataShards shards we have map the data to them, so their inverse maps them back to the data
+	present = present[:c.dataShards]
+	decode, err := c.matrix.subRows(present).invert()
+	if err != nil {
+		return err
+	}
+	inputs := make([][]byte, c.dataShards)
+	for i, shard := range present {
+		inputs[i] = shards[shard]
+	}
+
+	for d := 0; d < c.dataShards; d++ {
+		if len(shards[d]) == 0 {
+			shards[d] = c.encodeRow(decode[d], inputs, nil, size)
+		}
+	}
+	for p := c.dataShards; p < len(shards); p++ {
+		if len(shards[p]) == 0 {
+			shards[p] = c.encodeRow(c.matrix[p], shards[:c.dataShards], nil, size)
+		}
+	}
+	return nil
+}
+
+// Verify tells whether the parity shards match the data shards
+func (c *Code) Verify(shards [][]byte) (bool, error) {
+	if len(shards) != c.dataShards+c.parityShards {
+		return false, fmt.Errorf("expected %d shards, got %d", c.dataShards+c.parityShards, len(shards))
+	}
+	size := len(shards[0])
+	for _, shard := range shards {
+		if len(shard) != size {
+			return false, ErrShardSize
+		}
+	}
+	for p := c.dataShards; p < len(shards); p++ {
+		expected := c.encodeRow(c.matrix[p], shards[:c.dataShards], nil, size)
+		if string(expected) != string(shards[p]) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func seq(n int) []int {
+	s := make([]int, n)
+	for i := range s {
+		s[i] = i
+	}
+	return s
+}
@@ -0,0 +1,168 @@
+package erasure
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+// shardsOf returns dataShards random shards of size bytes, followed by room for the parity
+func shardsOf(rng *rand.Rand, dataShards, parityShards, size int) [][]byte {
+	shards := make([][]byte, dataShards+parityShards)
+	for i := 0; i < dataShards; i++ {
+		shards[i] = make([]byte, size)
+		rng.Read(shards[i])
+	}
+	return shards
+}
+
+func clone(shards [][]byte) [][]byte {
+	copied := make([][]byte, len(shards))
+	for i, shard := range shards {
+		copied[i] = append([]byte(nil), shard...)
+	}
+	return copied
+}
+
+// every way of picking k of n indexes
+func combinations(n, k int) [][]int {
+	var all [][]int
+	var pick func(start int, picked []int)
+	pick = func(start int, picked []int) {
+		if len(picked) == k {
+			all = append(all, append([]int(nil), picked...))
+			return
+		}
+		for i := start; i < n; i++ {
+			pick(i+1, append(picked, i))
+		}
+	}
+	pick(0, nil)
+	return all
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		data, parity int
+		ok           bool
+	}{
+		{1, 1, true},
+		{6, 3, true},
+		{200, 56, true},
+		{0, 2, false},
+		{4, 0, false},
+		{200, 57, false},
+	}
+	for _, tt := range tests {
+		_, err := New(tt.data, tt.parity)
+		if (err == nil) != tt.ok {
+			t.Errorf("New(%d, %d) error = %v, want ok %t", tt.data, tt.parity, err, tt.ok)
+		}
+	}
+}
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		data, parity, size int
+	}{
+		{1, 1, 10},
+		{2, 1, 1},
+		{4, 2, 64},
+		{6, 3, 1000},
+		{10, 4, 333},
+	}
+	rng := rand.New(rand.NewSource(1))
+	for _, tt := range tests {
+		code, err := New(tt.data, tt.parity)
+		if err != nil {
+			t.Fatal(err)
+		}
+		shards := shardsOf(rng, tt.data, tt.parity, tt.size)
+		data := clone(shards[:tt.data])
+		if err := code.Encode(shards); err != nil {
+			t.Fatalf("%d+%d: %v", tt.data, tt.parity, err)
+		}
+		for i := range data {
+			if !bytes.Equal(shards[i], data[i]) {
+				t.Fatalf("%d+%d: Encode changed data shard %d", tt.data, tt.parity, i)
+			}
+		}
+		if ok, err := code.Verify(shards); err != nil || !ok {
+			t.Fatalf("%d+%d: Verify after Encode = %t, %v", tt.data, tt.parity, ok, err)
+		}
+		shards[tt.data][0] ^= 1
+		if ok, _ := code.Verify(shards); ok {
+			t.Fatalf("%d+%d: Verify missed a flipped parity bit", tt.data, tt.parity)
+		}
+	}
+}
+
+// losing any parityShards shards, data or parity, gets every one of them back
+func TestReconstruct(t *testing.T) {
+	tests := []struct {
+		data, parity int
+	}{
+		{1, 1},
+		{3, 1},
+		{4, 2},
+		{6, 3},
+		{10, 4},
+	}
+	rng := rand.New(rand.NewSource(2))
+	for _, tt := range tests {
+		code, err := New(tt.data, tt.parity)
+		if err != nil {
+			t.Fatal(err)
+		}
+		shards := shardsOf(rng, tt.data, tt.parity, 97)
+		if err := code.Encode(shards); err != nil {
+			t.Fatal(err)
+		}
+
+		for lost := 1; lost <= tt.parity; lost++ {
+			for _, missing := range combinations(tt.data+tt.parity, lost) {
+				damaged := clone(shards)
+				for _, i := range missing {
+					damaged[i] = nil
+				}
+				if err := code.Reconstruct(damaged); err != nil {
+					t.Fatalf("%d+%d without %v: %v", tt.data, tt.parity, missing, err)
+				}
+				for i := range shards {
+					if !bytes.Equal(damaged[i], shards[i]) {
+						t.Fatalf("%d+%d without %v: shard %d came back wrong", tt.data, tt.parity, missing, i)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestReconstructErrors(t *testing.T) {
+	code, err := New(4, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shards := shardsOf(rand.New(rand.NewSource(3)), 4, 2, 16)
+	if err := code.Encode(shards); err != nil {
+		t.Fatal(err)
+	}
+
+	tooFew := clone(shards)
+	tooFew[0], tooFew[2], tooFew[5] = nil, nil, nil
+	if err := code.Reconstruct(tooFew); !errors.Is(err, ErrTooFewShards) {
+		t.Errorf("Reconstruct with 3 of 4+2 shards = %v, want ErrTooFewShards", err)
+	}
+
+	uneven := clone(shards)
+	uneven[0] = nil
+	uneven[1] = uneven[1][:8]
+	if err := code.Reconstruct(uneven); !errors.Is(err, ErrShardSize) {
+		t.Errorf("Reconstruct with a short shard = %v, want ErrShardSize", err)
+	}
+
+	if err := code.Reconstruct(shards[:5]); err == nil {
+		t.Error("Reconstruct with 5 shards for a 4+2 code succeeded")
+	}
+}
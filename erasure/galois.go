@@ -0,0 +1,64 @@
+package erasure
+
+// arithmetic in GF(2^8), the field every Reed-Solomon byte lives in
+// addition is xor, multiplication goes through log / exp tables of the generator 2 over the polynomial x^8+x^4+x^3+x^2+1
+
+const fieldPolynomial = 0x11d
+
+var (
+	expTable [510]byte // expTable[i] = 2^i, twice as long so log(a)+log(b) never needs a mod 255
+	logTable [256]byte // logTable[2^i] = i, logTable[0] is unused
+	mulTable [256][256]byte
+)
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		expTable[i] = byte(x)
+		expTable[i+255] = byte(x)
+		logTable[x] = byte(i)
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= fieldPolynomial
+		}
+	}
+	for a := 0; a < 256; a++ {
+		for b := 0; b < 256; b++ {
+			mulTable[a][b] = galMul(byte(a), byte(b))
+		}
+	}
+}
+
+func galMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return expTable[int(logTable[a])+int(logTable[b])]
+}
+
+// galInv is 1/a, a can't be 0
+func galInv(a byte) byte {
+	return expTable[255-int(logTable[a])]
+}
+
+// galExp is a^n
+func galExp(a byte, n int) byte {
+	if n == 0 {
+		return 1
+	}
+	if a == 0 {
+		return 0
+	}
+	return expTable[(int(logTable[a])*n)%255]
+}
+
+// mulAdd does dst ^= coef * src, byte by byte
+func mulAdd(dst, src []byte, coef byte) {
+	if coef == 0 {
+		return
+	}
+	row := &mulTable[coef]
+	for i, b := range src {
+		dst[i] ^= row[b]
+	}
+}
@@ -0,0 +1,90 @@
+package erasure
+
+import "testing"
+
+// slowMul multiplies in GF(2^8) the long way, shift and add with reduction by fieldPolynomial
+func slowMul(a, b byte) byte {
+	var product int
+	x, y := int(a), int(b)
+	for y > 0 {
+		if y&1 != 0 {
+			product ^= x
+		}
+		y >>= 1
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= fieldPolynomial
+		}
+	}
+	return byte(product)
+}
+
+func TestTables(t *testing.T) {
+	seen := make(map[byte]bool)
+	for i := 0; i < 255; i++ {
+		if expTable[i] != expTable[i+255] {
+			t.Fatalf("expTable[%d] = %d, expTable[%d] = %d, the second half should repeat the first", i, expTable[i], i+255, expTable[i+255])
+		}
+		if seen[expTable[i]] {
+			t.Fatalf("2^%d = %d came up before, 2 should generate all 255 non zero bytes", i, expTable[i])
+		}
+		seen[expTable[i]] = true
+	}
+	for a := 1; a < 256; a++ {
+		if got := expTable[logTable[a]]; got != byte(a) {
+			t.Fatalf("exp(log(%d)) = %d", a, got)
+		}
+	}
+}
+
+func TestMul(t *testing.T) {
+	for a := 0; a < 256; a++ {
+		for b := 0; b < 256; b++ {
+			want := slowMul(byte(a), byte(b))
+			if got := galMul(byte(a), byte(b)); got != want {
+				t.Fatalf("galMul(%d, %d) = %d, want %d", a, b, got, want)
+			}
+			if got := mulTable[a][b]; got != want {
+				t.Fatalf("mulTable[%d][%d] = %d, want %d", a, b, got, want)
+			}
+		}
+	}
+}
+
+func TestInvAndExp(t *testing.T) {
+	for a := 1; a < 256; a++ {
+		if got := galMul(byte(a), galInv(byte(a))); got != 1 {
+			t.Fatalf("%d * galInv(%d) = %d, want 1", a, a, got)
+		}
+	}
+	tests := []struct {
+		a byte
+		n int
+	}{
+		{0, 0}, {0, 3}, {1, 100}, {2, 0}, {2, 1}, {2, 8}, {3, 7}, {29, 255}, {200, 300}, {255, 2},
+	}
+	for _, tt := range tests {
+		want := byte(1)
+		for i := 0; i < tt.n; i++ {
+			want = slowMul(want, tt.a)
+		}
+		if got := galExp(tt.a, tt.n); got != want {
+			t.Errorf("galExp(%d, %d) = %d, want %d", tt.a, tt.n, got, want)
+		}
+	}
+}
+
+func TestMulAdd(t *testing.T) {
+	src := []byte{0, 1, 2, 3, 100, 255}
+	for _, coef := range []byte{0, 1, 2, 77, 255} {
+		dst := []byte{9, 8, 7, 6, 5, 4}
+		want := make([]byte, len(dst))
+		for i := range dst {
+			want[i] = dst[i] ^ slowMul(coef, src[i])
+		}
+		mulAdd(dst, src, coef)
+		if string(dst) != string(want) {
+			t.Errorf("mulAdd with %d = %v, want %v", coef, dst, want)
+		}
+	}
+}
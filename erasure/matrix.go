@@ -0,0 +1,103 @@
+package erasure
+
+import "errors"
+
+// matrix is a row major matrix over GF(2^8)
+type matrix [][]byte
+
+var errSingular = errors.New("matrix is singular")
+
+func newMatrix(rows, cols int) matrix {
+	m := make(matrix, rows)
+	for r := range m {
+		m[r] = make([]byte, cols)
+	}
+	return m
+}
+
+func identity(n int) matrix {
+	m := newMatrix(n, n)
+	for i := range m {
+		m[i][i] = 1
+	}
+	return m
+}
+
+// vandermonde returns the rows x cols matrix with m[r][c] = r^c, any cols of its rows are linearly independent
+func vandermonde(rows, cols int) matrix {
+	m := newMatrix(rows, cols)
+	for r := range m {
+		for c := range m[r] {
+			m[r][c] = galExp(byte(r), c)
+		}
+	}
+	return m
+}
+
+func (m matrix) multiply(other matrix) matrix {
+	out := newMatrix(len(m), len(other[0]))
+	for r := range m {
+		for c := range out[r] {
+			var v byte
+			for i := range m[r] {
+				v ^= galMul(m[r][i], other[i][c])
+			}
+			out[r][c] = v
+		}
+	}
+	return out
+}
+
+// subRows returns a new matrix made of the given rows of m
+func (m matrix) subRows(rows []int) matrix {
+	out := make(matrix, len(rows))
+	for i, r := range rows {
+		out[i] = append([]byte(nil), m[r]...)
+	}
+	return out
+}
+
+// invert returns the inverse of the square matrix m with Gauss-Jordan elimination, m is left untouched
+func (m matrix) invert() (matrix, error) {
+	n := len(m)
+	work := make(matrix, n)
+	inv := identity(n)
+	for r := range m {
+		work[r] = append([]byte(nil), m[r]...)
+	}
+
+	for col := 0; col < n; col++ {
+		// find a row with a non zero pivot and move it up
+		pivot := -1
+		for r := col; r < n; r++ {
+			if work[r][col] != 0 {
+				pivot = r
+				break
+			}
+		}
+		if pivot < 0 {
+			return nil, errSingular
+		}
+		work[col], work[pivot] = work[pivot], work[col]
+		inv[col], inv[pivot] = inv[pivot], inv[col]
+
+		// scale the pivot row so the pivot is 1
+		if scale := galInv(work[col][col]); scale != 1 {
+			for c := 0; c < n; c++ {
+				work[col][c] = galMul(work[col][c], scale)
+				inv[col][c] = galMul(inv[col][c], scale)
+			}
+		}
+
+		// and clear the column in every other row
+		for r := 0; r < n; r++ {
+			if r == col || work[r][col] == 0 {
+				continue
+			}
+			factor := work[r][col]
+			mulAdd(work[r], work[col], factor)
+			mulAdd(inv[r], inv[col], factor)
+		}
+	}
+	return inv, nil
+}
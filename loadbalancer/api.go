@@ -0,0 +1,335 @@
+package loadbalancer
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+	"github.com/Rahul6700/Foodo/shared"
+	"github.com/gin-gonic/gin"
+)
+
+// the LB sits between the clients and the rest of the cluster
+// DN's send it heartbeats, clients ask it where to put / find chunks, and it talks to the namenode leader for them
+type ApiServer struct {
+	namenodes   []string // api addresses of all the namenodes -> "http://localhost:8001"
+	replication int      // how many DN's each chunk is written to
+	minReplicas int      // how many of those have to acknowledge a chunk before its upload can be committed
+	dataNodes   *dataNodeRegistry
+	blockReports *blockReportRegistry
+
+	leaderLock sync.Mutex
+	leaderAddr string // last namenode that told us it was the leader
+	staleReads atomic.Uint64 // stale reads go round robin over the namenodes, see forwardStaleRead
+}
+
+// NewApiServer is the constructor
+// deadTimeout is how long a DN can go without a heartbeat before we treat it as dead
+func NewApiServer(namenodes []string, replication int, minReplicas int, deadTimeout time.Duration) *ApiServer {
+	return &ApiServer{
+		namenodes:   namenodes,
+		replication: replication,
+		minReplicas: minReplicas,
+		dataNodes:   newDataNodeRegistry(deadTimeout),
+		blockReports: newBlockReportRegistry(),
+	}
+}
+
+// "/heartbeat" is where the DN's report that they are alive, "/datanodes" lists every DN we know (DN's only pipeline writes to those)
+// "/blockReport" is where they send the full list of chunks they hold, "/blockReports" shows what those reports turned up
+// "/uploadFile" opens an upload session and gives the client its upload plan, "/ackChunks", "/commitUpload", "/abortUpload"
+// and "/uploadStatus" drive the session from there (see uploads.go), the file can only be read once it is committed
+// "/get-file-locations" gives the client the download plan of a file, "/reportBadReplicas" is where it tells us which replicas failed
+// "/deleteFile" removes a file, its chunks are cleaned up later by the garbage collector
+// "/dedupStats" reports how much space chunk dedup is saving
+// "/mkdir", "/rename", "/delete" and "/ls" work on the namespace, "/files" and "/stat" describe files, the leader does all the work
+// "/setReplication" changes how many replicas a file keeps (or its storage policy), the replication manager follows it
+func (s *ApiServer) RegisterRoutes(r *gin.Engine) {
+	r.POST("/heartbeat", s.handleHeartbeat)
+	r.GET("/datanodes", s.handleDatanodes)
+	r.POST("/blockReport", s.handleBlockReport)
+	r.GET("/blockReports", s.handleGetBlockReports)
+	r.POST("/uploadFile", s.handleUploadFile)
+	r.POST("/ackChunks", s.handleNamespace("/upload/ack"))
+	r.POST("/commitUpload", s.handleCommitUpload)
+	r.POST("/abortUpload", s.handleNamespace("/upload/abort"))
+	r.GET("/uploadStatus", s.handleUploadStatus)
+	r.GET("/get-file-locations", s.handleGetFileLocations)
+	r.POST("/reportBadReplicas", s.handleReportBadReplicas)
+	r.POST("/deleteFile", s.handleDeleteFile)
+	r.GET("/dedupStats", s.handleDedupStats)
+	r.POST("/mkdir", s.handleNamespace("/mkdir"))
+	r.POST("/rename", s.handleNamespace("/rename"))
+	r.POST("/delete", s.handleNamespace("/delete"))
+	r.GET("/ls", s.handleNamespace("/ls"))
+	r.GET("/files", s.handleNamespace("/files"))
+	r.GET("/stat", s.handleNamespace("/stat"))
+	r.POST("/setReplication", s.handleNamespace("/set-replication"))
+}
+
+func (s *ApiServer) handleHeartbeat(c *gin.Context) {
+	var payload shared.HeartbeatPayload
+	if err := c.ShouldBindJSON(&payload); err != nil || payload.NodeID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad heartbeat payload"})
+		return
+	}
+
+	s.dataNodes.heartbeat(payload.NodeID, payload.ActiveWrites)
+
+	// the DN's scrubber quarantined some chunks, so this DN is no longer a replica for them
+	// if we cant commit that, we answer 503 and the DN reports them again with its next heartbeat
+	if len(payload.CorruptChunks) > 0 {
+		if err := s.dropReplicas(payload.NodeID, payload.CorruptChunks); err != nil {
+			log.Printf("failed to drop corrupt replicas on %s: %s", payload.NodeID, err)
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "failed to record corrupt chunks"})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, shared.HeartbeatResponse{Success: true, Peers: s.dataNodes.nodeIDs()})
+}
+
+// every DN that ever sent us a heartbeat -> {"datanodes": ["http://localhost:9001", ...]}
+func (s *ApiServer) handleDatanodes(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"datanodes": s.dataNodes.nodeIDs()})
+}
+
+// builds the upload plan (chunkID -> DN urls) for the client's chunks
+// and proposes it to the namenodes as a BEGIN_UPLOAD command before handing it back with the new session's id
+// chunks the cluster already stores keep their current locations and are marked as already stored, so the client skips them
+// an erasure coded file gets one DN per shard instead, every shard of a stripe on a different one (see erasure.go)
+func (s *ApiServer) handleUploadFile(c *gin.Context) {
+	var req shared.ClientUploadRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.FileName == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad upload request"})
+		return
+	}
+
+	live := s.dataNodes.liveNodes()
+	if len(live) == 0 {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "no live datanodes"})
+		return
+	}
+
+	if err := applyPolicy(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Erasure != nil {
+		if err := checkErasureRequest(req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		// with fewer DN's than shards some DN would hold two shards of a stripe, and losing it would cost two
+		if shards := req.Erasure.DataShards + req.Erasure.ParityShards; len(live) < shards {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": fmt.Sprintf("erasure coding %d+%d needs %d live datanodes, only %d are live", req.Erasure.DataShards, req.Erasure.ParityShards, shards, len(live))})
+			return
+		}
+	}
+
+	chunkIDs := make([]string, len(req.Chunks))
+	for i, chunk := range req.Chunks {
+		chunkIDs[i] = chunk.ChunkID
+	}
+	for _, stripe := range req.Stripes {
+		for _, parity := range stripe.Parity {
+			chunkIDs = append(chunkIDs, parity.ChunkID)
+		}
+	}
+	existing, err := s.lookupChunks(chunkIDs)
+	if err != nil {
+		log.Printf("failed to look up existing chunks for %s: %s", req.FileName, err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "failed to reach namenodes"})
+		return
+	}
+
+	// the client can ask for more (or fewer) replicas than our default, the file keeps that setting
+	// and the replication manager holds its chunks at it from then on
+	replication := s.replication
+	if req.Replication > 0 {
+		replication = req.Replication
+	}
+	placements := pickReplicas(live, len(req.Chunks), replication)
+	var stripePlacements [][]string
+	if req.Erasure != nil {
+		stripePlacements = pickReplicas(live, len(req.Stripes), req.Erasure.DataShards+req.Erasure.ParityShards)
+		for i := range req.Chunks {
+			placements[i] = []string{stripePlacements[i/req.Erasure.DataShards][i%req.Erasure.DataShards]}
+		}
+	}
+	uploadPlan := make(map[string][]string)
+	alreadyStored := []string{}
+	var savedBytes int64
+	cmd := shared.RaftCommand{
+		Operation:  "BEGIN_UPLOAD",
+		Filename:   req.FileName,
+		Timestamp:  time.Now().UnixNano(),
+		SessionID:  newSessionID(),
+		Policy:     req.Policy,
+		Chunking:   req.Chunking,
+		Encryption: req.Encryption,
+	}
+	if req.Erasure == nil {
+		cmd.Replication = req.Replication
+	}
+	for i, chunk := range req.Chunks {
+		// the same chunk can show up twice in one file (two identical blocks), it only needs to be stored once
+		locations, ok := uploadPlan[chunk.ChunkID]
+		if !ok {
+			if stored, isStored := existing[chunk.ChunkID]; isStored {
+				locations = stored
+				alreadyStored = append(alreadyStored, chunk.ChunkID)
+				savedBytes += chunk.Size
+			} else {
+				locations = placements[i]
+			}
+			uploadPlan[chunk.ChunkID] = locations
+		}
+		cmd.Chunks = append(cmd.Chunks, shared.ChunkStruct{
+			ChunkID:    chunk.ChunkID,
+			ChunkIndex: chunk.Index,
+			Locations:  locations,
+			Size:       chunk.Size,
+			Codec:      chunk.Codec,
+			RawSize:    chunk.RawSize,
+		})
+		cmd.Size += chunk.Size
+	}
+	// encrypted and compressed chunks aren't the size of the data in them, the client tells us the real size
+	if req.Size > 0 {
+		cmd.Size = req.Size
+	}
+	if req.Erasure != nil {
+		cmd.Erasure = req.Erasure
+		for i, stripe := range req.Stripes {
+			planned := shared.StripeStruct{Index: stripe.Index}
+			for p, parity := range stripe.Parity {
+				locations, ok := uploadPlan[parity.ChunkID]
+				if !ok {
+					if stored, isStored := existing[parity.ChunkID]; isStored {
+						locations = stored
+						alreadyStored = append(alreadyStored, parity.ChunkID)
+					} else {
+						locations = []string{stripePlacements[i][req.Erasure.DataShards+p]}
+					}
+					uploadPlan[parity.ChunkID] = locations
+				}
+				planned.Parity = append(planned.Parity, shared.ChunkStruct{
+					ChunkID:    parity.ChunkID,
+					ChunkIndex: p,
+					Locations:  locations,
+					Size:       parity.Size,
+				})
+			}
+			cmd.Stripes = append(cmd.Stripes, planned)
+		}
+	}
+
+	if err := s.propose(cmd); err != nil {
+		log.Printf("failed to begin upload of %s: %s", req.FileName, err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "failed to begin upload with namenodes"})
+		return
+	}
+
+	log.Printf("began upload %s of %s with %d chunks (%d already stored, %d bytes saved)", cmd.SessionID, req.FileName, len(req.Chunks), len(alreadyStored), savedBytes)
+	c.JSON(http.StatusOK, shared.UploadPlanResponse{
+		Success:       true,
+		SessionID:     cmd.SessionID,
+		UploadPlan:    uploadPlan,
+		AlreadyStored: alreadyStored,
+	})
+}
+
+// the namenode leader (any namenode for stale reads) already has the plan in the shape the client wants,
+// we only reorder every chunk's locations so the client tries the least loaded live DN first and dead ones last
+func (s *ApiServer) handleGetFileLocations(c *gin.Context) {
+	stale := c.Query("consistency") == shared.ConsistencyStale
+	status, headers, body, err := s.getRead("/get-metadata?"+c.Request.URL.RawQuery, stale)
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+	for header, value := range headers {
+		c.Header(header, value)
+	}
+	if status != http.StatusOK {
+		c.Data(status, "application/json", body)
+		return
+	}
+
+	var plan struct {
+		Chunks  []shared.ChunkStruct  `json:"chunks"`
+		Erasure *shared.ErasureCoding `json:"erasure,omitempty"`
+		Stripes []shared.StripeStruct `json:"stripes,omitempty"`
+		Chunking *shared.Chunking     `json:"chunking,omitempty"`
+		Encryption *shared.Encryption `json:"encryption,omitempty"`
+	}
+	if err := json.Unmarshal(body, &plan); err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "bad metadata from namenode leader"})
+		return
+	}
+	for i := range plan.Chunks {
+		plan.Chunks[i].Locations = s.dataNodes.rankLocations(plan.Chunks[i].Locations)
+	}
+	for _, stripe := range plan.Stripes {
+		for i := range stripe.Parity {
+			stripe.Parity[i].Locations = s.dataNodes.rankLocations(stripe.Parity[i].Locations)
+		}
+	}
+	c.JSON(http.StatusOK, plan)
+}
+
+// clients report the replicas that failed them while downloading
+// a corrupt or missing replica is dropped from the chunk's locations (the replication manager then makes a new one),
+// an unreachable one is left alone, if its DN is really gone the dead node detection takes care of it
+func (s *ApiServer) handleReportBadReplicas(c *gin.Context) {
+	var reports []shared.BadReplica
+	if err := c.ShouldBindJSON(&reports); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad replica report"})
+		return
+	}
+
+	toDrop := make(map[string][]string) // nodeID -> chunkIDs
+	for _, report := range reports {
+		log.Printf("client reported %s replica of chunk %s on %s", report.Reason, report.ChunkID, report.NodeID)
+		if report.Reason != "corrupt" && report.Reason != "missing" {
+			continue
+		}
+		// only the DN can tell whether its copy is really bad, anyone can send us a report
+		bad, err := verifyReplica(report.NodeID, report.ChunkID)
+		if err != nil {
+			log.Printf("could not verify chunk %s on %s, keeping it: %s", report.ChunkID, report.NodeID, err)
+			continue
+		}
+		if !bad {
+			log.Printf("chunk %s on %s is fine, not dropping it", report.ChunkID, report.NodeID)
+			continue
+		}
+		toDrop[report.NodeID] = append(toDrop[report.NodeID], report.ChunkID)
+	}
+	for nodeID, chunkIDs := range toDrop {
+		if err := s.dropReplicas(nodeID, chunkIDs); err != nil {
+			log.Printf("failed to drop bad replicas on %s: %s", nodeID, err)
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "failed to record bad replicas"})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+func (s *ApiServer) handleDeleteFile(c *gin.Context) {
+	s.forwardToLeader(c, "/delete-file")
+}
+
+func (s *ApiServer) handleDedupStats(c *gin.Context) {
+	s.forwardToLeader(c, "/dedup-stats")
+}
+
+// the namespace routes are the same on the LB and the leader, so they are passed straight through
+func (s *ApiServer) handleNamespace(path string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		s.forwardToLeader(c, path)
+	}
+}
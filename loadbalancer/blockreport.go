@@ -0,0 +1,164 @@
+package loadbalancer
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Rahul6700/Foodo/shared"
+	"github.com/gin-gonic/gin"
+)
+
+// reconciling block reports:
+// "missing" -> the namenodes say the DN has a chunk, but it is not in the DN's report (the replica is dropped from the chunk's locations)
+// "orphan"  -> the DN has a chunk the namenodes dont list for it, and no file or upload uses (nobody reads it, so it is deleted from the DN)
+//              a chunk something still holds a reference on is never an orphan, wherever it is: an upload may have stored it
+//              on a DN it hasn't acknowledged yet, and that copy is what the commit is going to count
+// a chunk is only acted on once two reports in a row agree, so a chunk that is mid upload or mid delete is not mistaken for either
+
+// what we remember about the last block report of a single DN
+type blockReportState struct {
+	NodeID     string    `json:"node_id"`
+	ReportedAt time.Time `json:"reported_at"`
+	ChunkCount int       `json:"chunk_count"`
+	TotalBytes int64     `json:"total_bytes"`
+	Missing    []string  `json:"missing"` // discrepancies seen in the last report (acted on if the next one agrees)
+	Orphans    []string  `json:"orphans"`
+	Dropped    int       `json:"dropped"` // missing replicas removed from the metadata so far
+	Deleted    int       `json:"deleted"` // orphan chunks deleted from the DN so far
+}
+
+type blockReportRegistry struct {
+	lock    sync.Mutex
+	reports map[string]*blockReportState
+}
+
+func newBlockReportRegistry() *blockReportRegistry {
+	return &blockReportRegistry{reports: make(map[string]*blockReportState)}
+}
+
+func (s *ApiServer) handleBlockReport(c *gin.Context) {
+	var report shared.BlockReport
+	if err := c.ShouldBindJSON(&report); err != nil || report.NodeID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad block report"})
+		return
+	}
+
+	state, err := s.reconcileBlockReport(report)
+	if err != nil {
+		log.Printf("blockreport: could not reconcile report from %s: %s", report.NodeID, err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "failed to reconcile block report"})
+		return
+	}
+	c.JSON(http.StatusOK, state)
+}
+
+// the discrepancies of every DN's last block report
+func (s *ApiServer) handleGetBlockReports(c *gin.Context) {
+	s.blockReports.lock.Lock()
+	defer s.blockReports.lock.Unlock()
+
+	states := []blockReportState{}
+	for _, state := range s.blockReports.reports {
+		states = append(states, *state)
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].NodeID < states[j].NodeID })
+	c.JSON(http.StatusOK, gin.H{"datanodes": states})
+}
+
+// compares a block report with the namenodes' chunk locations, and fixes whatever the previous report already flagged too
+func (s *ApiServer) reconcileBlockReport(report shared.BlockReport) (blockReportState, error) {
+	// targets has an entry for every chunk a file or upload session holds a reference on
+	chunks, targets, err := s.fetchChunkLocations()
+	if err != nil {
+		return blockReportState{}, err
+	}
+	garbage, err := s.fetchGarbageChunks()
+	if err != nil {
+		return blockReportState{}, err
+	}
+
+	reported := make(map[string]bool, len(report.Chunks))
+	var totalBytes int64
+	for _, chunk := range report.Chunks {
+		reported[chunk.ChunkID] = true
+		totalBytes += chunk.Size
+	}
+
+	missing, orphans := []string{}, []string{}
+	for chunkID, locations := range chunks {
+		if containsString(locations, report.NodeID) && !reported[chunkID] {
+			missing = append(missing, chunkID)
+		}
+	}
+	for chunkID := range reported {
+		if _, isGarbage := garbage.Chunks[chunkID]; isGarbage {
+			continue // the garbage collector is already on it
+		}
+		if _, isDeleting := garbage.Deleting[chunkID]; isDeleting {
+			continue
+		}
+		if _, referenced := targets[chunkID]; referenced {
+			continue
+		}
+		if !containsString(chunks[chunkID], report.NodeID) {
+			orphans = append(orphans, chunkID)
+		}
+	}
+	sort.Strings(missing)
+	sort.Strings(orphans)
+
+	s.blockReports.lock.Lock()
+	state, ok := s.blockReports.reports[report.NodeID]
+	if !ok {
+		state = &blockReportState{NodeID: report.NodeID}
+		s.blockReports.reports[report.NodeID] = state
+	}
+	confirmedMissing := intersect(state.Missing, missing)
+	confirmedOrphans := intersect(state.Orphans, orphans)
+	state.ReportedAt = time.Now()
+	state.ChunkCount = len(report.Chunks)
+	state.TotalBytes = totalBytes
+	state.Missing = missing
+	state.Orphans = orphans
+	s.blockReports.lock.Unlock()
+
+	if len(confirmedMissing) > 0 {
+		if err := s.dropReplicas(report.NodeID, confirmedMissing); err != nil {
+			s.blockReports.lock.Lock()
+			defer s.blockReports.lock.Unlock()
+			return *state, err
+		}
+		log.Printf("blockreport: %s lost %d replicas", report.NodeID, len(confirmedMissing))
+	}
+	deleted := 0
+	for _, chunkID := range confirmedOrphans {
+		if err := deleteChunk(report.NodeID, chunkID); err != nil {
+			log.Printf("blockreport: could not delete orphan chunk %s from %s: %s", chunkID, report.NodeID, err)
+			continue
+		}
+		deleted++
+	}
+	if deleted > 0 {
+		log.Printf("blockreport: deleted %d orphan chunks from %s", deleted, report.NodeID)
+	}
+
+	s.blockReports.lock.Lock()
+	defer s.blockReports.lock.Unlock()
+	state.Dropped += len(confirmedMissing)
+	state.Deleted += deleted
+	return *state, nil
+}
+
+// the entries that are in both lists
+func intersect(a, b []string) []string {
+	var both []string
+	for _, item := range b {
+		if containsString(a, item) {
+			both = append(both, item)
+		}
+	}
+	return both
+}
@@ -0,0 +1,104 @@
+package loadbalancer
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Rahul6700/Foodo/shared"
+)
+
+func blockReport(nodeID string, chunkIDs ...string) shared.BlockReport {
+	report := shared.BlockReport{NodeID: nodeID}
+	for _, id := range chunkIDs {
+		report.Chunks = append(report.Chunks, shared.BlockReportItem{ChunkID: id, Size: 1})
+	}
+	return report
+}
+
+// /a has "kept" and "lost" on both DN's, and nothing uses "stray" unless the case's setup says so.
+// every case sends two reports from DN1, only what both of them agree on is acted on
+func TestReconcileBlockReport(t *testing.T) {
+	tests := []struct {
+		name          string
+		first, second []string // the chunks DN1 reports
+		setup         func(nn *fakeNamenode, dn1 string)
+		events        []string // what the second report does
+		lost          []string // the locations of "lost" after it
+	}{
+		{
+			name:   "a missing replica and an orphan in both reports",
+			first:  []string{"kept", "stray"},
+			second: []string{"kept", "stray"},
+			events: []string{"propose UPDATE_LOCATIONS [lost]", "delete stray from DN1"},
+			lost:   []string{"DN2"},
+		},
+		{
+			name:   "one report is not enough",
+			first:  []string{"kept", "lost"},
+			second: []string{"kept", "stray"},
+			lost:   []string{"DN1", "DN2"},
+		},
+		{
+			name:   "a chunk that showed up again is not dropped",
+			first:  []string{"kept", "stray"},
+			second: []string{"kept", "lost"},
+			lost:   []string{"DN1", "DN2"},
+		},
+		{
+			name:   "a chunk an upload holds is never an orphan",
+			first:  []string{"kept", "lost", "stray"},
+			second: []string{"kept", "lost", "stray"},
+			setup: func(nn *fakeNamenode, dn1 string) {
+				nn.apply(shared.RaftCommand{Operation: "BEGIN_UPLOAD", SessionID: "s1", Filename: "/b", Chunks: []shared.ChunkStruct{chunkOn("stray", dn1)}})
+			},
+			lost: []string{"DN1", "DN2"},
+		},
+		{
+			name:   "garbage is left to the garbage collector",
+			first:  []string{"kept", "lost", "stray"},
+			second: []string{"kept", "lost", "stray"},
+			setup: func(nn *fakeNamenode, dn1 string) {
+				nn.apply(register("/old", chunkOn("stray", dn1)))
+				nn.apply(shared.RaftCommand{Operation: "DELETE_FILE", Filename: "/old"})
+			},
+			lost: []string{"DN1", "DN2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := &recorder{}
+			nn := newFakeNamenode(t, rec)
+			dn1, dn2 := newFakeDatanode(t, rec), newFakeDatanode(t, rec)
+			lb := newTestLB(nn)
+			nn.apply(register("/a", chunkOn("kept", dn1.srv.URL, dn2.srv.URL), chunkOn("lost", dn1.srv.URL, dn2.srv.URL)))
+			if tt.setup != nil {
+				tt.setup(nn, dn1.srv.URL)
+			}
+			names := []string{dn1.srv.URL, "DN1", dn2.srv.URL, "DN2"}
+
+			if _, err := lb.reconcileBlockReport(blockReport(dn1.srv.URL, tt.first...)); err != nil {
+				t.Fatal(err)
+			}
+			if events := rec.take(); len(events) > 0 {
+				t.Fatalf("the first report acted: %q", events)
+			}
+			if _, err := lb.reconcileBlockReport(blockReport(dn1.srv.URL, tt.second...)); err != nil {
+				t.Fatal(err)
+			}
+			events := rec.take()
+			for i := range events {
+				events[i] = replaceAll(events[i], names...)
+			}
+			if !reflect.DeepEqual(orNone(events), orNone(tt.events)) {
+				t.Errorf("events = %q, want %q", events, tt.events)
+			}
+			var lost []string
+			for _, location := range nn.locations("lost") {
+				lost = append(lost, replaceAll(location, names...))
+			}
+			if !reflect.DeepEqual(lost, tt.lost) {
+				t.Errorf("locations of lost = %v, want %v", lost, tt.lost)
+			}
+		})
+	}
+}
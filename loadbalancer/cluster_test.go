@@ -0,0 +1,170 @@
+package loadbalancer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Rahul6700/Foodo/namenode"
+	"github.com/Rahul6700/Foodo/shared"
+	"github.com/hashicorp/raft"
+)
+
+// what the fake namenode and datanodes were asked to do, in order
+type recorder struct {
+	lock   sync.Mutex
+	events []string
+}
+
+func (r *recorder) add(format string, args ...any) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.events = append(r.events, fmt.Sprintf(format, args...))
+}
+
+// returns the events so far and forgets them
+func (r *recorder) take() []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	events := r.events
+	r.events = nil
+	return events
+}
+
+// fakeNamenode is a raft leader of one, the commands the LB proposes are applied straight to a real FSM
+type fakeNamenode struct {
+	t   *testing.T
+	fsm *namenode.FSM
+	rec *recorder
+	srv *httptest.Server
+	// runs once the answer to a /garbage-chunks is computed, before it is sent
+	afterGarbageFetch func()
+}
+
+func newFakeNamenode(t *testing.T, rec *recorder) *fakeNamenode {
+	nn := &fakeNamenode{t: t, fsm: namenode.NewFsm(), rec: rec}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"state": "Leader"})
+	})
+	mux.HandleFunc("POST /raft/propose", func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		var cmd shared.RaftCommand
+		json.Unmarshal(data, &cmd)
+		rec.add("propose %s %s", cmd.Operation, chunkIDs(cmd.Chunks))
+		if err, ok := nn.fsm.Apply(&raft.Log{Data: data}).(error); ok {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]bool{"success": true})
+	})
+	mux.HandleFunc("GET /garbage-chunks", func(w http.ResponseWriter, r *http.Request) {
+		garbage, deleting := nn.fsm.GetGarbageChunks()
+		if nn.afterGarbageFetch != nil {
+			nn.afterGarbageFetch()
+		}
+		json.NewEncoder(w).Encode(map[string]any{"chunks": garbage, "deleting": deleting})
+	})
+	mux.HandleFunc("GET /chunk-locations", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"chunks": nn.fsm.GetAllChunkLocations(), "targets": nn.fsm.ReplicationTargets(3)})
+	})
+	mux.HandleFunc("POST /lookup-chunks", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			ChunkIDs []string `json:"chunk_ids"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		json.NewEncoder(w).Encode(map[string]any{"chunks": nn.fsm.LookupChunks(body.ChunkIDs)})
+	})
+	nn.srv = httptest.NewServer(mux)
+	t.Cleanup(nn.srv.Close)
+	return nn
+}
+
+// applies cmd as if some other client of the namenodes had proposed it
+func (nn *fakeNamenode) apply(cmd shared.RaftCommand) {
+	nn.t.Helper()
+	data, _ := json.Marshal(cmd)
+	if err, ok := nn.fsm.Apply(&raft.Log{Data: data}).(error); ok {
+		nn.t.Fatalf("%s: %v", cmd.Operation, err)
+	}
+}
+
+func (nn *fakeNamenode) locations(chunkID string) []string {
+	return nn.fsm.LookupChunks([]string{chunkID})[chunkID]
+}
+
+// fakeDatanode answers the LB's deletes and verifies, the node id is its url like everywhere in the cluster
+type fakeDatanode struct {
+	srv         *httptest.Server
+	failDeletes bool            // deletes answer 500
+	bad         map[string]bool // chunks /verifyChunk says are corrupt
+}
+
+func newFakeDatanode(t *testing.T, rec *recorder) *fakeDatanode {
+	dn := &fakeDatanode{bad: make(map[string]bool)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("DELETE /chunk/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if dn.failDeletes {
+			http.Error(w, "disk error", http.StatusInternalServerError)
+			return
+		}
+		rec.add("delete %s from %s", r.PathValue("id"), dn.srv.URL)
+	})
+	mux.HandleFunc("GET /verifyChunk/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if dn.bad[r.PathValue("id")] {
+			w.WriteHeader(http.StatusConflict)
+		}
+	})
+	dn.srv = httptest.NewServer(mux)
+	t.Cleanup(dn.srv.Close)
+	return dn
+}
+
+// an LB in front of nn, nothing it knows ever counts as dead
+func newTestLB(nn *fakeNamenode) *ApiServer {
+	return NewApiServer([]string{nn.srv.URL}, 3, 1, time.Hour)
+}
+
+func chunkIDs(chunks []shared.ChunkStruct) []string {
+	ids := []string{}
+	for _, chunk := range chunks {
+		ids = append(ids, chunk.ChunkID)
+	}
+	return ids
+}
+
+func register(name string, chunks ...shared.ChunkStruct) shared.RaftCommand {
+	return shared.RaftCommand{Operation: "REGISTER_FILE", Filename: name, Chunks: chunks}
+}
+
+func chunkOn(id string, locations ...string) shared.ChunkStruct {
+	return shared.ChunkStruct{ChunkID: id, Locations: locations}
+}
+
+// replaceAll(s, old1, new1, old2, new2...) -> the node urls in events are long and random, tests name them instead
+func replaceAll(s string, oldNew ...string) string {
+	return strings.NewReplacer(oldNew...).Replace(s)
+}
+
+func sortedIDs(chunks map[string][]string) []string {
+	ids := []string{}
+	for id := range chunks {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func orNone(ids []string) []string {
+	if ids == nil {
+		return []string{}
+	}
+	return ids
+}
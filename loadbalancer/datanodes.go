@@ -0,0 +1,160 @@
+package loadbalancer
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// what we know about a single DN, all of it comes from its heartbeats
+type dataNodeInfo struct {
+	NodeID       string    // DN's full url -> "http://localhost:9001"
+	ActiveWrites int       // load reported in the last heartbeat
+	LastSeen     time.Time // when the last heartbeat came in
+	Dead         bool      // set once the node misses heartbeats for the dead timeout, cleared by its next heartbeat
+}
+
+// registry of every DN that has ever sent us a heartbeat
+type dataNodeRegistry struct {
+	lock  sync.Mutex
+	nodes map[string]*dataNodeInfo
+	// a DN is considered dead if we havent heard a heartbeat from it for this long
+	// DN's send one every 5 seconds, so the default of 15s is 3 missed heartbeats
+	deadTimeout time.Duration
+	startedAt   time.Time
+}
+
+func newDataNodeRegistry(deadTimeout time.Duration) *dataNodeRegistry {
+	return &dataNodeRegistry{
+		nodes:       make(map[string]*dataNodeInfo),
+		deadTimeout: deadTimeout,
+		startedAt:   time.Now(),
+	}
+}
+
+// right after the LB starts nobody has sent us a heartbeat yet, so every DN would look dead
+// nothing that acts on dead nodes should run before this returns true
+func (reg *dataNodeRegistry) warmedUp() bool {
+	return time.Since(reg.startedAt) > reg.deadTimeout
+}
+
+// true if the DN sent a heartbeat within the dead timeout
+func (reg *dataNodeRegistry) isLive(nodeID string) bool {
+	reg.lock.Lock()
+	defer reg.lock.Unlock()
+
+	node, ok := reg.nodes[nodeID]
+	return ok && time.Since(node.LastSeen) < reg.deadTimeout
+}
+
+// records a heartbeat, a DN we have never seen before gets added here
+func (reg *dataNodeRegistry) heartbeat(nodeID string, activeWrites int) {
+	reg.lock.Lock()
+	defer reg.lock.Unlock()
+
+	node, ok := reg.nodes[nodeID]
+	if !ok {
+		node = &dataNodeInfo{NodeID: nodeID}
+		reg.nodes[nodeID] = node
+		log.Printf("datanode %s joined", nodeID)
+	} else if node.Dead {
+		log.Printf("datanode %s is back after %s", nodeID, time.Since(node.LastSeen).Round(time.Second))
+		node.Dead = false
+	}
+	node.ActiveWrites = activeWrites
+	node.LastSeen = time.Now()
+}
+
+// flags every DN that went quiet for longer than the dead timeout and returns the ones that just died
+func (reg *dataNodeRegistry) detectDeadNodes() []string {
+	reg.lock.Lock()
+	defer reg.lock.Unlock()
+
+	var died []string
+	for _, node := range reg.nodes {
+		if !node.Dead && time.Since(node.LastSeen) >= reg.deadTimeout {
+			node.Dead = true
+			died = append(died, node.NodeID)
+		}
+	}
+	return died
+}
+
+// returns the id of every DN that ever sent us a heartbeat, dead ones included, sorted
+func (reg *dataNodeRegistry) nodeIDs() []string {
+	reg.lock.Lock()
+	defer reg.lock.Unlock()
+
+	ids := make([]string, 0, len(reg.nodes))
+	for id := range reg.nodes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// returns a copy of every live DN, least loaded first
+// ties are broken by the node id so the order is stable between calls
+func (reg *dataNodeRegistry) liveNodes() []dataNodeInfo {
+	reg.lock.Lock()
+	defer reg.lock.Unlock()
+
+	var live []dataNodeInfo
+	for _, node := range reg.nodes {
+		if time.Since(node.LastSeen) < reg.deadTimeout {
+			live = append(live, *node)
+		}
+	}
+	sort.Slice(live, func(i, j int) bool {
+		if live[i].ActiveWrites != live[j].ActiveWrites {
+			return live[i].ActiveWrites < live[j].ActiveWrites
+		}
+		return live[i].NodeID < live[j].NodeID
+	})
+	return live
+}
+
+// picks the replicas for every chunk of an upload
+// chunk i starts at offset i in the (load sorted) live list and takes the next "replication" nodes,
+// that way consecutive chunks land on different DN's and no single node gets the whole file
+// if there are fewer live nodes than the replication factor, every chunk just goes to all of them
+func pickReplicas(live []dataNodeInfo, chunkCount, replication int) [][]string {
+	replicas := replication
+	if replicas > len(live) {
+		replicas = len(live)
+	}
+
+	placements := make([][]string, chunkCount)
+	for i := 0; i < chunkCount; i++ {
+		for r := 0; r < replicas; r++ {
+			placements[i] = append(placements[i], live[(i+r)%len(live)].NodeID)
+		}
+	}
+	return placements
+}
+
+// orders a chunk's locations the way a reader should try them:
+// live DN's first, least loaded first, then the ones we think are dead (or never heard of), in their original order
+func (reg *dataNodeRegistry) rankLocations(locations []string) []string {
+	reg.lock.Lock()
+	defer reg.lock.Unlock()
+
+	ranked := append([]string(nil), locations...)
+	rank := func(nodeID string) (bool, int) {
+		node, ok := reg.nodes[nodeID]
+		if !ok || time.Since(node.LastSeen) >= reg.deadTimeout {
+			return false, 0
+		}
+		return true, node.ActiveWrites
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		liveI, loadI := rank(ranked[i])
+		liveJ, loadJ := rank(ranked[j])
+		if liveI != liveJ {
+			return liveI
+		}
+		return liveI && loadI < loadJ
+	})
+	return ranked
+}
@@ -0,0 +1,72 @@
+package loadbalancer
+
+import (
+	"fmt"
+	"github.com/Rahul6700/Foodo/shared"
+)
+
+// erasure coded uploads -> the client sends its chunks plus the parity chunks of every stripe,
+// we put every shard of a stripe (data and parity) on its own DN, one copy each
+// losing a DN then costs a stripe at most one shard, and it survives as long as it keeps DataShards of them
+
+// checks an erasure coded upload request before we plan it, the namenodes check the layout again when they apply it
+func checkErasureRequest(req shared.ClientUploadRequest) error {
+	erasure := req.Erasure
+	if erasure.DataShards < 1 || erasure.ParityShards < 1 {
+		return fmt.Errorf("bad erasure coding %d+%d", erasure.DataShards, erasure.ParityShards)
+	}
+	stripes := (len(req.Chunks) + erasure.DataShards - 1) / erasure.DataShards
+	if len(req.Stripes) != stripes {
+		return fmt.Errorf("%d chunks in stripes of %d need %d stripes, got %d", len(req.Chunks), erasure.DataShards, stripes, len(req.Stripes))
+	}
+	for i, stripe := range req.Stripes {
+		if stripe.Index != i || len(stripe.Parity) != erasure.ParityShards {
+			return fmt.Errorf("stripe %d should be stripe %d with %d parity chunks", stripe.Index, i, erasure.ParityShards)
+		}
+	}
+	return nil
+}
+
+// plans the shards of an erasure coded upload session that nobody acknowledged yet, shardID -> [DN url]
+// every missing shard goes to a live DN (least loaded first) that doesnt hold another shard of its stripe yet,
+// if there is no such DN left it goes to the least loaded one, with no live DN's at all it gets no locations
+func planMissingShards(live []dataNodeInfo, erasure *shared.ErasureCoding, chunks []shared.ChunkStruct, stripes []shared.StripeStruct, acked map[string][]string) map[string][]string {
+	uploadPlan := make(map[string][]string)
+	for _, stripe := range stripes {
+		first := stripe.Index * erasure.DataShards
+		last := first + erasure.DataShards
+		if last > len(chunks) {
+			last = len(chunks)
+		}
+		shards := append(append([]shared.ChunkStruct(nil), chunks[first:last]...), stripe.Parity...)
+
+		used := make(map[string]bool)
+		for _, shard := range shards {
+			for _, location := range acked[shard.ChunkID] {
+				used[location] = true
+			}
+		}
+		for _, shard := range shards {
+			if len(acked[shard.ChunkID]) > 0 {
+				continue
+			}
+			if _, planned := uploadPlan[shard.ChunkID]; planned {
+				continue
+			}
+			if len(live) == 0 {
+				uploadPlan[shard.ChunkID] = nil // the caller answers 503 for these
+				continue
+			}
+			target := live[0].NodeID
+			for _, node := range live {
+				if !used[node.NodeID] {
+					target = node.NodeID
+					break
+				}
+			}
+			used[target] = true
+			uploadPlan[shard.ChunkID] = []string{target}
+		}
+	}
+	return uploadPlan
+}
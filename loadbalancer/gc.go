@@ -0,0 +1,127 @@
+package loadbalancer
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/Rahul6700/Foodo/shared"
+)
+
+// client used to talk to the DN's from the background jobs
+var dataNodeClient = &http.Client{Timeout: 10 * time.Second}
+
+// StartGarbageCollector runs forever, every interval it asks the leader which chunks are no longer used by any file,
+// deletes them from the DN's holding them and then tells the namenodes to forget them (PURGE_CHUNKS)
+// before deleting anything the chunks are marked through raft (MARK_DELETING), which only takes the ones that are still
+// garbage and stops uploads from reusing them, and we only delete what the leader lists as marked after that.
+// so a chunk that an upload picked up again between our two fetches is never deleted from under it
+// a chunk is only purged once every live DN confirmed the delete, so failed deletes are retried on the next round
+// dead DN's are skipped, we cant reach them anyway
+func (s *ApiServer) StartGarbageCollector(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	for range ticker.C {
+		if err := s.collectGarbage(); err != nil {
+			log.Printf("gc: %s", err)
+		}
+	}
+}
+
+// one round of the garbage collector
+func (s *ApiServer) collectGarbage() error {
+	garbage, err := s.fetchGarbageChunks()
+	if err != nil {
+		return fmt.Errorf("could not fetch garbage chunks: %w", err)
+	}
+
+	if len(garbage.Chunks) > 0 {
+		mark := shared.RaftCommand{Operation: "MARK_DELETING"}
+		for chunkID := range garbage.Chunks {
+			mark.Chunks = append(mark.Chunks, shared.ChunkStruct{ChunkID: chunkID})
+		}
+		if err := s.propose(mark); err != nil {
+			return fmt.Errorf("could not mark %d chunks for deletion: %w", len(mark.Chunks), err)
+		}
+		// whatever is marked now is fenced off, so this is the list we may delete
+		if garbage, err = s.fetchGarbageChunks(); err != nil {
+			return fmt.Errorf("could not fetch garbage chunks: %w", err)
+		}
+	}
+	if len(garbage.Deleting) == 0 {
+		return nil
+	}
+
+	purge := shared.RaftCommand{Operation: "PURGE_CHUNKS"}
+	for chunkID, locations := range garbage.Deleting {
+		deleted := true
+		for _, location := range locations {
+			if !s.dataNodes.isLive(location) && s.dataNodes.warmedUp() {
+				continue
+			}
+			if err := deleteChunk(location, chunkID); err != nil {
+				log.Printf("gc: could not delete chunk %s from %s: %s", chunkID, location, err)
+				deleted = false
+			}
+		}
+		if deleted {
+			purge.Chunks = append(purge.Chunks, shared.ChunkStruct{ChunkID: chunkID})
+		}
+	}
+
+	if len(purge.Chunks) == 0 {
+		return nil
+	}
+	if err := s.propose(purge); err != nil {
+		return fmt.Errorf("could not purge %d chunks: %w", len(purge.Chunks), err)
+	}
+	log.Printf("gc: deleted %d chunks", len(purge.Chunks))
+	return nil
+}
+
+// what the leader's /garbage-chunks lists (chunkID -> locations)
+type garbageChunks struct {
+	Chunks   map[string][]string `json:"chunks"`   // no longer used by any file
+	Deleting map[string][]string `json:"deleting"` // marked for deletion, nothing can use them anymore
+}
+
+// GET's the leader's /garbage-chunks
+func (s *ApiServer) fetchGarbageChunks() (garbageChunks, error) {
+	var body garbageChunks
+	leader, err := s.findLeader()
+	if err != nil {
+		return body, err
+	}
+
+	resp, err := namenodeClient.Get(leader + "/garbage-chunks")
+	if err != nil {
+		return body, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return body, fmt.Errorf("leader returned %s", resp.Status)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return body, err
+	}
+	return body, nil
+}
+
+// sends DELETE /chunk/:chunkID to a single DN
+func deleteChunk(location, chunkID string) error {
+	req, err := http.NewRequest(http.MethodDelete, location+"/chunk/"+chunkID, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := dataNodeClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("datanode returned %s", resp.Status)
+	}
+	return nil
+}
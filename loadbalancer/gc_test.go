@@ -0,0 +1,102 @@
+package loadbalancer
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Rahul6700/Foodo/shared"
+)
+
+func TestCollectGarbage(t *testing.T) {
+	tests := []struct {
+		name string
+		// runs between the GC's first look at the garbage and its MARK_DELETING
+		meanwhile  func(nn *fakeNamenode)
+		failDelete bool
+		events     []string // what the round does, in order
+		deleting   []string // chunks still marked after the round
+	}{
+		{
+			name: "mark, delete everywhere, then purge",
+			events: []string{
+				"propose MARK_DELETING [c1]",
+				"delete c1 from DN1",
+				"delete c1 from DN2",
+				"propose PURGE_CHUNKS [c1]",
+			},
+		},
+		{
+			name: "a chunk an upload took back before the mark is left alone",
+			meanwhile: func(nn *fakeNamenode) {
+				nn.apply(shared.RaftCommand{Operation: "BEGIN_UPLOAD", SessionID: "s1", Filename: "/b", Chunks: []shared.ChunkStruct{chunkOn("c1")}})
+			},
+			events: []string{"propose MARK_DELETING [c1]"},
+		},
+		{
+			name:       "a failed delete is not purged",
+			failDelete: true,
+			events:     []string{"propose MARK_DELETING [c1]", "delete c1 from DN1"},
+			deleting:   []string{"c1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := &recorder{}
+			nn := newFakeNamenode(t, rec)
+			dn1, dn2 := newFakeDatanode(t, rec), newFakeDatanode(t, rec)
+			dn2.failDeletes = tt.failDelete
+			lb := newTestLB(nn)
+
+			nn.apply(register("/a", chunkOn("c1", dn1.srv.URL, dn2.srv.URL)))
+			nn.apply(shared.RaftCommand{Operation: "DELETE_FILE", Filename: "/a"})
+			if tt.meanwhile != nil {
+				nn.afterGarbageFetch = func() {
+					nn.afterGarbageFetch = nil
+					tt.meanwhile(nn)
+				}
+			}
+
+			if err := lb.collectGarbage(); err != nil {
+				t.Fatal(err)
+			}
+			events := rec.take()
+			for i := range events {
+				events[i] = replaceAll(events[i], dn1.srv.URL, "DN1", dn2.srv.URL, "DN2")
+			}
+			if !reflect.DeepEqual(events, tt.events) {
+				t.Errorf("events = %q, want %q", events, tt.events)
+			}
+			_, deleting := nn.fsm.GetGarbageChunks()
+			if got := sortedIDs(deleting); !reflect.DeepEqual(got, orNone(tt.deleting)) {
+				t.Errorf("still deleting %v, want %v", got, tt.deleting)
+			}
+		})
+	}
+}
+
+// a delete that failed is retried on the next round, and only purged once it went through
+func TestCollectGarbageRetriesFailedDeletes(t *testing.T) {
+	rec := &recorder{}
+	nn := newFakeNamenode(t, rec)
+	dn := newFakeDatanode(t, rec)
+	lb := newTestLB(nn)
+	nn.apply(register("/a", chunkOn("c1", dn.srv.URL)))
+	nn.apply(shared.RaftCommand{Operation: "DELETE_FILE", Filename: "/a"})
+
+	dn.failDeletes = true
+	if err := lb.collectGarbage(); err != nil {
+		t.Fatal(err)
+	}
+	rec.take()
+	dn.failDeletes = false
+	if err := lb.collectGarbage(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"delete c1 from " + dn.srv.URL, "propose PURGE_CHUNKS [c1]"}
+	if events := rec.take(); !reflect.DeepEqual(events, want) {
+		t.Errorf("events = %q, want %q", events, want)
+	}
+	if garbage, deleting := nn.fsm.GetGarbageChunks(); len(garbage)+len(deleting) > 0 {
+		t.Errorf("garbage %v and deleting %v left after the purge", garbage, deleting)
+	}
+}
@@ -0,0 +1,257 @@
+package loadbalancer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+	"github.com/Rahul6700/Foodo/shared"
+	"github.com/gin-gonic/gin"
+)
+
+// client used for every call the LB makes to the namenodes
+var namenodeClient = &http.Client{Timeout: 10 * time.Second}
+
+// asks every namenode for its /status and returns the api address of the one that says it is the leader
+// the last known leader is tried first, so in the normal case this is a single request
+// a follower's answer names the leader, so we go there next instead of trying the rest of the list
+func (s *ApiServer) findLeader() (string, error) {
+	s.leaderLock.Lock()
+	cached := s.leaderAddr
+	s.leaderLock.Unlock()
+
+	candidates := s.namenodes
+	if cached != "" {
+		candidates = append([]string{cached}, s.namenodes...)
+	}
+
+	asked := make(map[string]bool)
+	for i := 0; i < len(candidates); i++ {
+		addr := candidates[i]
+		if asked[addr] {
+			continue
+		}
+		asked[addr] = true
+		resp, err := namenodeClient.Get(addr + "/status")
+		if err != nil {
+			continue // namenode is down, try the next one
+		}
+		var status struct {
+			Leader string `json:"leader"` // followers say who the leader is, if they know
+		}
+		json.NewDecoder(resp.Body).Decode(&status)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK && status.Leader != "" && !asked[status.Leader] {
+			// ask the leader next, it may be a namenode that joined after we started and isnt in -namenodes
+			candidates = slices.Insert(slices.Clone(candidates), i+1, status.Leader)
+			continue
+		}
+		if resp.StatusCode == http.StatusOK {
+			s.leaderLock.Lock()
+			s.leaderAddr = addr
+			s.leaderLock.Unlock()
+			return addr, nil
+		}
+	}
+
+	// nobody claimed leadership, forget the cached one so we dont keep hitting it first
+	s.leaderLock.Lock()
+	s.leaderAddr = ""
+	s.leaderLock.Unlock()
+	return "", fmt.Errorf("no namenode leader found")
+}
+
+// sends a raft command to the leader's /raft/propose
+// it only returns once the namenodes have committed (and applied) it
+func (s *ApiServer) propose(cmd interface{}) error {
+	cmdBytes, err := json.Marshal(cmd)
+	if err != nil {
+		return fmt.Errorf("failed to marshal raft command: %w", err)
+	}
+
+	leader, err := s.findLeader()
+	if err != nil {
+		return err
+	}
+
+	resp, err := namenodeClient.Post(leader+"/raft/propose", "application/json", bytes.NewBuffer(cmdBytes))
+	if err != nil {
+		return fmt.Errorf("failed to reach leader %s: %w", leader, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("leader %s rejected proposal: %s %s", leader, resp.Status, body)
+	}
+	return nil
+}
+
+// proxies the incoming request to the same method on the leader, on the given path (the query string is kept)
+// whatever the leader answers is copied back to the caller as is
+func (s *ApiServer) forwardToLeader(c *gin.Context, path string) {
+	if c.Request.Method == http.MethodGet && c.Query("consistency") == shared.ConsistencyStale {
+		s.forwardStaleRead(c, path)
+		return
+	}
+	leader, err := s.findLeader()
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+
+	reqURL := leader + path
+	if c.Request.URL.RawQuery != "" {
+		reqURL += "?" + c.Request.URL.RawQuery
+	}
+
+	req, err := http.NewRequest(c.Request.Method, reqURL, c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	req.Header.Set("Content-Type", c.GetHeader("Content-Type"))
+
+	resp, err := namenodeClient.Do(req)
+	if err != nil {
+		log.Printf("failed to forward %s to leader %s: %s", path, leader, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "failed to reach namenode leader"})
+		return
+	}
+	defer resp.Body.Close()
+	copyNamenodeResponse(c, resp)
+}
+
+// a stale read can be answered by any namenode, so they are spread over all of them instead of all landing on the leader
+// one that is down is skipped, the caller only gets an error if none of them answers
+func (s *ApiServer) forwardStaleRead(c *gin.Context, path string) {
+	resp, err := s.getStale(path + "?" + c.Request.URL.RawQuery)
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+	defer resp.Body.Close()
+	copyNamenodeResponse(c, resp)
+}
+
+// GET's pathAndQuery from the next namenode in the round robin, the caller closes the body
+func (s *ApiServer) getStale(pathAndQuery string) (*http.Response, error) {
+	start := s.staleReads.Add(1)
+	for i := range s.namenodes {
+		addr := s.namenodes[(int(start)+i)%len(s.namenodes)]
+		resp, err := namenodeClient.Get(addr + pathAndQuery)
+		if err != nil {
+			log.Printf("failed to forward stale read %s to %s: %s", pathAndQuery, addr, err)
+			continue
+		}
+		return resp, nil
+	}
+	return nil, fmt.Errorf("no namenode reachable")
+}
+
+// copies a namenode's answer back to the caller
+func copyNamenodeResponse(c *gin.Context, resp *http.Response) {
+	c.DataFromReader(resp.StatusCode, resp.ContentLength, resp.Header.Get("Content-Type"), resp.Body, readHeaders(resp))
+}
+
+// reads come back with how fresh they are (see namenode/consistency.go), the client gets those headers too
+func readHeaders(resp *http.Response) map[string]string {
+	extra := map[string]string{}
+	for _, header := range []string{shared.AppliedIndexHeader, shared.LastContactHeader} {
+		if value := resp.Header.Get(header); value != "" {
+			extra[header] = value
+		}
+	}
+	return extra
+}
+
+// POST's the chunk ids to the leader's /lookup-chunks and returns the ones that are already stored (chunkID -> locations)
+func (s *ApiServer) lookupChunks(chunkIDs []string) (map[string][]string, error) {
+	reqBody, err := json.Marshal(map[string][]string{"chunk_ids": chunkIDs})
+	if err != nil {
+		return nil, err
+	}
+
+	leader, err := s.findLeader()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := namenodeClient.Post(leader+"/lookup-chunks", "application/json", bytes.NewBuffer(reqBody))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("leader returned %s", resp.Status)
+	}
+
+	var body struct {
+		Chunks map[string][]string `json:"chunks"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	return body.Chunks, nil
+}
+
+// POST's body as JSON to path on the leader and returns its status and body as is
+func (s *ApiServer) postToLeader(path string, body interface{}) (int, []byte, error) {
+	reqBody, err := json.Marshal(body)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	leader, err := s.findLeader()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	resp, err := namenodeClient.Post(leader+path, "application/json", bytes.NewBuffer(reqBody))
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to reach leader %s: %w", leader, err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+	return resp.StatusCode, respBody, nil
+}
+
+// GET's pathAndQuery on the leader and returns its status and body as is
+func (s *ApiServer) getFromLeader(pathAndQuery string) (int, []byte, error) {
+	status, _, body, err := s.getRead(pathAndQuery, false)
+	return status, body, err
+}
+
+// GET's pathAndQuery from the leader, or from any namenode if stale, and returns the answer with its read headers
+func (s *ApiServer) getRead(pathAndQuery string, stale bool) (int, map[string]string, []byte, error) {
+	var resp *http.Response
+	if stale {
+		var err error
+		if resp, err = s.getStale(pathAndQuery); err != nil {
+			return 0, nil, nil, err
+		}
+	} else {
+		leader, err := s.findLeader()
+		if err != nil {
+			return 0, nil, nil, err
+		}
+		if resp, err = namenodeClient.Get(leader + pathAndQuery); err != nil {
+			return 0, nil, nil, fmt.Errorf("failed to reach leader %s: %w", leader, err)
+		}
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	return resp.StatusCode, readHeaders(resp), body, nil
+}
@@ -0,0 +1,29 @@
+package loadbalancer
+
+import (
+	"fmt"
+	"github.com/Rahul6700/Foodo/shared"
+)
+
+// an upload can name a storage policy (see shared/policy.go) instead of a replication,
+// a replicated policy just sets req.Replication, an erasure coded one needs the client to have encoded the file with its layout
+func applyPolicy(req *shared.ClientUploadRequest) error {
+	if req.Policy == "" {
+		return nil
+	}
+	policy, ok := shared.LookupPolicy(req.Policy)
+	if !ok {
+		return fmt.Errorf("unknown storage policy %s", req.Policy)
+	}
+	if policy.Erasure == nil {
+		if req.Erasure != nil {
+			return fmt.Errorf("policy %s is replicated, the file shouldnt be erasure coded", req.Policy)
+		}
+		req.Replication = policy.Replication
+		return nil
+	}
+	if req.Erasure == nil || *req.Erasure != *policy.Erasure {
+		return fmt.Errorf("policy %s is erasure coded %d+%d, the file has to be encoded with that layout", req.Policy, policy.Erasure.DataShards, policy.Erasure.ParityShards)
+	}
+	return nil
+}
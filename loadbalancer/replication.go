@@ -0,0 +1,243 @@
+package loadbalancer
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/Rahul6700/Foodo/shared"
+)
+
+// StartReplicationManager runs forever, every interval it compares every chunk's locations against the DN's that are still alive
+// chunks that dropped below the replicas their files want are copied from a surviving replica to new DN's,
+// chunks that have more than that (a file's replication went down) lose their extra replicas,
+// and the changes (dead DN's dropped, new ones added, extra ones removed) are committed with an UPDATE_LOCATIONS command
+func (s *ApiServer) StartReplicationManager(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	for range ticker.C {
+		// until a full dead timeout has passed we havent heard from every DN yet, and they would all look dead
+		if !s.dataNodes.warmedUp() {
+			continue
+		}
+		if err := s.repairReplicas(); err != nil {
+			log.Printf("replication: %s", err)
+		}
+	}
+}
+
+// one round of the replication manager
+func (s *ApiServer) repairReplicas() error {
+	for _, nodeID := range s.dataNodes.detectDeadNodes() {
+		log.Printf("replication: datanode %s is dead, re-replicating its chunks", nodeID)
+	}
+
+	live := s.dataNodes.liveNodes()
+	if len(live) == 0 {
+		return fmt.Errorf("no live datanodes, skipping")
+	}
+	liveSet := make(map[string]bool, len(live))
+	for _, node := range live {
+		liveSet[node.NodeID] = true
+	}
+
+	chunks, targets, err := s.fetchChunkLocations()
+	if err != nil {
+		return fmt.Errorf("could not fetch chunk locations: %w", err)
+	}
+
+	update := shared.RaftCommand{Operation: "UPDATE_LOCATIONS"}
+	extra := make(map[string][]string) // chunkID -> DN's to delete it from, once the update no longer points at them
+	for chunkID, locations := range chunks {
+		// the namenodes tell us how many copies every chunk wants (its files' replication, 1 for erasure coded shards)
+		// we cant have more replicas than live DN's either way
+		target := s.replication
+		if t, ok := targets[chunkID]; ok {
+			target = t
+		}
+		if target > len(live) {
+			target = len(live)
+		}
+
+		var liveLocations []string
+		for _, location := range locations {
+			if liveSet[location] {
+				liveLocations = append(liveLocations, location)
+			}
+		}
+		if len(liveLocations) == len(locations) && len(liveLocations) == target {
+			continue // nothing to do
+		}
+		if len(liveLocations) == 0 {
+			// every replica is on a dead DN, all we can do is wait for one of them to come back
+			log.Printf("replication: chunk %s has no live replica left", chunkID)
+			continue
+		}
+		if len(liveLocations) > target {
+			// we keep the least loaded replicas, the others are deleted after the update is committed
+			ranked := s.dataNodes.rankLocations(liveLocations)
+			update.Chunks = append(update.Chunks, locationChanges(chunkID, locations, ranked[:target]))
+			extra[chunkID] = ranked[target:]
+			continue
+		}
+
+		newLocations := append([]string(nil), liveLocations...)
+		for _, node := range live { // live is sorted least loaded first
+			if len(newLocations) >= target {
+				break
+			}
+			if containsString(newLocations, node.NodeID) {
+				continue
+			}
+			if err := replicateChunk(node.NodeID, liveLocations[0], chunkID); err != nil {
+				log.Printf("replication: could not copy chunk %s from %s to %s: %s", chunkID, liveLocations[0], node.NodeID, err)
+				continue
+			}
+			newLocations = append(newLocations, node.NodeID)
+		}
+
+		// if no replica was dead and every copy failed there is nothing to commit
+		if change := locationChanges(chunkID, locations, newLocations); len(change.Added) > 0 || len(change.Removed) > 0 {
+			update.Chunks = append(update.Chunks, change)
+		}
+	}
+
+	if len(update.Chunks) == 0 {
+		return nil
+	}
+	if err := s.propose(update); err != nil {
+		return fmt.Errorf("could not commit %d location updates: %w", len(update.Chunks), err)
+	}
+	log.Printf("replication: updated locations of %d chunks", len(update.Chunks))
+
+	// if a delete fails the copy stays behind on its DN, block reports then find it as an orphan and delete it
+	removed := 0
+	for chunkID, nodes := range extra {
+		for _, nodeID := range nodes {
+			if err := deleteChunk(nodeID, chunkID); err != nil {
+				log.Printf("replication: could not delete extra replica of chunk %s from %s: %s", chunkID, nodeID, err)
+				continue
+			}
+			removed++
+		}
+	}
+	if removed > 0 {
+		log.Printf("replication: removed %d extra replicas", removed)
+	}
+	return nil
+}
+
+// removes nodeID from the locations of the given chunks (UPDATE_LOCATIONS), used when a DN or a client reports bad copies
+// the replication manager then sees those chunks as under-replicated and copies them from a healthy replica
+// a chunk's last location is never dropped, without it there is nothing left to copy from or to retry
+func (s *ApiServer) dropReplicas(nodeID string, chunkIDs []string) error {
+	current, err := s.lookupChunks(chunkIDs)
+	if err != nil {
+		return err
+	}
+
+	update := shared.RaftCommand{Operation: "UPDATE_LOCATIONS"}
+	for chunkID, locations := range current {
+		if !containsString(locations, nodeID) {
+			continue
+		}
+		if len(locations) == 1 {
+			log.Printf("replication: %s has the only replica of chunk %s, keeping it", nodeID, chunkID)
+			continue
+		}
+		update.Chunks = append(update.Chunks, shared.ChunkStruct{ChunkID: chunkID, Removed: []string{nodeID}})
+	}
+
+	if len(update.Chunks) == 0 {
+		return nil
+	}
+	if err := s.propose(update); err != nil {
+		return err
+	}
+	log.Printf("replication: dropped %d bad replicas on %s", len(update.Chunks), nodeID)
+	return nil
+}
+
+// the UPDATE_LOCATIONS entry that takes a chunk from the locations we read to the ones we want
+// only the differences are sent, the namenodes apply them to whatever the chunk has by then
+func locationChanges(chunkID string, old, new []string) shared.ChunkStruct {
+	chunk := shared.ChunkStruct{ChunkID: chunkID}
+	for _, location := range new {
+		if !containsString(old, location) {
+			chunk.Added = append(chunk.Added, location)
+		}
+	}
+	for _, location := range old {
+		if !containsString(new, location) {
+			chunk.Removed = append(chunk.Removed, location)
+		}
+	}
+	return chunk
+}
+
+// GET's the leader's /chunk-locations -> every chunk's locations, and the replicas every chunk wants
+func (s *ApiServer) fetchChunkLocations() (map[string][]string, map[string]int, error) {
+	leader, err := s.findLeader()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	resp, err := namenodeClient.Get(fmt.Sprintf("%s/chunk-locations?replication=%d", leader, s.replication))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("leader returned %s", resp.Status)
+	}
+
+	var body struct {
+		Chunks  map[string][]string `json:"chunks"`
+		Targets map[string]int      `json:"targets"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, nil, err
+	}
+	return body.Chunks, body.Targets, nil
+}
+
+// asks the DN whether its copy of the chunk is missing or corrupt (a corrupt one is quarantined by the DN)
+func verifyReplica(nodeID, chunkID string) (bool, error) {
+	resp, err := dataNodeClient.Get(nodeID + "/verifyChunk/" + chunkID)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return false, nil
+	case http.StatusNotFound, http.StatusConflict:
+		return true, nil
+	}
+	return false, fmt.Errorf("datanode returned %s", resp.Status)
+}
+
+// asks the target DN to pull the chunk from the source DN
+func replicateChunk(target, source, chunkID string) error {
+	reqURL := fmt.Sprintf("%s/replicateChunk/%s?source=%s", target, chunkID, url.QueryEscape(source))
+	resp, err := dataNodeClient.Post(reqURL, "application/json", nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("datanode returned %s", resp.Status)
+	}
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
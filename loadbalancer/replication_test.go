@@ -0,0 +1,80 @@
+package loadbalancer
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/Rahul6700/Foodo/shared"
+	"github.com/gin-gonic/gin"
+)
+
+func TestDropReplicas(t *testing.T) {
+	tests := []struct {
+		name      string
+		locations []string // DN names, "dn1" is the one dropped
+		want      []string
+	}{
+		{"one of two", []string{"dn1", "dn2"}, []string{"dn2"}},
+		{"one of three", []string{"dn2", "dn1", "dn3"}, []string{"dn2", "dn3"}},
+		{"the last replica is kept", []string{"dn1"}, []string{"dn1"}},
+		{"not a replica", []string{"dn2", "dn3"}, []string{"dn2", "dn3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := &recorder{}
+			nn := newFakeNamenode(t, rec)
+			lb := newTestLB(nn)
+			nn.apply(register("/a", chunkOn("c1", tt.locations...)))
+
+			if err := lb.dropReplicas("dn1", []string{"c1"}); err != nil {
+				t.Fatal(err)
+			}
+			if got := nn.locations("c1"); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("locations = %v, want %v", got, tt.want)
+			}
+			if changed := !reflect.DeepEqual(tt.locations, tt.want); changed != (len(rec.take()) > 0) {
+				t.Errorf("proposed an update: %t, want %t", !changed, changed)
+			}
+		})
+	}
+}
+
+// a client's report only drops a replica the DN itself confirms is bad, and never the last one
+func TestReportBadReplicas(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	rec := &recorder{}
+	nn := newFakeNamenode(t, rec)
+	dn1, dn2 := newFakeDatanode(t, rec), newFakeDatanode(t, rec)
+	lb := newTestLB(nn)
+	nn.apply(register("/a", chunkOn("fine", dn1.srv.URL, dn2.srv.URL), chunkOn("bad", dn1.srv.URL, dn2.srv.URL), chunkOn("only", dn1.srv.URL)))
+	dn1.bad["bad"], dn1.bad["only"] = true, true
+
+	r := gin.New()
+	lb.RegisterRoutes(r)
+	body, _ := json.Marshal([]shared.BadReplica{
+		{ChunkID: "fine", NodeID: dn1.srv.URL, Reason: "corrupt"},
+		{ChunkID: "bad", NodeID: dn1.srv.URL, Reason: "corrupt"},
+		{ChunkID: "only", NodeID: dn1.srv.URL, Reason: "missing"},
+		{ChunkID: "bad", NodeID: dn2.srv.URL, Reason: "unreachable"},
+	})
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/reportBadReplicas", bytes.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("report answered %d: %s", w.Code, w.Body)
+	}
+
+	want := map[string][]string{
+		"fine": {dn1.srv.URL, dn2.srv.URL},
+		"bad":  {dn2.srv.URL},
+		"only": {dn1.srv.URL},
+	}
+	for chunkID, locations := range want {
+		if got := nn.locations(chunkID); !reflect.DeepEqual(got, locations) {
+			t.Errorf("locations of %s = %v, want %v", chunkID, got, locations)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package loadbalancer
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/Rahul6700/Foodo/shared"
+	"github.com/gin-gonic/gin"
+)
+
+// a new random upload session id, the LB picks it so every namenode applies the same one
+func newSessionID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// what the client gets back from /uploadStatus when it resumes an upload
+type uploadStatusResponse struct {
+	SessionID  string              `json:"session_id"`
+	Filename   string              `json:"filename"`
+	UploadPlan map[string][]string `json:"upload_plan"` // chunks that still need (more) replicas for the commit -> DN urls
+	Acked      map[string][]string `json:"acked"`       // chunks already stored -> replicas that acknowledged them
+}
+
+// the state of an upload session -> /uploadStatus?session_id=abc
+// chunks that don't have enough acknowledged replicas for the commit yet get a fresh plan on the DN's that are alive now,
+// the ones planned when the session began may have died since. DN's that already acknowledged a chunk are left out of its plan
+func (s *ApiServer) handleUploadStatus(c *gin.Context) {
+	status, body, err := s.getFromLeader("/upload/status?" + c.Request.URL.RawQuery)
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+	if status != http.StatusOK {
+		c.Data(status, "application/json", body)
+		return
+	}
+
+	var session struct {
+		ID          string                `json:"id"`
+		Filename    string                `json:"filename"`
+		Chunks      []shared.ChunkStruct  `json:"chunks"`
+		Acked       map[string][]string   `json:"acked"`
+		Erasure     *shared.ErasureCoding `json:"erasure"`
+		Stripes     []shared.StripeStruct `json:"stripes"`
+		Replication int                   `json:"replication"`
+	}
+	if err := json.Unmarshal(body, &session); err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "bad upload session from namenode leader"})
+		return
+	}
+
+	live := s.dataNodes.liveNodes()
+	if session.Erasure != nil {
+		uploadPlan := planMissingShards(live, session.Erasure, session.Chunks, session.Stripes, session.Acked)
+		if len(uploadPlan) > 0 && len(live) == 0 {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "no live datanodes"})
+			return
+		}
+		c.JSON(http.StatusOK, uploadStatusResponse{SessionID: session.ID, Filename: session.Filename, UploadPlan: uploadPlan, Acked: session.Acked})
+		return
+	}
+	// the commit wants min-replicas acks per chunk, or the file's own replication if that is lower
+	replication := s.replication
+	if session.Replication > 0 {
+		replication = session.Replication
+	}
+	needed := s.minReplicas
+	if needed > replication {
+		needed = replication
+	}
+	if needed > len(live) {
+		needed = len(live)
+	}
+	var pending []shared.ChunkStruct
+	for _, chunk := range session.Chunks {
+		if acked := len(session.Acked[chunk.ChunkID]); acked == 0 || acked < needed {
+			pending = append(pending, chunk)
+		}
+	}
+	if len(pending) > 0 && len(live) == 0 {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "no live datanodes"})
+		return
+	}
+	placements := pickReplicas(live, len(pending), replication)
+	uploadPlan := make(map[string][]string)
+	for i, chunk := range pending {
+		if _, ok := uploadPlan[chunk.ChunkID]; ok {
+			continue
+		}
+		var locations []string
+		for _, location := range placements[i] {
+			if !containsString(session.Acked[chunk.ChunkID], location) {
+				locations = append(locations, location)
+			}
+		}
+		if len(locations) == 0 {
+			locations = placements[i]
+		}
+		uploadPlan[chunk.ChunkID] = locations
+	}
+
+	c.JSON(http.StatusOK, uploadStatusResponse{
+		SessionID:  session.ID,
+		Filename:   session.Filename,
+		UploadPlan: uploadPlan,
+		Acked:      session.Acked,
+	})
+}
+
+// commits an upload session -> {"session_id": "...", "chunks": [...]}, chunks are the client's last acknowledgments
+// the namenodes only commit it if every chunk has at least minReplicas acknowledged replicas (fewer if we dont have
+// that many live DN's, but never fewer than the namenodes' own -min-replicas), otherwise the leader's 409 goes back
+// to the client and the session stays open
+func (s *ApiServer) handleCommitUpload(c *gin.Context) {
+	var req shared.UploadSessionRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.SessionID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing 'session_id'"})
+		return
+	}
+
+	req.MinReplicas = s.minReplicas
+	if live := len(s.dataNodes.liveNodes()); live < req.MinReplicas {
+		log.Printf("only %d datanodes are live, committing upload %s with %d replicas per chunk instead of %d (the namenodes' -min-replicas still applies)", live, req.SessionID, live, s.minReplicas)
+		req.MinReplicas = live
+	}
+
+	status, body, err := s.postToLeader("/upload/commit", req)
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+	if status == http.StatusOK {
+		log.Printf("committed upload %s", req.SessionID)
+	}
+	c.Data(status, "application/json", body)
+}
+
+// StartUploadExpiry runs forever, every interval it expires the upload sessions that are older than ttl (EXPIRE_UPLOAD)
+// those are uploads whose client died or gave up without aborting, expiring them drops their pending file
+// and lets the garbage collector have the chunks nothing else uses
+func (s *ApiServer) StartUploadExpiry(interval, ttl time.Duration) {
+	ticker := time.NewTicker(interval)
+	for range ticker.C {
+		sessions, err := s.fetchUploadSessions()
+		if err != nil {
+			log.Printf("upload expiry: could not fetch upload sessions: %s", err)
+			continue
+		}
+
+		for _, session := range sessions {
+			// sessions come oldest first, so the first one that is young enough ends the round
+			if time.Since(session.CreatedAt) < ttl {
+				break
+			}
+			expire := shared.RaftCommand{Operation: "EXPIRE_UPLOAD", SessionID: session.ID, Timestamp: time.Now().UnixNano()}
+			if err := s.propose(expire); err != nil {
+				log.Printf("upload expiry: could not expire upload %s of %s: %s", session.ID, session.Filename, err)
+				continue
+			}
+			log.Printf("upload expiry: expired upload %s of %s, started %s", session.ID, session.Filename, session.CreatedAt.Format(time.RFC3339))
+		}
+	}
+}
+
+// an open upload session, as the leader's /upload/sessions lists it
+type uploadSession struct {
+	ID        string    `json:"id"`
+	Filename  string    `json:"filename"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// GET's the leader's /upload/sessions
+func (s *ApiServer) fetchUploadSessions() ([]uploadSession, error) {
+	status, body, err := s.getFromLeader("/upload/sessions")
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("leader returned %d", status)
+	}
+
+	var list struct {
+		Sessions []uploadSession `json:"sessions"`
+	}
+	if err := json.Unmarshal(body, &list); err != nil {
+		return nil, err
+	}
+	return list.Sessions, nil
+}
@@ -1,9 +1,14 @@
 package namenode
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 	"time"
+	"github.com/Rahul6700/Foodo/shared"
 	"github.com/gin-gonic/gin"
 	"github.com/hashicorp/raft"
 )
@@ -12,30 +17,64 @@ import (
 type ApiServer struct {
 	raft *raft.Raft
 	fsm *FSM
+	apiAddr string // where our API is reachable -> "http://localhost:8001", followers forward to it while we are the leader
+	leaseTimeout time.Duration // raft's LeaderLeaseTimeout, how long a read lease lasts (consistency.go)
+	lease readLease
+	minReplicas int // the least replicas a commit can ask for, the LB lowers its -min-replicas when DN's are down
 }
 
 // this method creates a new namenode server
 // we pass in our main raftNode object
-func NewApiServer(r *raft.Raft, fsm *FSM) *ApiServer {
+func NewApiServer(r *raft.Raft, fsm *FSM, apiAddr string, leaseTimeout time.Duration, minReplicas int) *ApiServer {
 	return &ApiServer{
 		raft: r,
 		fsm: fsm,
+		apiAddr: apiAddr,
+		leaseTimeout: leaseTimeout,
+		minReplicas: minReplicas,
 	}
 }
 
 // This is where the RAFT server interacts with GIN to expose endpoints
 // we have "/status" which tells whether a Raft Namenode is a the leader or not
 // "/raft/purpose" endpoints listens to the proposed plan that the LB sends
-func (server *ApiServer) RegisterRoutes(r *gin.Engine) {
-	r.GET("/status", server.handleStatus)
+// everything in the leader group only runs on the leader, followers forward those requests to it (forward.go)
+func (server *ApiServer) RegisterRoutes(engine *gin.Engine) {
+	engine.GET("/status", server.handleStatus)
+	engine.GET("/cluster/members", server.handleClusterMembers)
+
+	r := engine.Group("", server.forwardToLeader)
 	r.POST("/raft/propose", server.handlePropose)
 	r.GET("/get-metadata", server.handleGetMetadata)
+	r.POST("/delete-file", server.handleDeleteFile)
+	r.GET("/garbage-chunks", server.handleGetGarbageChunks)
+	r.GET("/chunk-locations", server.handleGetChunkLocations)
+	r.POST("/lookup-chunks", server.handleLookupChunks)
+	r.GET("/dedup-stats", server.handleDedupStats)
+	// the namespace -> directories, listing, moving and deleting paths
+	r.POST("/mkdir", server.handleMkdir)
+	r.POST("/rename", server.handleRename)
+	r.POST("/delete", server.handleDelete)
+	r.GET("/ls", server.handleListDir)
+	r.GET("/files", server.handleListFiles)
+	r.GET("/stat", server.handleStat)
+	r.POST("/set-replication", server.handleSetReplication)
+	// upload sessions -> the LB opens them with a BEGIN_UPLOAD proposal, the client acks its chunks and commits
+	r.POST("/upload/ack", server.handleUploadCommand("ACK_CHUNKS"))
+	r.POST("/upload/commit", server.handleUploadCommand("COMMIT_UPLOAD"))
+	r.POST("/upload/abort", server.handleUploadCommand("ABORT_UPLOAD"))
+	r.GET("/upload/status", server.handleUploadStatus)
+	r.GET("/upload/sessions", server.handleListUploadSessions)
+	// raft membership -> adding and removing namenodes at runtime (cluster.go)
+	r.POST("/cluster/join", server.handleClusterJoin)
+	r.POST("/cluster/remove", server.handleClusterRemove)
 }
 
 // this endpoint is used by the LB to find whether the namenode is the leader or no, return true or false accordingly
+// a follower also says who the leader is, if it knows its API address
 func (s* ApiServer) handleStatus (c* gin.Context) {
 	if s.raft.State() != raft.Leader {
-		c.JSON(503, gin.H{"status" : "false"})
+		c.JSON(503, gin.H{"status" : "false", "leader" : s.leaderAPIAddr()})
 		return
 	}
 	c.JSON(200, gin.H{"status" : "true"})
@@ -74,15 +113,19 @@ func (s *ApiServer) handlePropose(c *gin.Context) {
 		c.JSON(503, gin.H{"error": "failed to apply raft command"})
 		return
 	}
+	// the command was committed, but the FSM can still refuse it (unknown file, unknown operation...)
+	if err, ok := applyFuture.Response().(error); ok {
+		s.respondApplyError(c, err)
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{"success": true})
 }
 
 func (s *ApiServer) handleGetMetadata(c *gin.Context) {
 	// Only the leader should answer read requests
-	// to prevent serving "stale" (old) data.
-	if s.raft.State() != raft.Leader {
-		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "not the leader"})
+	// to prevent serving "stale" (old) data, unless the caller asks for a stale read (see consistency.go)
+	if !s.checkRead(c) {
 		return
 	}
 
@@ -102,12 +145,321 @@ func (s *ApiServer) handleGetMetadata(c *gin.Context) {
     //        raft *raft.Raft
     //        fsm  *Fsm  // <-- ADD THIS
     //    }
-	plan, err := s.fsm.GetFileMetadata(fileName) // <-- ASSUMES 'fsm' IS AVAILABLE
+	metadata, err := s.fsm.GetFileMetadata(fileName) // <-- ASSUMES 'fsm' IS AVAILABLE
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
 		return
 	}
 
 	// 3. Send the plan back to the Load Balancer
-	c.JSON(http.StatusOK, gin.H{"chunks": plan})
+	c.JSON(http.StatusOK, metadata)
+}
+
+// returned by applyCommand when raft itself failed (lost leadership, timed out...), as opposed to the FSM rejecting the command
+var errRaftApply = errors.New("failed to apply raft command")
+
+// marshals a command and pushes it through raft, same as handlePropose does with the LB's raw bytes
+// returns an error if raft could not commit it, or if the FSM rejected it while applying
+func (s *ApiServer) applyCommand(cmd RaftCommand) error {
+	if cmd.Timestamp == 0 {
+		cmd.Timestamp = time.Now().UnixNano()
+	}
+	cmdBytes, err := json.Marshal(cmd)
+	if err != nil {
+		return fmt.Errorf("could not marshal command: %s", err)
+	}
+	applyFuture := s.raft.Apply(cmdBytes, 5*time.Second)
+	if err := applyFuture.Error(); err != nil {
+		log.Printf("raft apply error in api.go: %s\n", err)
+		return errRaftApply
+	}
+	if err, ok := applyFuture.Response().(error); ok {
+		return err
+	}
+	return nil
+}
+
+// raft failures are the cluster's fault (503), anything else was the FSM refusing the request
+func (s *ApiServer) respondApplyError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, errRaftApply), errors.Is(err, ErrChunkDeleting):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrExists), errors.Is(err, ErrIncomplete):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	}
+}
+
+// removes a file -> /delete-file?filename=foo.txt
+// the chunks are not deleted here, the ones nobody else uses end up in /garbage-chunks for the LB to clean up
+func (s *ApiServer) handleDeleteFile(c *gin.Context) {
+	if s.raft.State() != raft.Leader {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "not the leader"})
+		return
+	}
+
+	fileName := c.Query("filename")
+	if fileName == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing 'filename' query parameter"})
+		return
+	}
+
+	if err := s.applyCommand(RaftCommand{Operation: "DELETE_FILE", Filename: fileName}); err != nil {
+		s.respondApplyError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// the LB's garbage collector polls this to find out which chunks it should delete from the DN's
+// "chunks" are garbage, "deleting" the ones it marked (MARK_DELETING) and may delete now
+func (s *ApiServer) handleGetGarbageChunks(c *gin.Context) {
+	if s.raft.State() != raft.Leader {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "not the leader"})
+		return
+	}
+	garbage, deleting := s.fsm.GetGarbageChunks()
+	c.JSON(http.StatusOK, gin.H{"chunks": garbage, "deleting": deleting})
+}
+
+// every chunk and where it lives, the LB's replication manager compares this against the DN's it knows are alive
+// "targets" are the replicas every chunk wants -> /chunk-locations?replication=3, replication is the LB's default for files without their own
+func (s *ApiServer) handleGetChunkLocations(c *gin.Context) {
+	if s.raft.State() != raft.Leader {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "not the leader"})
+		return
+	}
+
+	defaultReplication, err := strconv.Atoi(c.Query("replication"))
+	if err != nil || defaultReplication < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "'replication' must be at least 1"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"chunks": s.fsm.GetAllChunkLocations(), "targets": s.fsm.ReplicationTargets(defaultReplication)})
+}
+
+// tells the LB which of the chunks it is about to place are already stored somewhere
+// body -> {"chunk_ids": ["abc", "def"]}, answer -> {"chunks": {"abc": [DN urls]}} (unknown chunks are left out)
+func (s *ApiServer) handleLookupChunks(c *gin.Context) {
+	if s.raft.State() != raft.Leader {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "not the leader"})
+		return
+	}
+
+	var body struct {
+		ChunkIDs []string `json:"chunk_ids"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad request body"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"chunks": s.fsm.LookupChunks(body.ChunkIDs)})
+}
+
+func (s *ApiServer) handleDedupStats(c *gin.Context) {
+	if s.raft.State() != raft.Leader {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "not the leader"})
+		return
+	}
+	c.JSON(http.StatusOK, s.fsm.GetDedupStats())
+}
+
+// creates a directory and its missing parents -> /mkdir?path=/logs/app
+func (s *ApiServer) handleMkdir(c *gin.Context) {
+	if s.raft.State() != raft.Leader {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "not the leader"})
+		return
+	}
+
+	dir := c.Query("path")
+	if dir == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing 'path' query parameter"})
+		return
+	}
+
+	if err := s.applyCommand(RaftCommand{Operation: "MKDIR", Filename: dir}); err != nil {
+		s.respondApplyError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// moves a file or a directory -> /rename?src=/a/x.txt&dst=/b/x.txt
+func (s *ApiServer) handleRename(c *gin.Context) {
+	if s.raft.State() != raft.Leader {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "not the leader"})
+		return
+	}
+
+	src, dst := c.Query("src"), c.Query("dst")
+	if src == "" || dst == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing 'src' or 'dst' query parameter"})
+		return
+	}
+
+	if err := s.applyCommand(RaftCommand{Operation: "RENAME", Filename: src, NewPath: dst}); err != nil {
+		s.respondApplyError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// removes a file or a directory -> /delete?path=/logs&recursive=true
+func (s *ApiServer) handleDelete(c *gin.Context) {
+	if s.raft.State() != raft.Leader {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "not the leader"})
+		return
+	}
+
+	target := c.Query("path")
+	if target == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing 'path' query parameter"})
+		return
+	}
+
+	cmd := RaftCommand{Operation: "DELETE", Filename: target, Recursive: c.Query("recursive") == "true"}
+	if err := s.applyCommand(cmd); err != nil {
+		s.respondApplyError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// lists a directory -> /ls?path=/logs (no path means "/")
+func (s *ApiServer) handleListDir(c *gin.Context) {
+	if !s.checkRead(c) {
+		return
+	}
+
+	entries, err := s.fsm.ListDir(c.DefaultQuery("path", "/"))
+	if err != nil {
+		s.respondApplyError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"entries": entries})
+}
+
+// lists files by path prefix, a page at a time -> /files?prefix=/logs/&limit=100&after=/logs/b.txt
+// the answer has a "next" value, pass it as "after" to get the next page (it is empty on the last page)
+func (s *ApiServer) handleListFiles(c *gin.Context) {
+	if !s.checkRead(c) {
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit < 1 || limit > 1000 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "'limit' must be between 1 and 1000"})
+		return
+	}
+
+	files, next := s.fsm.ListFiles(c.Query("prefix"), c.Query("after"), limit)
+	c.JSON(http.StatusOK, gin.H{"files": files, "next": next})
+}
+
+// size, chunk count, replica counts and times of a file -> /stat?filename=/logs/a.txt
+func (s *ApiServer) handleStat(c *gin.Context) {
+	if !s.checkRead(c) {
+		return
+	}
+
+	fileName := c.Query("filename")
+	if fileName == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing 'filename' query parameter"})
+		return
+	}
+
+	stat, err := s.fsm.Stat(fileName)
+	if err != nil {
+		s.respondApplyError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, stat)
+}
+
+// changes how many replicas a file keeps -> /set-replication?filename=/a.txt&replication=2 or &policy=hot-3x
+func (s *ApiServer) handleSetReplication(c *gin.Context) {
+	if s.raft.State() != raft.Leader {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "not the leader"})
+		return
+	}
+
+	fileName, policy := c.Query("filename"), c.Query("policy")
+	if fileName == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing 'filename' query parameter"})
+		return
+	}
+	replication := 0
+	if policy == "" {
+		var err error
+		if replication, err = strconv.Atoi(c.Query("replication")); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "missing 'replication' or 'policy' query parameter"})
+			return
+		}
+	}
+
+	cmd := RaftCommand{Operation: "SET_REPLICATION", Filename: fileName, Replication: replication, Policy: policy}
+	if err := s.applyCommand(cmd); err != nil {
+		s.respondApplyError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// applies one of the upload session commands, body -> {"session_id": "...", "chunks": [...]}
+func (s *ApiServer) handleUploadCommand(operation string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if s.raft.State() != raft.Leader {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "not the leader"})
+			return
+		}
+
+		var req shared.UploadSessionRequest
+		if err := c.ShouldBindJSON(&req); err != nil || req.SessionID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "missing 'session_id'"})
+			return
+		}
+
+		if err := s.applyCommand(s.uploadCommand(operation, req)); err != nil {
+			s.respondApplyError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"success": true})
+	}
+}
+
+// the raft command for an upload session request
+// the body can ask a commit for fewer replicas than our floor, not that we commit with them
+func (s *ApiServer) uploadCommand(operation string, req shared.UploadSessionRequest) RaftCommand {
+	cmd := RaftCommand{Operation: operation, SessionID: req.SessionID, Chunks: req.Chunks, MinReplicas: req.MinReplicas}
+	if operation == "COMMIT_UPLOAD" && cmd.MinReplicas < s.minReplicas {
+		cmd.MinReplicas = s.minReplicas
+	}
+	return cmd
+}
+
+// an open upload session and which of its chunks are acknowledged -> /upload/status?session_id=abc
+func (s *ApiServer) handleUploadStatus(c *gin.Context) {
+	if s.raft.State() != raft.Leader {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "not the leader"})
+		return
+	}
+
+	session, err := s.fsm.GetUploadSession(c.Query("session_id"))
+	if err != nil {
+		s.respondApplyError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, session)
+}
+
+// every open upload session, oldest first -> the LB uses it to expire the ones nobody finished
+func (s *ApiServer) handleListUploadSessions(c *gin.Context) {
+	if s.raft.State() != raft.Leader {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "not the leader"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"sessions": s.fsm.ListUploadSessions()})
 }
@@ -0,0 +1,46 @@
+package namenode
+
+import (
+	"fmt"
+
+	"github.com/Rahul6700/Foodo/shared"
+)
+
+// files remember how the client cut them into chunks (fixed size or content defined, see shared.Chunking)
+// we dont need it for anything ourselves, chunk sizes are recorded per chunk, it is only handed back in /stat and /get-metadata
+// same for compression: the client compresses every chunk on its own, we record the codec and size before compression
+// per chunk so it can undo it (and find offsets in the file for range reads) without fetching the chunks
+
+type Chunking = shared.Chunking
+
+// checks the chunking an upload says it used, nil is fine (older clients, always fixed size)
+func checkChunking(chunking *Chunking) error {
+	if chunking == nil {
+		return nil
+	}
+	switch chunking.Algorithm {
+	case shared.ChunkerFixed, shared.ChunkerFastCDC:
+	default:
+		return fmt.Errorf("unknown chunker %q", chunking.Algorithm)
+	}
+	if chunking.MinSize < 1 || chunking.MinSize > chunking.AvgSize || chunking.AvgSize > chunking.MaxSize {
+		return fmt.Errorf("bad chunk sizes %d/%d/%d, want 0 < min <= avg <= max", chunking.MinSize, chunking.AvgSize, chunking.MaxSize)
+	}
+	return nil
+}
+
+// checks the codecs of an upload's chunks, a compressed chunk needs its size before compression
+func checkCodecs(chunks []ChunkStruct) error {
+	for _, chunk := range chunks {
+		switch chunk.Codec {
+		case "":
+		case shared.CodecGzip:
+			if chunk.RawSize < 1 {
+				return fmt.Errorf("chunk %s is compressed but has no raw size", chunk.ChunkID)
+			}
+		default:
+			return fmt.Errorf("chunk %s has unknown codec %q", chunk.ChunkID, chunk.Codec)
+		}
+	}
+	return nil
+}
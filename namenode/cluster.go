@@ -0,0 +1,195 @@
+package namenode
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hashicorp/raft"
+)
+
+// raft membership -> which namenodes are in the cluster
+// the first node is bootstrapped with a peer list (-bootstrap -peers), after that namenodes are added and removed at runtime:
+// a new one starts with -join and asks a member to add it (/cluster/join), /cluster/remove takes one out.
+// membership changes go through the leader and are replicated like any other log entry, so only the leader handles them
+
+// JoinRequest is what a namenode sends to /cluster/join to be added to the cluster
+type JoinRequest struct {
+	ID       string `json:"id"`                 // its -id -> "nn-4"
+	RaftAddr string `json:"raft_addr"`          // its -raft-addr -> "localhost:7004"
+	APIAddr  string `json:"api_addr,omitempty"` // where its API is reachable, so it can forward to us and we to it once it leads
+	Nonvoter bool   `json:"nonvoter,omitempty"` // only replicate the log to it, it doesn't vote or count towards the quorum
+}
+
+// Member is one namenode of the raft configuration, as /cluster/members lists it
+type Member struct {
+	ID       string `json:"id"`
+	RaftAddr string `json:"raft_addr"`
+	APIAddr  string `json:"api_addr,omitempty"` // "" until it registered one, see forward.go
+	Voter    bool   `json:"voter"`
+	Leader   bool   `json:"leader"`
+}
+
+// how long the leader waits for a membership change to be committed
+const membershipTimeout = 10 * time.Second
+
+// adds a namenode to the cluster -> POST /cluster/join {"id": "nn-4", "raft_addr": "localhost:7004"}
+// joining again with the same address is fine, a known id with a new address moves that namenode
+func (s *ApiServer) handleClusterJoin(c *gin.Context) {
+	if s.raft.State() != raft.Leader {
+		s.respondNotLeader(c)
+		return
+	}
+	var req JoinRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.ID == "" || req.RaftAddr == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "id and raft_addr are required"})
+		return
+	}
+
+	id, addr := raft.ServerID(req.ID), raft.ServerAddress(req.RaftAddr)
+	future := s.raft.GetConfiguration()
+	if err := future.Error(); err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+	for _, server := range future.Configuration().Servers {
+		switch {
+		case server.ID == id && server.Address == addr && (server.Suffrage == raft.Voter) != req.Nonvoter:
+			c.JSON(http.StatusOK, gin.H{"success": true, "message": req.ID + " is already a member"})
+			return
+		case server.ID != id && server.Address == addr:
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("%s is already used by %s", req.RaftAddr, server.ID)})
+			return
+		}
+	}
+
+	var change raft.IndexFuture
+	if req.Nonvoter {
+		change = s.raft.AddNonvoter(id, addr, 0, membershipTimeout)
+	} else {
+		change = s.raft.AddVoter(id, addr, 0, membershipTimeout)
+	}
+	if err := change.Error(); err != nil {
+		log.Printf("failed to add %s (%s) to the cluster: %s", req.ID, req.RaftAddr, err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+	log.Printf("added %s (%s) to the cluster, voter: %t", req.ID, req.RaftAddr, !req.Nonvoter)
+	if req.APIAddr != "" {
+		cmd := RaftCommand{Operation: "REGISTER_PEER", NodeID: req.ID, RaftAddr: req.RaftAddr, APIAddr: req.APIAddr}
+		if err := s.applyCommand(cmd); err != nil {
+			// it is a member already, it only can't be forwarded to until it registers itself as leader
+			log.Printf("failed to register the API address of %s: %s", req.ID, err)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// takes a namenode out of the cluster -> POST /cluster/remove?id=nn-3
+// removing the leader itself is allowed, it steps down once the change is committed and the others elect a new one
+func (s *ApiServer) handleClusterRemove(c *gin.Context) {
+	if s.raft.State() != raft.Leader {
+		s.respondNotLeader(c)
+		return
+	}
+	id := c.Query("id")
+	if id == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "id is required"})
+		return
+	}
+
+	future := s.raft.GetConfiguration()
+	if err := future.Error(); err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+	found := false
+	for _, server := range future.Configuration().Servers {
+		found = found || server.ID == raft.ServerID(id)
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": id + " is not a member"})
+		return
+	}
+
+	if err := s.raft.RemoveServer(raft.ServerID(id), 0, membershipTimeout).Error(); err != nil {
+		log.Printf("failed to remove %s from the cluster: %s", id, err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+	log.Printf("removed %s from the cluster", id)
+	if err := s.applyCommand(RaftCommand{Operation: "REMOVE_PEER", NodeID: id}); err != nil {
+		log.Printf("failed to forget the API address of %s: %s", id, err)
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// lists the namenodes of the cluster as this node knows them -> GET /cluster/members
+func (s *ApiServer) handleClusterMembers(c *gin.Context) {
+	future := s.raft.GetConfiguration()
+	if err := future.Error(); err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+	_, leaderID := s.raft.LeaderWithID()
+	members := []Member{}
+	for _, server := range future.Configuration().Servers {
+		members = append(members, Member{
+			ID:       string(server.ID),
+			RaftAddr: string(server.Address),
+			APIAddr:  s.fsm.PeerAPIAddr(string(server.ID)),
+			Voter:    server.Suffrage == raft.Voter,
+			Leader:   server.ID == leaderID,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"members": members})
+}
+
+// for requests that only work on the leader, the caller gets whatever we know about the leader to find it with
+func (s *ApiServer) respondNotLeader(c *gin.Context) {
+	leaderAddr, leaderID := s.raft.LeaderWithID()
+	c.JSON(http.StatusServiceUnavailable, gin.H{"error": "not the leader", "leader": s.leaderAPIAddr(), "leader_id": leaderID, "leader_raft_addr": leaderAddr})
+}
+
+var joinClient = &http.Client{Timeout: membershipTimeout + 5*time.Second}
+
+// Join asks the members at apiAddrs ("http://localhost:8001") to add this namenode, followers forward it to the leader
+// it keeps going round the list until timeout, the cluster may be in the middle of an election
+func Join(apiAddrs []string, req JoinRequest, timeout time.Duration) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(timeout)
+	lastErr := fmt.Errorf("no namenodes to join")
+	for {
+		for _, addr := range apiAddrs {
+			resp, err := joinClient.Post(addr+"/cluster/join", "application/json", bytes.NewReader(body))
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			var answer struct {
+				Error string `json:"error"`
+			}
+			json.NewDecoder(resp.Body).Decode(&answer)
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				log.Printf("joined the cluster through %s", addr)
+				return nil
+			}
+			lastErr = fmt.Errorf("%s: %d %s", addr, resp.StatusCode, answer.Error)
+			if resp.StatusCode != http.StatusServiceUnavailable {
+				return lastErr // refused, asking again won't help
+			}
+		}
+		if time.Now().After(deadline) {
+			return lastErr
+		}
+		time.Sleep(time.Second)
+	}
+}
@@ -0,0 +1,86 @@
+package namenode
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Rahul6700/Foodo/shared"
+	"github.com/gin-gonic/gin"
+	"github.com/hashicorp/raft"
+)
+
+// metadata reads (/get-metadata, /stat, /ls, /files) take ?consistency= to pick how fresh the answer has to be:
+//   - linearizable -> the leader first makes sure it still is one (VerifyLeader, a round trip to a quorum) and that
+//     everything committed before the read is applied (Barrier), the answer is never older than the read
+//   - lease (default) -> the leader answers straight from its FSM while it holds a read lease: a quorum confirmed it as
+//     leader (VerifyLeader) less than LeaderLeaseTimeout ago, in the current term. the followers wont elect anyone else
+//     before that runs out, so nobody can have committed a write we haven't seen. without a lease the read is done
+//     linearizable, which takes a new one
+//   - stale -> any namenode answers from its own FSM, followers dont forward the read
+//
+// every answer carries the raft index the FSM had applied when the read started (shared.AppliedIndexHeader),
+// a caller can compare it with an earlier answer or write, and follower answers also say how long ago the node heard
+// from the leader (shared.LastContactHeader), so callers can decide whether a stale read is fresh enough
+
+// how long a linearizable read waits for the FSM to catch up with the log
+const barrierTimeout = 5 * time.Second
+
+// a leader's read lease, taken (and extended) by every linearizable read
+type readLease struct {
+	lock   sync.Mutex
+	term   uint64    // the term it was taken in, it is worthless in any other
+	expiry time.Time // LeaderLeaseTimeout after the VerifyLeader that took it started
+}
+
+// makes sure we can answer the read in c with the consistency it asks for, and sets the applied index header
+// it answers the request itself (and returns false) if we can't
+func (s *ApiServer) checkRead(c *gin.Context) bool {
+	switch c.DefaultQuery("consistency", shared.ConsistencyLease) {
+	case shared.ConsistencyStale:
+		if s.raft.State() != raft.Leader {
+			c.Header(shared.LastContactHeader, strconv.FormatInt(time.Since(s.raft.LastContact()).Milliseconds(), 10))
+		}
+	case shared.ConsistencyLease, shared.ConsistencyLinearizable:
+		if c.DefaultQuery("consistency", shared.ConsistencyLease) == shared.ConsistencyLease && s.holdsLease() {
+			break
+		}
+		term, start := s.raft.CurrentTerm(), time.Now()
+		if err := s.raft.VerifyLeader().Error(); err != nil {
+			s.respondNotLeader(c)
+			return false
+		}
+		if err := s.raft.Barrier(barrierTimeout).Error(); err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "failed to catch up with the log: " + err.Error()})
+			return false
+		}
+		s.renewLease(term, start)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "consistency must be linearizable, lease or stale"})
+		return false
+	}
+	c.Header(shared.AppliedIndexHeader, strconv.FormatUint(s.raft.AppliedIndex(), 10))
+	return true
+}
+
+// whether we are the leader and hold an unexpired read lease of the current term
+func (s *ApiServer) holdsLease() bool {
+	if s.raft.State() != raft.Leader {
+		return false
+	}
+	s.lease.lock.Lock()
+	defer s.lease.lock.Unlock()
+	return s.lease.term == s.raft.CurrentTerm() && time.Now().Before(s.lease.expiry)
+}
+
+// a quorum confirmed us as leader of term after start, and the FSM has caught up with the log
+// the lease counts from start, the followers may have heard from us any time after that
+func (s *ApiServer) renewLease(term uint64, start time.Time) {
+	s.lease.lock.Lock()
+	defer s.lease.lock.Unlock()
+	expiry := start.Add(s.leaseTimeout)
+	if term != s.lease.term || expiry.After(s.lease.expiry) {
+		s.lease.term, s.lease.expiry = term, expiry
+	}
+}
@@ -0,0 +1,28 @@
+package namenode
+
+import (
+	"fmt"
+
+	"github.com/Rahul6700/Foodo/shared"
+)
+
+// encrypted files -> the client seals every chunk before uploading it, we only keep what it needs to open them again
+// (the data key wrapped with its master key, the nonce base and the master key's fingerprint, see shared.Encryption)
+// and hand it back with the file's metadata. the chunks are normal chunks to everybody else
+
+type Encryption = shared.Encryption
+
+// checks an upload's encryption record, so a broken one is refused before any chunk is sent instead of on download
+func checkEncryption(encryption *Encryption) error {
+	if encryption == nil {
+		return nil
+	}
+	if encryption.Cipher != shared.CipherAES256GCM {
+		return fmt.Errorf("unknown cipher %q", encryption.Cipher)
+	}
+	// a 12 byte nonce for every AES-GCM seal, the wrapped key is its nonce, a 32 byte key and the 16 byte tag
+	if len(encryption.Nonce) != 12 || len(encryption.WrappedKey) != 12+32+16 || encryption.KeyID == "" {
+		return fmt.Errorf("bad encryption record, want a 12 byte nonce, a 60 byte wrapped key and a key id")
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package namenode
+
+import (
+	"fmt"
+	"github.com/Rahul6700/Foodo/shared"
+)
+
+// erasure coded files -> instead of every chunk being replicated, the file's chunks are grouped into stripes
+// and every stripe gets parity chunks, each shard (data or parity chunk) is stored once on its own DN
+// we only keep the layout and the parity chunk ids, the client does the encoding and rebuilds lost shards while downloading
+// shards are normal chunks as far as ref counting, dedup and the GC go, the replication manager just leaves them
+// at a single copy (see ReplicationTargets)
+
+type ErasureCoding = shared.ErasureCoding
+type StripeStruct = shared.StripeStruct
+
+// the most shards a stripe can have, GF(2^8) only has that many distinct rows
+const maxStripeShards = 256
+
+// checks that the stripes the LB sent match the layout and the number of chunks of the file
+func checkErasure(erasure *ErasureCoding, chunkCount int, stripes []StripeStruct) error {
+	if erasure.DataShards < 1 || erasure.ParityShards < 1 || erasure.DataShards+erasure.ParityShards > maxStripeShards {
+		return fmt.Errorf("bad erasure coding %d+%d", erasure.DataShards, erasure.ParityShards)
+	}
+	expected := (chunkCount + erasure.DataShards - 1) / erasure.DataShards
+	if len(stripes) != expected {
+		return fmt.Errorf("%d chunks in stripes of %d need %d stripes, got %d", chunkCount, erasure.DataShards, expected, len(stripes))
+	}
+	for i, stripe := range stripes {
+		if stripe.Index != i || len(stripe.Parity) != erasure.ParityShards {
+			return fmt.Errorf("stripe %d should be stripe %d with %d parity chunks", stripe.Index, i, erasure.ParityShards)
+		}
+	}
+	return nil
+}
+
+// the chunk ids of every parity chunk of the stripes, in order
+func parityChunkIDs(stripes []StripeStruct) []string {
+	var chunkIDs []string
+	for _, stripe := range stripes {
+		for _, parity := range stripe.Parity {
+			chunkIDs = append(chunkIDs, parity.ChunkID)
+		}
+	}
+	return chunkIDs
+}
+
+// releases everything a file holds on to, its chunks and (erasure coded) its parity chunks
+// has to be called with the lock held, after the file has been removed/replaced
+func (the_fsm *FSM) releaseFile(chunkIDs []string, info *FileInfo) {
+	the_fsm.releaseChunks(chunkIDs)
+	if info == nil {
+		return
+	}
+	for _, parity := range info.Stripes {
+		the_fsm.releaseChunks(parity)
+	}
+}
+
+// the stripes of an erasure coded file with the current locations and sizes of its parity chunks
+// has to be called with the lock held
+func (f *FSM) fileStripes(info *FileInfo) []StripeStruct {
+	stripes := make([]StripeStruct, len(info.Stripes))
+	for i, parity := range info.Stripes {
+		stripes[i] = StripeStruct{Index: i}
+		for p, chunkID := range parity {
+			chunk := ChunkStruct{ChunkID: chunkID, ChunkIndex: p, Locations: f.chunkIDToDataNodesMap[chunkID]}
+			if chunkInfo, ok := f.chunkInfoMap[chunkID]; ok {
+				chunk.Size = chunkInfo.Size
+			}
+			stripes[i].Parity = append(stripes[i].Parity, chunk)
+		}
+	}
+	return stripes
+}
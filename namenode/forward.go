@@ -0,0 +1,115 @@
+package namenode
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"time"
+
+	"github.com/Rahul6700/Foodo/shared"
+	"github.com/gin-gonic/gin"
+	"github.com/hashicorp/raft"
+)
+
+// followers forward -> everything but /status and /cluster/members has to run on the leader, a follower that gets
+// such a request proxies it to the leader's API, so the LB and clients can talk to any namenode.
+// raft only knows the leader's raft address, so every namenode's API address is in the FSM too (REGISTER_PEER):
+// a node that becomes leader registers its own, and joining nodes are registered by the leader that adds them
+
+// Peer is what the FSM knows about a namenode, its raft address and the address its API is reachable at
+type Peer struct {
+	RaftAddr string `json:"raft_addr"`
+	APIAddr  string `json:"api_addr"` // -> "http://localhost:8001"
+}
+
+// a request forwarded by a follower carries this header (set to the follower's API address), the node it lands on
+// never forwards it again, so a stale idea of who the leader is can't bounce a request around
+const forwardedHeader = "X-Foodo-Forwarded-By"
+
+// records the API address of a namenode
+func (the_fsm *FSM) applyRegisterPeer(cmd RaftCommand) interface{} {
+	if cmd.NodeID == "" || cmd.APIAddr == "" {
+		return fmt.Errorf("node_id and api_addr are required")
+	}
+	the_fsm.peers[cmd.NodeID] = &Peer{RaftAddr: cmd.RaftAddr, APIAddr: cmd.APIAddr}
+	return nil
+}
+
+// forgets a namenode that was removed from the cluster
+func (the_fsm *FSM) applyRemovePeer(cmd RaftCommand) interface{} {
+	delete(the_fsm.peers, cmd.NodeID)
+	return nil
+}
+
+// returns the API address of the namenode id, "" if it never registered one
+func (f *FSM) PeerAPIAddr(id string) string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if peer, ok := f.peers[id]; ok {
+		return peer.APIAddr
+	}
+	return ""
+}
+
+// the API address of the current leader, "" if there is no leader or it hasn't registered one yet
+func (s *ApiServer) leaderAPIAddr() string {
+	_, leaderID := s.raft.LeaderWithID()
+	if leaderID == "" {
+		return ""
+	}
+	return s.fsm.PeerAPIAddr(string(leaderID))
+}
+
+// middleware for the leader only routes, on a follower the request is proxied to the leader and the handler never runs
+// if the leader isn't known (election going on, or it hasn't registered its API yet) the caller gets a 503, with the
+// leader's address if we have it. stale reads are the exception, any node answers those itself (consistency.go)
+func (s *ApiServer) forwardToLeader(c *gin.Context) {
+	if s.raft.State() == raft.Leader || c.Query("consistency") == shared.ConsistencyStale {
+		c.Next()
+		return
+	}
+	leader := s.leaderAPIAddr()
+	if leader == "" || leader == s.apiAddr || c.GetHeader(forwardedHeader) != "" {
+		s.respondNotLeader(c)
+		c.Abort()
+		return
+	}
+	target, err := url.Parse(leader)
+	if err != nil {
+		s.respondNotLeader(c)
+		c.Abort()
+		return
+	}
+
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		log.Printf("failed to forward %s %s to the leader at %s: %s", r.Method, r.URL.Path, leader, err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "failed to reach the leader", "leader": leader})
+	}
+	c.Request.Header.Set(forwardedHeader, s.apiAddr)
+	proxy.ServeHTTP(c.Writer, c.Request)
+	c.Abort()
+}
+
+// makes sure the FSM has our API address whenever we are the leader, so followers can forward to us
+// it checks every interval instead of reacting to elections, a registration that failed is retried that way too
+func (s *ApiServer) StartLeaderRegistration(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	for range ticker.C {
+		if s.raft.State() != raft.Leader {
+			continue
+		}
+		raftAddr, id := s.raft.LeaderWithID()
+		if id == "" || s.fsm.PeerAPIAddr(string(id)) == s.apiAddr {
+			continue
+		}
+		cmd := RaftCommand{Operation: "REGISTER_PEER", NodeID: string(id), RaftAddr: string(raftAddr), APIAddr: s.apiAddr}
+		if err := s.applyCommand(cmd); err != nil {
+			log.Printf("failed to register our API address %s: %s", s.apiAddr, err)
+			continue
+		}
+		log.Printf("registered %s as the API address of %s", s.apiAddr, id)
+	}
+}
@@ -2,9 +2,12 @@ package namenode
 
 import (
 	"encoding/json"
+	"errors"
 	"io"
-	"log"
-	//"github.com/Rahul6700/Foodo/shared"
+	"path"
+	"slices"
+	"time"
+	"github.com/Rahul6700/Foodo/shared"
 	"github.com/hashicorp/raft"
 	"fmt"
 	"sync"
@@ -14,29 +17,60 @@ import (
 type fsm_snapshot struct {
 		Files  map[string][]string
 		Chunks map[string][]string
+		Garbage map[string][]string
+		Deleting map[string][]string
+		ChunkInfo map[string]*ChunkInfo
+		Dirs map[string]bool
+		FileInfo map[string]*FileInfo
+		Uploads map[string]*UploadSession
+		Peers map[string]*Peer
 	}
 
-type RaftCommand struct {
-	Operation string        `json:"operation"`
-	Filename  string        `json:"filename"`  
-	Chunks    []ChunkStruct `json:"chunks"`
-}
+// the LB marshals shared.RaftCommand, so we decode into the exact same structs
+type RaftCommand = shared.RaftCommand
+type ChunkStruct = shared.ChunkStruct
 
-type ChunkStruct struct {
-	ChunkID    string   `json:"chunk_id"`
-	ChunkIndex int      `json:"chunk_index"`
-	Locations  []string `json:"locations"`
+// what the FSM knows about a single stored chunk, apart from its locations
+type ChunkInfo struct {
+	RefCount int    `json:"ref_count"`          // how many times files point at this chunk, the chunk is garbage once this hits 0
+	Size     int64  `json:"size"`               // size of the chunk in bytes
+	Codec    string `json:"codec,omitempty"`    // compressed chunks -> how, see shared.CodecGzip
+	RawSize  int64  `json:"raw_size,omitempty"` // compressed chunks -> their size before compression
 }
 
-type HeartbeatPayload struct {
-	NodeID       string `json:"node_id"`
-	ActiveWrites int    `json:"active_writes"`
+// what the FSM knows about a file, apart from its chunks
+// the times come from the raft command (the proposer's clock), never from the node applying it, so every namenode ends up with the same values
+type FileInfo struct {
+	Size        int64          `json:"size"` // total size of the file in bytes
+	CreatedAt   time.Time      `json:"created_at"`
+	ModifiedAt  time.Time      `json:"modified_at"`
+	Erasure     *ErasureCoding `json:"erasure,omitempty"`     // nil -> the chunks are replicated
+	Stripes     [][]string     `json:"stripes,omitempty"`     // erasure coded -> the parity chunk ids of every stripe
+	Replication int            `json:"replication,omitempty"` // replicas every chunk wants, 0 -> the LB's -replication (see replication.go)
+	Policy      string         `json:"policy,omitempty"`      // the storage policy the file was stored or set with, "" if none
+	Chunking    *Chunking      `json:"chunking,omitempty"`    // how the client cut it into chunks, nil for files from before we recorded it
+	Encryption  *Encryption    `json:"encryption,omitempty"`  // nil -> the chunks are plaintext
 }
 
 type FSM struct {
 	lock                sync.Mutex // Your lock
 	fileToChunksMap     map[string][]string
 	chunkIDToDataNodesMap map[string][]string
+	// chunks that no file references anymore -> the DN's that still have them on disk
+	// the LB's garbage collector deletes them from the DN's and then sends a PURGE_CHUNKS to drop them from here
+	garbageChunks map[string][]string
+	// garbage chunks the LB is deleting from the DN's right now (MARK_DELETING), they can't be used again until PURGE_CHUNKS
+	deletingChunks map[string][]string
+	// chunkID -> ref count and size, every chunk in chunkIDToDataNodesMap has an entry here
+	chunkInfoMap map[string]*ChunkInfo
+	// every directory in the namespace, as a full path ("/logs/app"), see namespace.go
+	directories map[string]bool
+	// file path -> size and timestamps, every file in fileToChunksMap has an entry here
+	fileInfoMap map[string]*FileInfo
+	// session id -> upload that is not committed yet, see uploads.go
+	uploads map[string]*UploadSession
+	// namenode id -> its raft and API address, see forward.go
+	peers map[string]*Peer
 }
 
 type fsmSnapshot struct {
@@ -51,6 +85,13 @@ func NewFsm() *FSM {
 	return &FSM {
 			fileToChunksMap: make(map[string][]string),
 			chunkIDToDataNodesMap: make(map[string][]string),
+			garbageChunks: make(map[string][]string),
+			deletingChunks: make(map[string][]string),
+			chunkInfoMap: make(map[string]*ChunkInfo),
+			directories: make(map[string]bool),
+			fileInfoMap: make(map[string]*FileInfo),
+			uploads: make(map[string]*UploadSession),
+			peers: make(map[string]*Peer),
 	}
 }
 
@@ -61,30 +102,228 @@ func (the_fsm *FSM) Apply (raftLog *raft.Log) interface{} {
 	the_fsm.lock.Lock()
 	defer the_fsm.lock.Unlock()
 
-	 log.Printf("Raw raft log data: %s\n", string(raftLog.Data))
-
 	var cmd RaftCommand // it has cmd.Filename
-	log.Printf("0. apply func is applying to cmd.Filename as %s\n", cmd.Filename)
 	if err := json.Unmarshal(raftLog.Data, &cmd); err != nil {
 		return fmt.Errorf("could not unmarshal command: %s", err)
 	}
-	log.Printf("1. apply func is applying to cmd.Filename as %s\n", cmd.Filename)
-	if cmd.Operation != "REGISTER_FILE"{
+	switch cmd.Operation {
+	case "REGISTER_FILE":
+		return the_fsm.applyRegisterFile(cmd)
+	case "DELETE_FILE", "DELETE":
+		return the_fsm.applyDelete(cmd)
+	case "MKDIR":
+		return the_fsm.applyMkdir(cmd)
+	case "RENAME":
+		return the_fsm.applyRename(cmd)
+	case "UPDATE_LOCATIONS":
+		return the_fsm.applyUpdateLocations(cmd)
+	case "MARK_DELETING":
+		return the_fsm.applyMarkDeleting(cmd)
+	case "PURGE_CHUNKS":
+		return the_fsm.applyPurgeChunks(cmd)
+	case "BEGIN_UPLOAD":
+		return the_fsm.applyBeginUpload(cmd)
+	case "ACK_CHUNKS":
+		return the_fsm.applyAckChunks(cmd)
+	case "COMMIT_UPLOAD":
+		return the_fsm.applyCommitUpload(cmd)
+	case "ABORT_UPLOAD", "EXPIRE_UPLOAD":
+		return the_fsm.applyAbortUpload(cmd)
+	case "SET_REPLICATION":
+		return the_fsm.applySetReplication(cmd)
+	case "REGISTER_PEER":
+		return the_fsm.applyRegisterPeer(cmd)
+	case "REMOVE_PEER":
+		return the_fsm.applyRemovePeer(cmd)
+	default:
 		return fmt.Errorf("unknown operation %s", cmd.Operation)
-	} else {
-		var chunkIDSlice []string
+	}
+}
+
+// adds a file and the locations of its chunks to the maps, missing parent directories are created
+// chunks that are already stored (dedup) just get their ref count bumped, and their location sets are merged
+// if a file with the same name already exists it is replaced, and its old chunks are released
+// the LB used to send this straight away, now uploads go through a session (uploads.go), it stays for the existing raft logs
+func (the_fsm *FSM) applyRegisterFile(cmd RaftCommand) interface{} {
+	cmd.Filename = normalizePath(cmd.Filename)
+	replication, err := checkPolicy(nil, cmd.Replication, cmd.Policy)
+	if err != nil {
+		return err
+	}
+	if err := the_fsm.checkFilePath(cmd.Filename); err != nil {
+		return err
+	}
+	if err := the_fsm.checkNotDeleting(cmd.Chunks); err != nil {
+		return err
+	}
+
+	var chunkIDSlice []string
+	for _, chunk := range cmd.Chunks {
+		chunkIDSlice = append(chunkIDSlice, chunk.ChunkID) // basically all the chunks of the file come in this slice
+		// this add's data to the fsm's map
+		// so what is added is -> chunkIDToDataNodesMap[chunkID 13434] = [DataNode3, Datanode5, DateNode6]
+		the_fsm.chunkIDToDataNodesMap[chunk.ChunkID] = mergeLocations(the_fsm.chunkIDToDataNodesMap[chunk.ChunkID], chunk.Locations)
+		the_fsm.retainChunk(chunk)
+	}
+
+	// the size is whatever the proposer sent, older proposers didnt send one so we add up the chunks instead
+	size := cmd.Size
+	if size == 0 {
 		for _, chunk := range cmd.Chunks {
-			chunkIDSlice = append(chunkIDSlice, chunk.ChunkID) // basically all the chunks of the file come in this slice
-			the_fsm.chunkIDToDataNodesMap[chunk.ChunkID] = chunk.Locations // this add's data to the fsm's map
-			// so what is added is -> fileToChunksMap[chunkID 13434] = [DataNode3, Datanode5, DateNode6]
+			size += chunk.Size
 		}
-		log.Printf("2. apply func is applying to cmd.Filename as %s\n", cmd.Filename)
-		the_fsm.fileToChunksMap[cmd.Filename] = chunkIDSlice // here we add the file to chunk ID's mapping to the fsm
-		// like fileToChunksMap["hello.txt"] = [1312412,3463563463,3453453,23423423] -> id's of the different chunks
 	}
+	info := the_fsm.installFile(cmd.Filename, chunkIDSlice, size, time.Unix(0, cmd.Timestamp).UTC())
+	info.Replication, info.Policy = replication, cmd.Policy
 	return nil // returning nil if the function runs successfully
 }
 
+// makes sure a file can be put at name (it isnt a directory) and creates its missing parent directories
+// has to be called with the lock held
+func (the_fsm *FSM) checkFilePath(name string) error {
+	if the_fsm.isDir(name) {
+		return fmt.Errorf("%s is a directory: %w", name, ErrExists)
+	}
+	return the_fsm.makeDirs(path.Dir(name))
+}
+
+// points name at chunkIDs, whose references the caller already holds
+// if a file with the same name already exists it is replaced, and its old chunks are released
+// it returns the file's new info, for the caller to fill in the rest
+// has to be called with the lock held, after checkFilePath
+func (the_fsm *FSM) installFile(name string, chunkIDs []string, size int64, stamp time.Time) *FileInfo {
+	oldChunks, exists := the_fsm.fileToChunksMap[name]
+	oldInfo := the_fsm.fileInfoMap[name]
+	the_fsm.fileToChunksMap[name] = chunkIDs // here we add the file to chunk ID's mapping to the fsm
+	// like fileToChunksMap["hello.txt"] = [1312412,3463563463,3453453,23423423] -> id's of the different chunks
+
+	info := &FileInfo{Size: size, CreatedAt: stamp, ModifiedAt: stamp}
+	if old, ok := the_fsm.fileInfoMap[name]; ok && exists {
+		info.CreatedAt = old.CreatedAt // overwriting a file keeps its creation time
+	}
+	the_fsm.fileInfoMap[name] = info
+
+	if exists {
+		the_fsm.releaseFile(oldChunks, oldInfo)
+	}
+	return info
+}
+
+// returns the union of both location lists, keeping the order of the existing one
+func mergeLocations(existing, added []string) []string {
+	merged := append([]string(nil), existing...)
+	for _, location := range added {
+		found := false
+		for _, have := range merged {
+			if have == location {
+				found = true
+				break
+			}
+		}
+		if !found {
+			merged = append(merged, location)
+		}
+	}
+	return merged
+}
+
+// adds and removes replicas of every chunk in the command, the LB sends this after re-replicating chunks off dead DN's
+// or dropping bad replicas. only the changes are sent, so an ack (or another update) that landed after the LB read the
+// locations isn't overwritten. logs from before that have no Added/Removed and replace the whole set with Locations
+// chunks that were deleted in the meantime are skipped, we dont want to bring them back
+func (the_fsm *FSM) applyUpdateLocations(cmd RaftCommand) interface{} {
+	for _, chunk := range cmd.Chunks {
+		locations, ok := the_fsm.chunkIDToDataNodesMap[chunk.ChunkID]
+		if !ok {
+			continue
+		}
+		if len(chunk.Added) == 0 && len(chunk.Removed) == 0 {
+			if len(chunk.Locations) > 0 { // an entry with nothing in it changes nothing, it doesnt wipe the set
+				the_fsm.chunkIDToDataNodesMap[chunk.ChunkID] = chunk.Locations
+			}
+			continue
+		}
+		updated := removeLocations(mergeLocations(locations, chunk.Added), chunk.Removed)
+		if len(updated) == 0 {
+			// two drops racing each other can both think theirs isnt the last replica, keep it
+			continue
+		}
+		the_fsm.chunkIDToDataNodesMap[chunk.ChunkID] = updated
+	}
+	return nil
+}
+
+// the locations in existing that are not in removed
+func removeLocations(existing, removed []string) []string {
+	var kept []string
+	for _, location := range existing {
+		if !slices.Contains(removed, location) {
+			kept = append(kept, location)
+		}
+	}
+	return kept
+}
+
+// returned (wrapped) when a file or upload wants a chunk the garbage collector is deleting from the DN's
+// the chunk can't be deduped or re-uploaded until the delete is done, the caller has to try again a bit later
+var ErrChunkDeleting = errors.New("chunk is being deleted")
+
+// the garbage collector is about to delete these chunks from the DN's
+// only chunks that are still garbage move to deletingChunks, one that a file or upload took back in the meantime is left alone
+// from here on retainChunk refuses them, so nothing can start using the chunk while its replicas are being deleted
+func (the_fsm *FSM) applyMarkDeleting(cmd RaftCommand) interface{} {
+	for _, chunk := range cmd.Chunks {
+		locations, ok := the_fsm.garbageChunks[chunk.ChunkID]
+		if !ok {
+			continue
+		}
+		the_fsm.deletingChunks[chunk.ChunkID] = locations
+		delete(the_fsm.garbageChunks, chunk.ChunkID)
+	}
+	return nil
+}
+
+// the garbage collector has deleted these chunks from the DN's, so we can forget about them
+// (logs from before MARK_DELETING purged straight out of garbageChunks)
+func (the_fsm *FSM) applyPurgeChunks(cmd RaftCommand) interface{} {
+	for _, chunk := range cmd.Chunks {
+		delete(the_fsm.deletingChunks, chunk.ChunkID)
+		delete(the_fsm.garbageChunks, chunk.ChunkID)
+	}
+	return nil
+}
+
+// fails if any of chunks is being deleted, see retainChunk. has to be called with the lock held
+func (the_fsm *FSM) checkNotDeleting(chunks []ChunkStruct) error {
+	for _, chunk := range chunks {
+		if _, ok := the_fsm.deletingChunks[chunk.ChunkID]; ok {
+			return fmt.Errorf("%s: %w, try again shortly", chunk.ChunkID, ErrChunkDeleting)
+		}
+	}
+	return nil
+}
+
+// drops one reference for every entry in chunkIDs (a chunk listed twice loses two references)
+// chunks that end up with no references are moved from the location map into garbageChunks
+// has to be called with the lock held, after the file that used them has been removed/replaced
+func (the_fsm *FSM) releaseChunks(chunkIDs []string) {
+	for _, chunkID := range chunkIDs {
+		info, ok := the_fsm.chunkInfoMap[chunkID]
+		if !ok {
+			continue
+		}
+		info.RefCount--
+		if info.RefCount > 0 {
+			continue
+		}
+		delete(the_fsm.chunkInfoMap, chunkID)
+		if locations, ok := the_fsm.chunkIDToDataNodesMap[chunkID]; ok {
+			the_fsm.garbageChunks[chunkID] = locations
+			delete(the_fsm.chunkIDToDataNodesMap, chunkID)
+		}
+	}
+}
+
 // the snapshot function takes a snapshot of both the slices and sends it to the FSM
 // this function return 2 things, a value and an error
 func (the_fsm *FSM) Snapshot() (raft.FSMSnapshot, error) {
@@ -95,6 +334,13 @@ func (the_fsm *FSM) Snapshot() (raft.FSMSnapshot, error) {
 	snapshot := fsm_snapshot{
 		Files:  the_fsm.fileToChunksMap,
 		Chunks: the_fsm.chunkIDToDataNodesMap,
+		Garbage: the_fsm.garbageChunks,
+		Deleting: the_fsm.deletingChunks,
+		ChunkInfo: the_fsm.chunkInfoMap,
+		Dirs: the_fsm.directories,
+		FileInfo: the_fsm.fileInfoMap,
+		Uploads: the_fsm.uploads,
+		Peers: the_fsm.peers,
 	}
 
 	// 2. Convert it to bytes
@@ -139,24 +385,81 @@ func (the_fsm *FSM) Restore (rc io.ReadCloser) error {
 
 	the_fsm.fileToChunksMap = data.Files
 	the_fsm.chunkIDToDataNodesMap = data.Chunks
+	the_fsm.garbageChunks = data.Garbage
+	if the_fsm.garbageChunks == nil { // snapshots taken before we had a garbage collector
+		the_fsm.garbageChunks = make(map[string][]string)
+	}
+	the_fsm.deletingChunks = data.Deleting
+	if the_fsm.deletingChunks == nil {
+		the_fsm.deletingChunks = make(map[string][]string)
+	}
+	// snapshots taken before we had directories store bare file names ("x.txt"), those move to the root ("/x.txt")
+	the_fsm.directories = data.Dirs
+	if the_fsm.directories == nil {
+		the_fsm.directories = make(map[string]bool)
+	}
+	for name, chunkIDs := range the_fsm.fileToChunksMap {
+		if clean := normalizePath(name); clean != name {
+			delete(the_fsm.fileToChunksMap, name)
+			the_fsm.fileToChunksMap[clean] = chunkIDs
+		}
+	}
+	// snapshots from before we tracked file info just get an empty one per file
+	the_fsm.fileInfoMap = data.FileInfo
+	if the_fsm.fileInfoMap == nil {
+		the_fsm.fileInfoMap = make(map[string]*FileInfo)
+	}
+	for name := range the_fsm.fileToChunksMap {
+		if the_fsm.fileInfoMap[name] == nil {
+			the_fsm.fileInfoMap[name] = &FileInfo{}
+		}
+	}
+	the_fsm.uploads = data.Uploads
+	if the_fsm.uploads == nil { // snapshots taken before upload sessions
+		the_fsm.uploads = make(map[string]*UploadSession)
+	}
+	the_fsm.peers = data.Peers
+	if the_fsm.peers == nil { // snapshots taken before followers forwarded to the leader
+		the_fsm.peers = make(map[string]*Peer)
+	}
+	the_fsm.chunkInfoMap = data.ChunkInfo
+	if the_fsm.chunkInfoMap == nil { // snapshots taken before ref counting, rebuild the counts from the files (sizes are unknown)
+		the_fsm.chunkInfoMap = make(map[string]*ChunkInfo)
+		for _, chunkIDs := range the_fsm.fileToChunksMap {
+			for _, chunkID := range chunkIDs {
+				if the_fsm.chunkInfoMap[chunkID] == nil {
+					the_fsm.chunkInfoMap[chunkID] = &ChunkInfo{}
+				}
+				the_fsm.chunkInfoMap[chunkID].RefCount++
+			}
+		}
+	}
 
 	return nil
 }
 
+// the download plan of a file, what /get-metadata answers
+type FileMetadata struct {
+	Chunks     []ChunkStruct  `json:"chunks"`
+	Erasure    *ErasureCoding `json:"erasure,omitempty"`    // erasure coded files also get their layout
+	Stripes    []StripeStruct `json:"stripes,omitempty"`    // and the parity chunks of every stripe
+	Chunking   *Chunking      `json:"chunking,omitempty"`   // how the chunks were cut, if known
+	Encryption *Encryption    `json:"encryption,omitempty"` // encrypted files -> what the client needs to decrypt them
+}
+
 // this is a thread-safe "read-only" function.
 // It locks the maps, finds the file's chunks, and builds a plan.
-func (f *FSM) GetFileMetadata(fileName string) ([]ChunkStruct, error) {
+func (f *FSM) GetFileMetadata(fileName string) (*FileMetadata, error) {
 	f.lock.Lock()
 	defer f.lock.Unlock()
 
 	// Find the file's chunk IDs
-	log.Printf("file name is: %s", fileName)
-
-	log.Printf("Current file map: %v", f.fileToChunksMap)
-
-	chunkIDs, ok := f.fileToChunksMap [fileName]
+	chunkIDs, ok := f.fileToChunksMap [normalizePath(fileName)]
 	if !ok {
-		return nil, fmt.Errorf("file %s not found", fileName)
+		if f.pendingUpload(normalizePath(fileName)) != nil {
+			return nil, fmt.Errorf("file %s is still being uploaded: %w", fileName, ErrNotFound)
+		}
+		return nil, fmt.Errorf("file %s: %w", fileName, ErrNotFound)
 	}
 
 	// build the "plan" by looking up each chunk's location
@@ -168,12 +471,91 @@ func (f *FSM) GetFileMetadata(fileName string) ([]ChunkStruct, error) {
 			return nil, fmt.Errorf("chunk %s (part of %s) has no location data", chunkID, fileName)
 		}
 		
-		plan = append(plan, ChunkStruct{
+		chunk := ChunkStruct{
 			ChunkID:    chunkID,
 			ChunkIndex: i,
 			Locations:  locations,
-		})
+		}
+		if info, ok := f.chunkInfoMap[chunkID]; ok {
+			chunk.Size, chunk.Codec, chunk.RawSize = info.Size, info.Codec, info.RawSize
+		}
+		plan = append(plan, chunk)
+	}
+
+	metadata := &FileMetadata{Chunks: plan}
+	if info, ok := f.fileInfoMap[normalizePath(fileName)]; ok {
+		metadata.Chunking, metadata.Encryption = info.Chunking, info.Encryption
+		if info.Erasure != nil {
+			metadata.Erasure = info.Erasure
+			metadata.Stripes = f.fileStripes(info)
+		}
+	}
+	return metadata, nil
+}
+
+// returns a copy of the chunks that are waiting to be deleted from the DN's, and of the ones being deleted (chunkID -> locations)
+func (f *FSM) GetGarbageChunks() (garbage, deleting map[string][]string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	garbage = make(map[string][]string, len(f.garbageChunks))
+	for chunkID, locations := range f.garbageChunks {
+		garbage[chunkID] = append([]string(nil), locations...)
+	}
+	deleting = make(map[string][]string, len(f.deletingChunks))
+	for chunkID, locations := range f.deletingChunks {
+		deleting[chunkID] = append([]string(nil), locations...)
+	}
+	return garbage, deleting
+}
+
+// returns the locations of the chunks in chunkIDs that are already stored, chunks we dont know about are left out
+// the LB uses this to skip re-uploading chunks that some other file already has
+func (f *FSM) LookupChunks(chunkIDs []string) map[string][]string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	found := make(map[string][]string)
+	for _, chunkID := range chunkIDs {
+		if locations, ok := f.chunkIDToDataNodesMap[chunkID]; ok {
+			found[chunkID] = append([]string(nil), locations...)
+		}
+	}
+	return found
+}
+
+// how much space dedup is saving us
+type DedupStats struct {
+	LogicalChunks int   `json:"logical_chunks"` // chunk references across all files
+	UniqueChunks  int   `json:"unique_chunks"`  // chunks actually stored (once per replica)
+	LogicalBytes  int64 `json:"logical_bytes"`  // size of all files added up
+	StoredBytes   int64 `json:"stored_bytes"`   // size of the unique chunks added up (per replica)
+	SavedBytes    int64 `json:"saved_bytes"`    // LogicalBytes - StoredBytes
+}
+
+func (f *FSM) GetDedupStats() DedupStats {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	var stats DedupStats
+	for _, info := range f.chunkInfoMap {
+		stats.UniqueChunks++
+		stats.LogicalChunks += info.RefCount
+		stats.StoredBytes += info.Size
+		stats.LogicalBytes += info.Size * int64(info.RefCount)
+	}
+	stats.SavedBytes = stats.LogicalBytes - stats.StoredBytes
+	return stats
+}
+
+// returns a copy of every chunk's locations (chunkID -> DN urls), the LB uses it to find chunks that lost replicas
+func (f *FSM) GetAllChunkLocations() map[string][]string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	chunks := make(map[string][]string, len(f.chunkIDToDataNodesMap))
+	for chunkID, locations := range f.chunkIDToDataNodesMap {
+		chunks[chunkID] = append([]string(nil), locations...)
 	}
-	
-	return plan, nil
+	return chunks
 }
@@ -0,0 +1,330 @@
+package namenode
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/Rahul6700/Foodo/shared"
+	"github.com/hashicorp/raft"
+)
+
+// applies cmd the way raft does, as a log entry with the JSON command in it
+func apply(t *testing.T, f *FSM, cmd RaftCommand) error {
+	t.Helper()
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err, ok := f.Apply(&raft.Log{Data: data}).(error); ok {
+		return err
+	}
+	return nil
+}
+
+func chunk(id string, locations ...string) ChunkStruct {
+	return ChunkStruct{ChunkID: id, Locations: locations}
+}
+
+func register(name string, chunks ...ChunkStruct) RaftCommand {
+	return RaftCommand{Operation: "REGISTER_FILE", Filename: name, Chunks: chunks}
+}
+
+func deleteFile(name string) RaftCommand {
+	return RaftCommand{Operation: "DELETE_FILE", Filename: name}
+}
+
+func chunkOp(operation string, chunks ...ChunkStruct) RaftCommand {
+	return RaftCommand{Operation: operation, Chunks: chunks}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := []string{}
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// every case applies its steps to a new FSM, all but the last have to succeed and the last has to fail with err
+// (nil -> succeed), then the maps have to look like the rest of the case says
+type fsmCase struct {
+	name      string
+	steps     []RaftCommand
+	err       error
+	files     []string            // every file in the namespace
+	refs      map[string]int      // every chunk with references, and how many
+	garbage   []string            // chunks waiting for the GC
+	deleting  []string            // chunks the GC is deleting
+	locations map[string][]string // the locations of these chunks, nil -> the chunk has none
+	uploads   []string            // open upload sessions
+}
+
+func runFSMCases(t *testing.T, tests []fsmCase) {
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := NewFsm()
+			for i, step := range tt.steps {
+				err := apply(t, f, step)
+				if i < len(tt.steps)-1 {
+					if err != nil {
+						t.Fatalf("step %d (%s): %v", i, step.Operation, err)
+					}
+					continue
+				}
+				if (err == nil) != (tt.err == nil) || (tt.err != nil && !errors.Is(err, tt.err)) {
+					t.Fatalf("last step (%s) returned %v, want %v", step.Operation, err, tt.err)
+				}
+			}
+
+			refs := make(map[string]int)
+			for id, info := range f.chunkInfoMap {
+				refs[id] = info.RefCount
+			}
+			if tt.refs == nil {
+				tt.refs = map[string]int{}
+			}
+			check := func(what string, got, want any) {
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("%s = %v, want %v", what, got, want)
+				}
+			}
+			check("files", sortedKeys(f.fileToChunksMap), orEmpty(tt.files))
+			check("ref counts", refs, tt.refs)
+			check("garbage", sortedKeys(f.garbageChunks), orEmpty(tt.garbage))
+			check("deleting", sortedKeys(f.deletingChunks), orEmpty(tt.deleting))
+			check("uploads", sortedKeys(f.uploads), orEmpty(tt.uploads))
+			for id, want := range tt.locations {
+				check(fmt.Sprintf("locations of %s", id), f.chunkIDToDataNodesMap[id], want)
+			}
+		})
+	}
+}
+
+func orEmpty(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
+
+func TestRefCounting(t *testing.T) {
+	runFSMCases(t, []fsmCase{
+		{
+			name:      "files share a chunk",
+			steps:     []RaftCommand{register("/a", chunk("c1", "dn1"), chunk("c2", "dn1")), register("/b", chunk("c1", "dn2"))},
+			files:     []string{"/a", "/b"},
+			refs:      map[string]int{"c1": 2, "c2": 1},
+			locations: map[string][]string{"c1": {"dn1", "dn2"}, "c2": {"dn1"}},
+		},
+		{
+			name:      "deleting a file keeps the chunks another one uses",
+			steps:     []RaftCommand{register("/a", chunk("c1", "dn1"), chunk("c2", "dn1")), register("/b", chunk("c1", "dn2")), deleteFile("/a")},
+			files:     []string{"/b"},
+			refs:      map[string]int{"c1": 1},
+			garbage:   []string{"c2"},
+			locations: map[string][]string{"c1": {"dn1", "dn2"}, "c2": nil},
+		},
+		{
+			name:    "overwriting a file releases its old chunks",
+			steps:   []RaftCommand{register("/a", chunk("c1", "dn1")), register("/a", chunk("c2", "dn1"))},
+			files:   []string{"/a"},
+			refs:    map[string]int{"c2": 1},
+			garbage: []string{"c1"},
+		},
+		{
+			name:    "a chunk a file lists twice is released twice",
+			steps:   []RaftCommand{register("/a", chunk("c1", "dn1"), chunk("c1", "dn1")), deleteFile("/a")},
+			garbage: []string{"c1"},
+		},
+		{
+			name:      "garbage is taken back by a new file",
+			steps:     []RaftCommand{register("/a", chunk("c1", "dn1")), deleteFile("/a"), register("/b", chunk("c1", "dn2"))},
+			files:     []string{"/b"},
+			refs:      map[string]int{"c1": 1},
+			locations: map[string][]string{"c1": {"dn2"}},
+		},
+	})
+}
+
+func TestGarbageFencing(t *testing.T) {
+	garbage := []RaftCommand{register("/a", chunk("c1", "dn1")), deleteFile("/a")}
+	steps := func(more ...RaftCommand) []RaftCommand {
+		return append(append([]RaftCommand(nil), garbage...), more...)
+	}
+	begin := RaftCommand{Operation: "BEGIN_UPLOAD", SessionID: "s1", Filename: "/b", Chunks: []ChunkStruct{chunk("c1", "dn2")}}
+
+	runFSMCases(t, []fsmCase{
+		{
+			name:     "marking moves garbage to deleting",
+			steps:    steps(chunkOp("MARK_DELETING", chunk("c1"))),
+			deleting: []string{"c1"},
+		},
+		{
+			name:  "marking skips a chunk that was taken back",
+			steps: steps(register("/b", chunk("c1", "dn2")), chunkOp("MARK_DELETING", chunk("c1"))),
+			files: []string{"/b"},
+			refs:  map[string]int{"c1": 1},
+		},
+		{
+			name:     "a file can't use a chunk that is being deleted",
+			steps:    steps(chunkOp("MARK_DELETING", chunk("c1")), register("/b", chunk("c1", "dn2"))),
+			err:      ErrChunkDeleting,
+			deleting: []string{"c1"},
+		},
+		{
+			name:     "an upload can't use a chunk that is being deleted",
+			steps:    steps(chunkOp("MARK_DELETING", chunk("c1")), begin),
+			err:      ErrChunkDeleting,
+			deleting: []string{"c1"},
+		},
+		{
+			name:  "purging ends the fence",
+			steps: steps(chunkOp("MARK_DELETING", chunk("c1")), chunkOp("PURGE_CHUNKS", chunk("c1")), register("/b", chunk("c1", "dn2"))),
+			files: []string{"/b"},
+			refs:  map[string]int{"c1": 1},
+		},
+		{
+			name:  "purging without marking (logs from before MARK_DELETING)",
+			steps: steps(chunkOp("PURGE_CHUNKS", chunk("c1"))),
+		},
+		{
+			name:     "a chunk that is still referenced is never marked",
+			steps:    []RaftCommand{register("/a", chunk("c1", "dn1")), chunkOp("MARK_DELETING", chunk("c1"))},
+			files:    []string{"/a"},
+			refs:     map[string]int{"c1": 1},
+			deleting: nil,
+		},
+	})
+}
+
+func TestUpdateLocations(t *testing.T) {
+	update := func(c ChunkStruct) RaftCommand { return chunkOp("UPDATE_LOCATIONS", c) }
+	runFSMCases(t, []fsmCase{
+		{
+			name:      "a delta adds and removes replicas",
+			steps:     []RaftCommand{register("/a", chunk("c1", "dn1", "dn2")), update(ChunkStruct{ChunkID: "c1", Added: []string{"dn3"}, Removed: []string{"dn1"}})},
+			files:     []string{"/a"},
+			refs:      map[string]int{"c1": 1},
+			locations: map[string][]string{"c1": {"dn2", "dn3"}},
+		},
+		{
+			name: "a delta keeps replicas that were added after the LB read the locations",
+			steps: []RaftCommand{
+				register("/a", chunk("c1", "dn1")),
+				register("/b", chunk("c1", "dn2")),
+				update(ChunkStruct{ChunkID: "c1", Added: []string{"dn3"}, Removed: []string{"dn1"}}),
+			},
+			files:     []string{"/a", "/b"},
+			refs:      map[string]int{"c1": 2},
+			locations: map[string][]string{"c1": {"dn2", "dn3"}},
+		},
+		{
+			name:      "a chunk never loses its last location",
+			steps:     []RaftCommand{register("/a", chunk("c1", "dn1")), update(ChunkStruct{ChunkID: "c1", Removed: []string{"dn1"}})},
+			files:     []string{"/a"},
+			refs:      map[string]int{"c1": 1},
+			locations: map[string][]string{"c1": {"dn1"}},
+		},
+		{
+			name:      "an update without a delta replaces the locations (logs from before deltas)",
+			steps:     []RaftCommand{register("/a", chunk("c1", "dn1")), update(chunk("c1", "dn9"))},
+			files:     []string{"/a"},
+			refs:      map[string]int{"c1": 1},
+			locations: map[string][]string{"c1": {"dn9"}},
+		},
+		{
+			name:      "an empty update changes nothing",
+			steps:     []RaftCommand{register("/a", chunk("c1", "dn1")), update(chunk("c1"))},
+			files:     []string{"/a"},
+			refs:      map[string]int{"c1": 1},
+			locations: map[string][]string{"c1": {"dn1"}},
+		},
+		{
+			name:      "a deleted chunk is not brought back",
+			steps:     []RaftCommand{register("/a", chunk("c1", "dn1")), deleteFile("/a"), update(ChunkStruct{ChunkID: "c1", Added: []string{"dn2"}})},
+			garbage:   []string{"c1"},
+			locations: map[string][]string{"c1": nil},
+		},
+	})
+}
+
+func TestCommitUpload(t *testing.T) {
+	begin := func(replication int, chunks ...ChunkStruct) RaftCommand {
+		return RaftCommand{Operation: "BEGIN_UPLOAD", SessionID: "s1", Filename: "/f", Chunks: chunks, Replication: replication}
+	}
+	ack := func(chunks ...ChunkStruct) RaftCommand {
+		return RaftCommand{Operation: "ACK_CHUNKS", SessionID: "s1", Chunks: chunks}
+	}
+	commit := func(minReplicas int, chunks ...ChunkStruct) RaftCommand {
+		return RaftCommand{Operation: "COMMIT_UPLOAD", SessionID: "s1", Chunks: chunks, MinReplicas: minReplicas}
+	}
+	planned := []ChunkStruct{chunk("c1", "dn1", "dn2"), chunk("c2", "dn1", "dn2")}
+
+	runFSMCases(t, []fsmCase{
+		{
+			name:      "a chunk below the floor rejects the commit",
+			steps:     []RaftCommand{begin(0, planned...), ack(chunk("c1", "dn1", "dn2"), chunk("c2", "dn1")), commit(2)},
+			err:       ErrIncomplete,
+			refs:      map[string]int{"c1": 1, "c2": 1},
+			uploads:   []string{"s1"},
+			locations: map[string][]string{"c2": {"dn1"}},
+		},
+		{
+			name:      "acks that come with the commit count",
+			steps:     []RaftCommand{begin(0, planned...), ack(chunk("c1", "dn1", "dn2"), chunk("c2", "dn1")), commit(2, chunk("c2", "dn2"))},
+			files:     []string{"/f"},
+			refs:      map[string]int{"c1": 1, "c2": 1},
+			locations: map[string][]string{"c1": {"dn1", "dn2"}, "c2": {"dn1", "dn2"}},
+		},
+		{
+			name:  "a file that wants fewer replicas than the floor gets by with those",
+			steps: []RaftCommand{begin(1, planned...), commit(2, chunk("c1", "dn1"), chunk("c2", "dn2"))},
+			files: []string{"/f"},
+			refs:  map[string]int{"c1": 1, "c2": 1},
+		},
+		{
+			name:    "every chunk needs at least one replica",
+			steps:   []RaftCommand{begin(0, planned...), commit(0, chunk("c1", "dn1"))},
+			err:     ErrIncomplete,
+			refs:    map[string]int{"c1": 1, "c2": 1},
+			uploads: []string{"s1"},
+		},
+		{
+			name:      "chunks the cluster already stores count as acknowledged",
+			steps:     []RaftCommand{register("/a", chunk("c1", "dn1", "dn2")), begin(0, chunk("c1", "dn3")), commit(2)},
+			files:     []string{"/a", "/f"},
+			refs:      map[string]int{"c1": 2},
+			locations: map[string][]string{"c1": {"dn1", "dn2"}},
+		},
+		{
+			name:    "aborting releases the acknowledged chunks",
+			steps:   []RaftCommand{begin(0, planned...), ack(chunk("c1", "dn1")), RaftCommand{Operation: "ABORT_UPLOAD", SessionID: "s1"}},
+			garbage: []string{"c1"},
+		},
+	})
+}
+
+func TestCommitMinReplicasFloor(t *testing.T) {
+	s := &ApiServer{minReplicas: 2}
+	tests := []struct {
+		operation string
+		requested int
+		want      int
+	}{
+		{"COMMIT_UPLOAD", 0, 2},
+		{"COMMIT_UPLOAD", 1, 2},
+		{"COMMIT_UPLOAD", 3, 3},
+		{"ACK_CHUNKS", 0, 0},
+	}
+	for _, tt := range tests {
+		cmd := s.uploadCommand(tt.operation, shared.UploadSessionRequest{SessionID: "s1", MinReplicas: tt.requested})
+		if cmd.MinReplicas != tt.want || cmd.Operation != tt.operation || cmd.SessionID != "s1" {
+			t.Errorf("%s asking for %d replicas became %+v, want %d", tt.operation, tt.requested, cmd, tt.want)
+		}
+	}
+}
@@ -0,0 +1,323 @@
+package namenode
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// the namespace is a tree, but we store it flat:
+// every file is a key in fileToChunksMap and every directory a key in directories, both as full clean paths ("/logs/app/x.txt")
+// "/" always exists and is never stored
+
+// returned (wrapped) by the FSM so the api can pick the right status code
+var (
+	ErrNotFound = errors.New("not found")
+	ErrExists   = errors.New("already exists")
+)
+
+// one entry of a directory listing, directories have no size or time
+type DirEntry struct {
+	Name       string     `json:"name"`
+	Path       string     `json:"path"`
+	IsDir      bool       `json:"is_dir"`
+	Size       int64      `json:"size,omitempty"`
+	ModifiedAt *time.Time `json:"modified_at,omitempty"`
+	Pending    bool       `json:"pending,omitempty"` // a file that is still being uploaded, it can't be read yet
+}
+
+// turns whatever the caller sent ("x.txt", "a//b/", "/a/../b") into a clean absolute path
+func normalizePath(p string) string {
+	return path.Clean("/" + p)
+}
+
+// true if child is somewhere below dir ("/a/b/c" is under "/a")
+func isUnder(child, dir string) bool {
+	if dir == "/" {
+		return child != "/"
+	}
+	return strings.HasPrefix(child, dir+"/")
+}
+
+// creates dir and every missing parent, like mkdir -p
+// fails if any of them is already a file. has to be called with the lock held
+func (the_fsm *FSM) makeDirs(dir string) error {
+	if dir == "/" {
+		return nil
+	}
+	if _, isFile := the_fsm.fileToChunksMap[dir]; isFile {
+		return fmt.Errorf("%s is a file: %w", dir, ErrExists)
+	}
+	if err := the_fsm.makeDirs(path.Dir(dir)); err != nil {
+		return err
+	}
+	the_fsm.directories[dir] = true
+	return nil
+}
+
+func (the_fsm *FSM) isDir(p string) bool {
+	return p == "/" || the_fsm.directories[p]
+}
+
+func (the_fsm *FSM) applyMkdir(cmd RaftCommand) interface{} {
+	return the_fsm.makeDirs(normalizePath(cmd.Filename))
+}
+
+// moves a file or a whole directory (with everything under it) to NewPath, in a single raft command
+// if NewPath is an existing directory the source is moved into it, like mv does
+func (the_fsm *FSM) applyRename(cmd RaftCommand) interface{} {
+	src := normalizePath(cmd.Filename)
+	dst := normalizePath(cmd.NewPath)
+	if src == "/" {
+		return fmt.Errorf("cannot move /")
+	}
+
+	_, srcIsFile := the_fsm.fileToChunksMap[src]
+	if !srcIsFile && !the_fsm.directories[src] {
+		return fmt.Errorf("%s: %w", src, ErrNotFound)
+	}
+	if the_fsm.isDir(dst) {
+		dst = path.Join(dst, path.Base(src))
+	}
+	if dst == src {
+		return nil
+	}
+	if _, isFile := the_fsm.fileToChunksMap[dst]; isFile || the_fsm.directories[dst] {
+		return fmt.Errorf("%s: %w", dst, ErrExists)
+	}
+	if isUnder(dst, src) {
+		return fmt.Errorf("cannot move %s into itself", src)
+	}
+	if err := the_fsm.makeDirs(path.Dir(dst)); err != nil {
+		return err
+	}
+
+	if srcIsFile {
+		the_fsm.moveFile(src, dst)
+		return nil
+	}
+
+	// a directory, so everything below it moves along
+	delete(the_fsm.directories, src)
+	the_fsm.directories[dst] = true
+	for dir := range the_fsm.directories {
+		if isUnder(dir, src) {
+			delete(the_fsm.directories, dir)
+			the_fsm.directories[dst+strings.TrimPrefix(dir, src)] = true
+		}
+	}
+	var filesUnder []string
+	for file := range the_fsm.fileToChunksMap {
+		if isUnder(file, src) {
+			filesUnder = append(filesUnder, file)
+		}
+	}
+	for _, file := range filesUnder {
+		the_fsm.moveFile(file, dst+strings.TrimPrefix(file, src))
+	}
+	return nil
+}
+
+// moves a single file entry (chunks and info) to a new path, has to be called with the lock held
+func (the_fsm *FSM) moveFile(src, dst string) {
+	the_fsm.fileToChunksMap[dst] = the_fsm.fileToChunksMap[src]
+	the_fsm.fileInfoMap[dst] = the_fsm.fileInfoMap[src]
+	delete(the_fsm.fileToChunksMap, src)
+	delete(the_fsm.fileInfoMap, src)
+}
+
+// drops a single file entry and releases its chunks, has to be called with the lock held
+func (the_fsm *FSM) removeFile(file string) {
+	chunkIDs := the_fsm.fileToChunksMap[file]
+	info := the_fsm.fileInfoMap[file]
+	delete(the_fsm.fileToChunksMap, file)
+	delete(the_fsm.fileInfoMap, file)
+	the_fsm.releaseFile(chunkIDs, info)
+}
+
+// removes a file, or a directory
+// a directory that still has something in it is only removed when Recursive is set (rm -r), and then everything under it goes too
+// DELETE_FILE is the older, files only version of this
+// chunks that no other file uses are handed over to the garbage collector
+func (the_fsm *FSM) applyDelete(cmd RaftCommand) interface{} {
+	target := normalizePath(cmd.Filename)
+
+	if _, ok := the_fsm.fileToChunksMap[target]; ok {
+		the_fsm.removeFile(target)
+		return nil
+	}
+	if cmd.Operation == "DELETE_FILE" || !the_fsm.directories[target] {
+		if target == "/" {
+			return fmt.Errorf("cannot delete /")
+		}
+		return fmt.Errorf("%s: %w", target, ErrNotFound)
+	}
+
+	var filesUnder []string
+	for file := range the_fsm.fileToChunksMap {
+		if isUnder(file, target) {
+			filesUnder = append(filesUnder, file)
+		}
+	}
+	var dirsUnder []string
+	for dir := range the_fsm.directories {
+		if isUnder(dir, target) {
+			dirsUnder = append(dirsUnder, dir)
+		}
+	}
+	if !cmd.Recursive && len(filesUnder)+len(dirsUnder) > 0 {
+		return fmt.Errorf("directory %s is not empty", target)
+	}
+
+	for _, file := range filesUnder {
+		the_fsm.removeFile(file)
+	}
+	for _, dir := range dirsUnder {
+		delete(the_fsm.directories, dir)
+	}
+	delete(the_fsm.directories, target)
+	return nil
+}
+
+// lists the direct children of a directory, directories first and then by name
+func (f *FSM) ListDir(dir string) ([]DirEntry, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	dir = normalizePath(dir)
+	if !f.isDir(dir) {
+		if _, isFile := f.fileToChunksMap[dir]; isFile {
+			return nil, fmt.Errorf("%s is not a directory", dir)
+		}
+		return nil, fmt.Errorf("%s: %w", dir, ErrNotFound)
+	}
+
+	entries := []DirEntry{}
+	for d := range f.directories {
+		if d != "/" && path.Dir(d) == dir {
+			entries = append(entries, DirEntry{Name: path.Base(d), Path: d, IsDir: true})
+		}
+	}
+	for file := range f.fileToChunksMap {
+		if path.Dir(file) == dir {
+			entry := DirEntry{Name: path.Base(file), Path: file}
+			if info, ok := f.fileInfoMap[file]; ok {
+				entry.Size = info.Size
+				modified := info.ModifiedAt
+				entry.ModifiedAt = &modified
+			}
+			entries = append(entries, entry)
+		}
+	}
+	// files that are only being uploaded show up too (once), a pending new version of an existing file doesnt
+	pending := make(map[string]bool)
+	for _, session := range f.uploads {
+		file := session.Filename
+		if _, isFile := f.fileToChunksMap[file]; isFile || pending[file] || path.Dir(file) != dir {
+			continue
+		}
+		pending[file] = true
+		created := session.CreatedAt
+		entries = append(entries, DirEntry{Name: path.Base(file), Path: file, Size: session.Size, ModifiedAt: &created, Pending: true})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].IsDir != entries[j].IsDir {
+			return entries[i].IsDir
+		}
+		return entries[i].Name < entries[j].Name
+	})
+	return entries, nil
+}
+
+// everything /stat reports about a single path
+type FileStat struct {
+	Path        string         `json:"path"`
+	IsDir       bool           `json:"is_dir"`
+	State       string         `json:"state,omitempty"` // files only -> StateCommitted, or StatePending while the first upload to the path is running
+	Size        int64          `json:"size"`
+	ChunkCount  int            `json:"chunk_count"`
+	CreatedAt   time.Time      `json:"created_at"`
+	ModifiedAt  time.Time      `json:"modified_at"`
+	Replicas    []int          `json:"replicas,omitempty"`    // replica count of every chunk, in chunk order
+	MinReplicas int            `json:"min_replicas"`          // the lowest of those, i.e. how many DN failures the file survives + 1
+	Erasure     *ErasureCoding `json:"erasure,omitempty"`     // erasure coded files -> their layout, every stripe survives ParityShards lost shards
+	Replication int            `json:"replication,omitempty"` // replicas the file wants, 0 -> the LB's -replication
+	Policy      string         `json:"policy,omitempty"`      // its storage policy, if it has one
+	Chunking    *Chunking      `json:"chunking,omitempty"`    // how it was cut into chunks, if known
+	Encryption  *Encryption    `json:"encryption,omitempty"`  // encrypted files -> their cipher and key id (and wrapped key)
+	StoredSize  int64          `json:"stored_size,omitempty"` // the chunks added up as stored (compressed, encrypted), one replica
+}
+
+// builds the stat of a file, has to be called with the lock held
+func (f *FSM) fileStat(file string) FileStat {
+	chunkIDs := f.fileToChunksMap[file]
+	stat := FileStat{Path: file, State: StateCommitted, ChunkCount: len(chunkIDs)}
+	if info, ok := f.fileInfoMap[file]; ok {
+		stat.Size = info.Size
+		stat.CreatedAt = info.CreatedAt
+		stat.ModifiedAt = info.ModifiedAt
+		stat.Erasure = info.Erasure
+		stat.Replication = info.Replication
+		stat.Policy = info.Policy
+		stat.Chunking = info.Chunking
+		stat.Encryption = info.Encryption
+	}
+	for i, chunkID := range chunkIDs {
+		if info, ok := f.chunkInfoMap[chunkID]; ok {
+			stat.StoredSize += info.Size
+		}
+		replicas := len(f.chunkIDToDataNodesMap[chunkID])
+		stat.Replicas = append(stat.Replicas, replicas)
+		if i == 0 || replicas < stat.MinReplicas {
+			stat.MinReplicas = replicas
+		}
+	}
+	return stat
+}
+
+// stat of a file or a directory
+func (f *FSM) Stat(p string) (FileStat, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	p = normalizePath(p)
+	if _, ok := f.fileToChunksMap[p]; ok {
+		return f.fileStat(p), nil
+	}
+	if f.isDir(p) {
+		return FileStat{Path: p, IsDir: true}, nil
+	}
+	if session := f.pendingUpload(p); session != nil {
+		return pendingStat(session), nil
+	}
+	return FileStat{}, fmt.Errorf("%s: %w", p, ErrNotFound)
+}
+
+// lists every file whose path starts with prefix, sorted by path
+// it is paginated: at most limit files after the path "after" are returned, plus the "after" to use for the next page ("" when there is none)
+func (f *FSM) ListFiles(prefix, after string, limit int) ([]FileStat, string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	var paths []string
+	for file := range f.fileToChunksMap {
+		if strings.HasPrefix(file, prefix) && file > after {
+			paths = append(paths, file)
+		}
+	}
+	sort.Strings(paths)
+
+	next := ""
+	if len(paths) > limit {
+		paths = paths[:limit]
+		next = paths[limit-1]
+	}
+	files := []FileStat{}
+	for _, file := range paths {
+		files = append(files, f.fileStat(file))
+	}
+	return files, next
+}
@@ -0,0 +1,106 @@
+package namenode
+
+import (
+	"fmt"
+
+	"github.com/Rahul6700/Foodo/shared"
+)
+
+// every file has a replication setting -> how many replicas each of its chunks wants
+// it comes with the upload (a number or a named storage policy, see shared/policy.go) and SET_REPLICATION changes it later,
+// 0 means the file follows the LB's -replication. we only record it, the LB's replication manager asks for the
+// resulting per chunk targets (ReplicationTargets) and copies or removes replicas until the chunks match them
+
+// works out the replication a file gets from what the command asked for, and checks it fits the file
+// a policy decides the replication on its own, an erasure coded file can only have its own layout's policy
+func checkPolicy(erasure *ErasureCoding, replication int, policy string) (int, error) {
+	if replication < 0 {
+		return 0, fmt.Errorf("bad replication %d", replication)
+	}
+	if policy == "" {
+		if erasure != nil && replication > 0 {
+			return 0, fmt.Errorf("erasure coded files keep a single copy of every shard, they can't be replicated")
+		}
+		return replication, nil
+	}
+
+	p, ok := shared.LookupPolicy(policy)
+	if !ok {
+		return 0, fmt.Errorf("unknown storage policy %s", policy)
+	}
+	switch {
+	case p.Erasure == nil && erasure != nil:
+		return 0, fmt.Errorf("policy %s is replicated but the file is erasure coded, it has to be uploaded again", policy)
+	case p.Erasure != nil && (erasure == nil || *erasure != *p.Erasure):
+		return 0, fmt.Errorf("policy %s is erasure coded %d+%d, files have to be uploaded with that layout", policy, p.Erasure.DataShards, p.Erasure.ParityShards)
+	}
+	return p.Replication, nil
+}
+
+// changes the replication (or policy) of a file, the LB adds or removes replicas from there on
+func (the_fsm *FSM) applySetReplication(cmd RaftCommand) interface{} {
+	name := normalizePath(cmd.Filename)
+	if _, ok := the_fsm.fileToChunksMap[name]; !ok {
+		if the_fsm.isDir(name) {
+			return fmt.Errorf("%s is a directory", name)
+		}
+		return fmt.Errorf("%s: %w", name, ErrNotFound)
+	}
+	if cmd.Replication < 1 && cmd.Policy == "" {
+		return fmt.Errorf("replication has to be at least 1")
+	}
+
+	info := the_fsm.fileInfoMap[name]
+	replication, err := checkPolicy(info.Erasure, cmd.Replication, cmd.Policy)
+	if err != nil {
+		return err
+	}
+	info.Replication, info.Policy = replication, cmd.Policy
+	return nil
+}
+
+// replicas wanted by every chunk of a file (or upload) with this setting
+func wantedReplicas(erasure *ErasureCoding, replication, defaultReplication int) int {
+	switch {
+	case erasure != nil:
+		return 1
+	case replication > 0:
+		return replication
+	default:
+		return defaultReplication
+	}
+}
+
+// how many replicas every chunk wants, chunkID -> replicas, defaultReplication is the LB's -replication
+// a chunk shared by several files (dedup) wants as many as the most demanding of them,
+// chunks of uploads that are still running want what their file will
+func (f *FSM) ReplicationTargets(defaultReplication int) map[string]int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	targets := make(map[string]int, len(f.chunkInfoMap))
+	want := func(chunkID string, replicas int) {
+		if replicas > targets[chunkID] {
+			targets[chunkID] = replicas
+		}
+	}
+	for name, chunkIDs := range f.fileToChunksMap {
+		info := f.fileInfoMap[name]
+		replicas := wantedReplicas(info.Erasure, info.Replication, defaultReplication)
+		for _, chunkID := range chunkIDs {
+			want(chunkID, replicas)
+		}
+		for _, parity := range info.Stripes {
+			for _, chunkID := range parity {
+				want(chunkID, replicas)
+			}
+		}
+	}
+	for _, session := range f.uploads {
+		replicas := wantedReplicas(session.Erasure, session.Replication, defaultReplication)
+		for _, chunk := range session.allChunks() {
+			want(chunk.ChunkID, replicas)
+		}
+	}
+	return targets
+}
@@ -0,0 +1,309 @@
+package namenode
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+// uploads go through a session so a file is never readable before its data exists:
+// BEGIN_UPLOAD   -> the LB opens a session with the file's chunk list, the path shows up as PENDING in /stat and /ls
+// ACK_CHUNKS     -> the client reports which replicas stored each chunk, as the datanodes acknowledge them
+// COMMIT_UPLOAD  -> carries the client's last acks, once every chunk has MinReplicas acknowledged replicas
+//                   the session turns into the file (replacing any old version), otherwise the commit is rejected
+// ABORT_UPLOAD   -> the client gave up, the session is dropped and its chunks released
+// EXPIRE_UPLOAD  -> same, but sent by the LB for sessions nobody committed or aborted in time
+// the session holds a reference on each of its chunks the same way a file does, so neither the GC nor block reports
+// touch chunks that a (possibly interrupted) upload has already written
+
+// returned (wrapped) when a commit comes before all of the session's chunks have enough acknowledged replicas
+var ErrIncomplete = errors.New("upload incomplete")
+
+// the states a path can be in, see FileStat.State
+const (
+	StateCommitted = "committed"
+	StatePending   = "pending"
+)
+
+// an upload that has begun but is not committed yet
+type UploadSession struct {
+	ID        string              `json:"id"`
+	Filename  string              `json:"filename"`
+	Chunks    []ChunkStruct       `json:"chunks"` // every chunk of the file in order, Locations are where the LB planned them
+	Size      int64               `json:"size"`
+	CreatedAt time.Time           `json:"created_at"`
+	Acked     map[string][]string `json:"acked"` // chunkID -> replicas that acknowledged storing it
+	Erasure   *ErasureCoding      `json:"erasure,omitempty"`
+	Stripes   []StripeStruct      `json:"stripes,omitempty"` // erasure coded -> the parity chunks, they are acked like the others
+	// what the file gets once committed, see replication.go
+	Replication int         `json:"replication,omitempty"`
+	Policy      string      `json:"policy,omitempty"`
+	Chunking    *Chunking   `json:"chunking,omitempty"`
+	Encryption  *Encryption `json:"encryption,omitempty"`
+}
+
+// opens an upload session, chunks the cluster already stores count as acknowledged right away
+func (the_fsm *FSM) applyBeginUpload(cmd RaftCommand) interface{} {
+	if cmd.SessionID == "" {
+		return fmt.Errorf("missing session id")
+	}
+	if _, exists := the_fsm.uploads[cmd.SessionID]; exists {
+		return fmt.Errorf("upload session %s: %w", cmd.SessionID, ErrExists)
+	}
+	filename := normalizePath(cmd.Filename)
+	if the_fsm.isDir(filename) {
+		return fmt.Errorf("%s is a directory: %w", filename, ErrExists)
+	}
+	if cmd.Erasure != nil {
+		if err := checkErasure(cmd.Erasure, len(cmd.Chunks), cmd.Stripes); err != nil {
+			return err
+		}
+	}
+	replication, err := checkPolicy(cmd.Erasure, cmd.Replication, cmd.Policy)
+	if err != nil {
+		return err
+	}
+	if err := checkChunking(cmd.Chunking); err != nil {
+		return err
+	}
+	if err := checkCodecs(cmd.Chunks); err != nil {
+		return err
+	}
+	if err := checkEncryption(cmd.Encryption); err != nil {
+		return err
+	}
+
+	session := &UploadSession{
+		ID:          cmd.SessionID,
+		Filename:    filename,
+		Chunks:      cmd.Chunks,
+		Size:        cmd.Size,
+		CreatedAt:   time.Unix(0, cmd.Timestamp).UTC(),
+		Acked:       make(map[string][]string),
+		Erasure:     cmd.Erasure,
+		Stripes:     cmd.Stripes,
+		Replication: replication,
+		Policy:      cmd.Policy,
+		Chunking:    cmd.Chunking,
+		Encryption:  cmd.Encryption,
+	}
+	if err := the_fsm.checkNotDeleting(session.allChunks()); err != nil {
+		return err
+	}
+	for _, chunk := range session.allChunks() {
+		if locations, ok := the_fsm.chunkIDToDataNodesMap[chunk.ChunkID]; ok {
+			session.Acked[chunk.ChunkID] = append([]string(nil), locations...)
+		}
+		the_fsm.retainChunk(chunk)
+	}
+	the_fsm.uploads[cmd.SessionID] = session
+	return nil
+}
+
+// the chunks of the file followed by the parity chunks of every stripe (if it is erasure coded)
+func (session *UploadSession) allChunks() []ChunkStruct {
+	if len(session.Stripes) == 0 {
+		return session.Chunks
+	}
+	chunks := append([]ChunkStruct(nil), session.Chunks...)
+	for _, stripe := range session.Stripes {
+		chunks = append(chunks, stripe.Parity...)
+	}
+	return chunks
+}
+
+// takes one reference on a chunk, creating its entry if this is the first one
+// has to be called with the lock held, and not for chunks that are being deleted (checkNotDeleting): the GC's deletes
+// could still land after the chunk was stored again, and take the new copy with them
+func (the_fsm *FSM) retainChunk(chunk ChunkStruct) {
+	delete(the_fsm.garbageChunks, chunk.ChunkID) // a chunk that was waiting to be deleted is in use again
+	info, ok := the_fsm.chunkInfoMap[chunk.ChunkID]
+	if !ok {
+		info = &ChunkInfo{}
+		the_fsm.chunkInfoMap[chunk.ChunkID] = info
+	}
+	info.RefCount++
+	if chunk.Size > 0 {
+		info.Size = chunk.Size
+	}
+	// the chunk ID is the hash of the stored bytes, so the same chunk always comes with the same codec
+	if chunk.Codec != "" {
+		info.Codec, info.RawSize = chunk.Codec, chunk.RawSize
+	}
+}
+
+// records the replicas that stored some of a session's chunks
+// they go into the chunk locations right away, so the replication manager and block reports already know about them
+func (the_fsm *FSM) applyAckChunks(cmd RaftCommand) interface{} {
+	session, ok := the_fsm.uploads[cmd.SessionID]
+	if !ok {
+		return fmt.Errorf("upload session %s: %w", cmd.SessionID, ErrNotFound)
+	}
+	the_fsm.ackChunks(session, cmd.Chunks)
+	return nil
+}
+
+func (the_fsm *FSM) ackChunks(session *UploadSession, acks []ChunkStruct) {
+	for _, ack := range acks {
+		if len(ack.Locations) == 0 || !sessionHasChunk(session, ack.ChunkID) {
+			continue
+		}
+		session.Acked[ack.ChunkID] = mergeLocations(session.Acked[ack.ChunkID], ack.Locations)
+		the_fsm.chunkIDToDataNodesMap[ack.ChunkID] = mergeLocations(the_fsm.chunkIDToDataNodesMap[ack.ChunkID], ack.Locations)
+	}
+}
+
+func sessionHasChunk(session *UploadSession, chunkID string) bool {
+	for _, chunk := range session.allChunks() {
+		if chunk.ChunkID == chunkID {
+			return true
+		}
+	}
+	return false
+}
+
+// turns the session into its file, the session's chunk references become the file's
+// the acks that came with the commit are recorded first, then every chunk needs cmd.MinReplicas (at least 1) of them
+// shards of an erasure coded file only need the one, the parity is their redundancy
+// a rejected commit leaves the session open, the client can upload the missing replicas and commit again
+func (the_fsm *FSM) applyCommitUpload(cmd RaftCommand) interface{} {
+	session, ok := the_fsm.uploads[cmd.SessionID]
+	if !ok {
+		return fmt.Errorf("upload session %s: %w", cmd.SessionID, ErrNotFound)
+	}
+	the_fsm.ackChunks(session, cmd.Chunks)
+
+	// a file that only wants fewer replicas than the LB's -min-replicas gets by with those
+	minReplicas := cmd.MinReplicas
+	if session.Replication > 0 && session.Replication < minReplicas {
+		minReplicas = session.Replication
+	}
+	if minReplicas < 1 || session.Erasure != nil {
+		minReplicas = 1
+	}
+	for _, chunk := range session.allChunks() {
+		if acked := len(session.Acked[chunk.ChunkID]); acked < minReplicas {
+			return fmt.Errorf("chunk %d (%s) of %s has %d acknowledged replicas, needs %d: %w", chunk.ChunkIndex, chunk.ChunkID, session.Filename, acked, minReplicas, ErrIncomplete)
+		}
+	}
+	if err := the_fsm.checkFilePath(session.Filename); err != nil {
+		return err
+	}
+
+	chunkIDs := make([]string, len(session.Chunks))
+	for i, chunk := range session.Chunks {
+		chunkIDs[i] = chunk.ChunkID
+	}
+	info := the_fsm.installFile(session.Filename, chunkIDs, session.Size, time.Unix(0, cmd.Timestamp).UTC())
+	info.Replication, info.Policy = session.Replication, session.Policy
+	info.Chunking, info.Encryption = session.Chunking, session.Encryption
+	if session.Erasure != nil {
+		info.Erasure = session.Erasure
+		for _, stripe := range session.Stripes {
+			var parity []string
+			for _, chunk := range stripe.Parity {
+				parity = append(parity, chunk.ChunkID)
+			}
+			info.Stripes = append(info.Stripes, parity)
+		}
+	}
+	delete(the_fsm.uploads, session.ID)
+	return nil
+}
+
+// drops a session without creating its file (ABORT_UPLOAD and EXPIRE_UPLOAD), chunks nobody else uses end up in garbage
+func (the_fsm *FSM) applyAbortUpload(cmd RaftCommand) interface{} {
+	session, ok := the_fsm.uploads[cmd.SessionID]
+	if !ok {
+		return fmt.Errorf("upload session %s: %w", cmd.SessionID, ErrNotFound)
+	}
+	if cmd.Operation == "EXPIRE_UPLOAD" {
+		log.Printf("upload session %s of %s expired", session.ID, session.Filename)
+	}
+	the_fsm.dropSession(session)
+	return nil
+}
+
+// the newest open session uploading to name, nil if there is none. has to be called with the lock held
+func (the_fsm *FSM) pendingUpload(name string) *UploadSession {
+	var newest *UploadSession
+	for _, session := range the_fsm.uploads {
+		if session.Filename == name && (newest == nil || session.CreatedAt.After(newest.CreatedAt)) {
+			newest = session
+		}
+	}
+	return newest
+}
+
+// the stat of a path that only has a pending upload, replicas are the acknowledged ones so far
+// has to be called with the lock held
+func pendingStat(session *UploadSession) FileStat {
+	stat := FileStat{
+		Path:        session.Filename,
+		State:       StatePending,
+		Size:        session.Size,
+		ChunkCount:  len(session.Chunks),
+		CreatedAt:   session.CreatedAt,
+		ModifiedAt:  session.CreatedAt,
+		Erasure:     session.Erasure,
+		Replication: session.Replication,
+		Policy:      session.Policy,
+		Chunking:    session.Chunking,
+		Encryption:  session.Encryption,
+	}
+	for i, chunk := range session.Chunks {
+		replicas := len(session.Acked[chunk.ChunkID])
+		stat.Replicas = append(stat.Replicas, replicas)
+		if i == 0 || replicas < stat.MinReplicas {
+			stat.MinReplicas = replicas
+		}
+	}
+	return stat
+}
+
+func (the_fsm *FSM) dropSession(session *UploadSession) {
+	var chunkIDs []string
+	for _, chunk := range session.allChunks() {
+		chunkIDs = append(chunkIDs, chunk.ChunkID)
+	}
+	delete(the_fsm.uploads, session.ID)
+	the_fsm.releaseChunks(chunkIDs)
+}
+
+// returns a copy of an upload session
+func (f *FSM) GetUploadSession(id string) (*UploadSession, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	session, ok := f.uploads[id]
+	if !ok {
+		return nil, fmt.Errorf("upload session %s: %w", id, ErrNotFound)
+	}
+	return copySession(session), nil
+}
+
+// returns a copy of every open upload session, oldest first
+func (f *FSM) ListUploadSessions() []*UploadSession {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	sessions := make([]*UploadSession, 0, len(f.uploads))
+	for _, session := range f.uploads {
+		sessions = append(sessions, copySession(session))
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].CreatedAt.Before(sessions[j].CreatedAt) })
+	return sessions
+}
+
+func copySession(session *UploadSession) *UploadSession {
+	c := *session
+	c.Chunks = append([]ChunkStruct(nil), session.Chunks...)
+	c.Stripes = append([]StripeStruct(nil), session.Stripes...)
+	c.Acked = make(map[string][]string, len(session.Acked))
+	for chunkID, locations := range session.Acked {
+		c.Acked[chunkID] = append([]string(nil), locations...)
+	}
+	return &c
+}
@@ -0,0 +1,29 @@
+package shared
+
+// storage policies are named presets for how a file is stored, so clients dont have to agree on numbers
+// a file records the policy it was uploaded with (or moved to with SET_REPLICATION), "client stat" shows it
+
+// StoragePolicy is either replicated (Replication copies of every chunk) or erasure coded (Erasure set, Replication 0)
+type StoragePolicy struct {
+	Name        string         `json:"name"`
+	Replication int            `json:"replication,omitempty"`
+	Erasure     *ErasureCoding `json:"erasure,omitempty"`
+}
+
+// StoragePolicies are the policies every component knows about
+var StoragePolicies = []StoragePolicy{
+	{Name: "hot-3x", Replication: 3},
+	{Name: "warm-2x", Replication: 2},
+	{Name: "scratch-1x", Replication: 1},
+	{Name: "cold-ec", Erasure: &ErasureCoding{DataShards: 6, ParityShards: 3}},
+}
+
+// LookupPolicy returns the storage policy called name
+func LookupPolicy(name string) (StoragePolicy, bool) {
+	for _, policy := range StoragePolicies {
+		if policy.Name == name {
+			return policy, true
+		}
+	}
+	return StoragePolicy{}, false
+}
@@ -9,6 +9,21 @@ type RaftCommand struct {
 	Operation string `json:"operation"`
 	Filename string `json:"filename"`
 	Chunks []ChunkStruct `json:"chunks"`
+	NewPath string `json:"new_path,omitempty"` // RENAME -> where Filename is moved to
+	Recursive bool `json:"recursive,omitempty"` // DELETE -> also delete a directory that is not empty
+	Size int64 `json:"size,omitempty"` // REGISTER_FILE -> total size of the file in bytes
+	Timestamp int64 `json:"timestamp,omitempty"` // unix nanos of when the proposer created the command, the FSM uses it as the file's time
+	SessionID string `json:"session_id,omitempty"` // BEGIN_UPLOAD, ACK_CHUNKS, COMMIT_UPLOAD, ABORT_UPLOAD, EXPIRE_UPLOAD -> the upload session
+	MinReplicas int `json:"min_replicas,omitempty"` // COMMIT_UPLOAD -> acknowledged replicas every chunk needs for the commit to go through
+	Erasure *ErasureCoding `json:"erasure,omitempty"` // BEGIN_UPLOAD -> the file is erasure coded instead of replicated
+	Stripes []StripeStruct `json:"stripes,omitempty"` // BEGIN_UPLOAD -> the parity chunks of every stripe, with where they go
+	Replication int `json:"replication,omitempty"` // REGISTER_FILE, BEGIN_UPLOAD, SET_REPLICATION -> replicas every chunk of the file wants, 0 -> the LB's -replication
+	Policy string `json:"policy,omitempty"` // REGISTER_FILE, BEGIN_UPLOAD, SET_REPLICATION -> the storage policy of the file, see policy.go
+	Chunking *Chunking `json:"chunking,omitempty"` // BEGIN_UPLOAD -> how the client cut the file into chunks
+	Encryption *Encryption `json:"encryption,omitempty"` // BEGIN_UPLOAD -> the file's chunks are encrypted by the client
+	NodeID string `json:"node_id,omitempty"` // REGISTER_PEER, REMOVE_PEER -> the namenode's raft id
+	RaftAddr string `json:"raft_addr,omitempty"` // REGISTER_PEER -> its raft address
+	APIAddr string `json:"api_addr,omitempty"` // REGISTER_PEER -> the address its API is reachable at, followers forward to the leader's
 }
 
 // this is the helper struct
@@ -16,10 +31,156 @@ type ChunkStruct struct {
 	ChunkID string `json:"chunk_id"`
 	ChunkIndex int `json:"chunk_index"`
 	Locations []string `json:"locations"`
+	Size int64 `json:"size,omitempty"` // size of the chunk in bytes
+	Codec string `json:"codec,omitempty"` // how the chunk's data was compressed before it was stored, "" -> it wasnt (see CodecGzip)
+	RawSize int64 `json:"raw_size,omitempty"` // compressed chunks -> the size of the data before compression
+	// UPDATE_LOCATIONS -> the replicas to add to and remove from the chunk's locations
+	Added []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
 }
 
 // HeartbeatPayload is used by the DN's to send heartbeat's to the LB
 type HeartbeatPayload struct {
 	NodeID       string `json:"node_id"`       // DN's full url -> "http://192.168.1.15:9001"
 	ActiveWrites int    `json:"active_writes"` // load tracked by the atomic counter
+	CorruptChunks []string `json:"corrupt_chunks,omitempty"` // chunks the DN found corrupt and quarantined since the last acknowledged heartbeat
+}
+
+// HeartbeatResponse is what the LB answers to a heartbeat
+type HeartbeatResponse struct {
+	Success bool     `json:"success"`
+	Peers   []string `json:"peers"` // every DN the LB knows, the only ones a DN forwards pipelined writes to
+}
+
+// ErasureCoding is the layout of an erasure coded file -> its chunks are grouped into stripes of DataShards chunks
+// (the last one can be shorter) and every stripe gets ParityShards parity chunks, every shard is stored once, on its own DN
+// any DataShards shards of a stripe are enough to rebuild the others
+type ErasureCoding struct {
+	DataShards   int `json:"data_shards"`
+	ParityShards int `json:"parity_shards"`
+}
+
+// StripeStruct is the parity of one stripe of an erasure coded file
+// stripe i covers chunks i*DataShards up to (i+1)*DataShards, all its shards are as big as its biggest chunk:
+// smaller chunks, and the chunks a short last stripe doesnt have, are zero padded for the encoding
+type StripeStruct struct {
+	Index  int           `json:"index"`
+	Parity []ChunkStruct `json:"parity"` // ChunkIndex is the number of the parity shard, 0 up to ParityShards
+}
+
+// the chunkers a client can cut files with, see Chunking
+const (
+	ChunkerFixed   = "fixed"   // every chunk is MaxSize bytes, except the last one
+	ChunkerFastCDC = "fastcdc" // content defined -> chunks end where a rolling hash of the data says so, between MinSize and MaxSize bytes, AvgSize on average
+)
+
+// Chunking is how a file was cut into chunks, it is recorded with the file so stats (and anybody re-uploading the data
+// to dedup against it) know. fixed chunking has all three sizes at the chunk size
+// content defined chunks keep their boundaries when bytes are inserted or removed before them, so an edited file still shares most chunks with its old version
+type Chunking struct {
+	Algorithm string `json:"algorithm"`
+	MinSize   int    `json:"min_size"`
+	AvgSize   int    `json:"avg_size"`
+	MaxSize   int    `json:"max_size"`
+}
+
+// CodecGzip is the only codec compressed chunks use for now
+// the client compresses each chunk on its own (and keeps it raw if that doesnt pay off), so every chunk can be read without the others
+const CodecGzip = "gzip"
+
+// CipherAES256GCM is the only cipher encrypted files use for now
+const CipherAES256GCM = "aes-256-gcm"
+
+// Encryption is what a client needs (on top of its master key) to decrypt an encrypted file
+// every file has its own random data key, stored wrapped (sealed) with the master key, the cluster never sees either key in the clear.
+// chunk i is sealed with the data key and the nonce Nonce with i xored into its last 8 bytes, so its stored size is 16 bytes
+// (the GCM tag) more than its data and its chunk ID is the sha1 of the sealed bytes
+type Encryption struct {
+	Cipher     string `json:"cipher"`
+	WrappedKey []byte `json:"wrapped_key"` // nonce + the data key sealed with the master key
+	Nonce      []byte `json:"nonce"`       // the file's nonce base
+	KeyID      string `json:"key_id"`      // fingerprint of the master key that wrapped the data key, to tell a wrong key apart from corrupt data
+}
+
+// ClientChunk is one entry of the chunk list the client sends to the LB
+type ClientChunk struct {
+	ChunkID string `json:"chunk_id"`
+	Index   int    `json:"index"`
+	Size    int64  `json:"size"`
+	Codec   string `json:"codec,omitempty"`    // compressed chunks -> CodecGzip
+	RawSize int64  `json:"raw_size,omitempty"` // compressed chunks -> the size before compression
+}
+
+// ClientUploadRequest is what the client POSTs to the LB's /uploadFile
+type ClientUploadRequest struct {
+	FileName    string        `json:"filename"`
+	Chunks      []ClientChunk `json:"chunks"`
+	Replication int           `json:"replication,omitempty"` // replicas every chunk of the file keeps, 0 -> the LB's -replication
+	// an erasure coded file sends its layout and the parity chunks of every stripe (no locations), Replication is ignored then
+	Erasure *ErasureCoding `json:"erasure,omitempty"`
+	Stripes []StripeStruct `json:"stripes,omitempty"`
+	// a named storage policy (see policy.go), it decides Replication, an erasure coded one needs Erasure to match it
+	Policy string `json:"policy,omitempty"`
+	Chunking *Chunking `json:"chunking,omitempty"` // how the chunks were cut, recorded with the file
+	// encrypted files send their wrapped key and nonce, and the size of the data (the chunks are bigger, see Encryption)
+	Encryption *Encryption `json:"encryption,omitempty"`
+	Size       int64       `json:"size,omitempty"`
+}
+
+// UploadPlanResponse is the LB's answer to /uploadFile
+type UploadPlanResponse struct {
+	Success    bool                `json:"success"`
+	SessionID  string              `json:"session_id"` // the upload session, the file only shows up once the client commits it
+	UploadPlan map[string][]string `json:"upload_plan"` // chunkID -> [DN_URL, ...], parity chunks included
+	// chunks the cluster already has (from this or another file), the client doesnt need to upload these
+	AlreadyStored []string `json:"already_stored"`
+}
+
+// UploadSessionRequest is what the client POSTs to the LB's /ackChunks, /commitUpload and /abortUpload
+type UploadSessionRequest struct {
+	SessionID   string        `json:"session_id"`
+	Chunks      []ChunkStruct `json:"chunks,omitempty"`       // /ackChunks and /commitUpload -> chunks with the replicas that acknowledged storing them
+	MinReplicas int           `json:"min_replicas,omitempty"` // set by the LB on /commitUpload, whatever the client sends is overwritten
+}
+
+// PipelineHeader carries the rest of a write pipeline -> "http://localhost:9002,http://localhost:9003"
+// the DN that gets a chunk with this header stores it, forwards it to the first url with the others left in the header,
+// and only answers once the whole chain has persisted it
+const PipelineHeader = "X-Foodo-Pipeline"
+
+// metadata reads on the namenodes answer with the raft index their FSM had applied (AppliedIndexHeader), and stale reads
+// from a follower with how many milliseconds ago it last heard from the leader (LastContactHeader)
+const AppliedIndexHeader = "X-Foodo-Applied-Index"
+const LastContactHeader = "X-Foodo-Last-Contact"
+
+// how fresh a metadata read has to be, the ?consistency= of /stat, /ls, /files and /get-metadata (see namenode/consistency.go)
+const (
+	ConsistencyLinearizable = "linearizable" // the leader confirms it still leads and has applied everything committed
+	ConsistencyLease        = "lease"        // the leader answers straight away while a quorum recently confirmed it, the default
+	ConsistencyStale        = "stale"        // any namenode answers from what it has applied so far
+)
+
+// WriteChunkResponse is what a DN answers to /writeChunk
+type WriteChunkResponse struct {
+	Success bool     `json:"success"`
+	Stored  []string `json:"stored"` // every DN (this one and the ones down the pipeline) that persisted the chunk
+	Error   string   `json:"error,omitempty"`
+}
+
+// BlockReport is the full list of chunks a DN has on disk, it sends one to the LB on startup and then periodically
+type BlockReport struct {
+	NodeID string            `json:"node_id"`
+	Chunks []BlockReportItem `json:"chunks"`
+}
+
+type BlockReportItem struct {
+	ChunkID string `json:"chunk_id"`
+	Size    int64  `json:"size"`
+}
+
+// BadReplica is a replica that failed a client during a download, the client reports them to the LB's /reportBadReplicas
+type BadReplica struct {
+	ChunkID string `json:"chunk_id"`
+	NodeID  string `json:"node_id"`
+	Reason  string `json:"reason"` // "corrupt", "missing", "unreachable" or "error"
 }